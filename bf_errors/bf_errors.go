@@ -13,6 +13,14 @@ const (
 	SyntaxError
 	StackOverflowError
 	StackUnderflowError
+	CellOverflowError
+	CellUnderflowError
+	EndOfInputError
+	StepLimitError
+	TimeoutError
+	OutputLimitError
+	CanceledError
+	PermissionError
 )
 
 type RuntimeError struct {
@@ -76,14 +84,46 @@ func (err RuntimeError) String() string {
 		result += "Stack overflow:"
 	case StackUnderflowError:
 		result += "Stack underflow:"
+	case CellOverflowError:
+		result += "Cell overflow:"
+	case CellUnderflowError:
+		result += "Cell underflow:"
+	case EndOfInputError:
+		result += "End of input:"
+	case StepLimitError:
+		result += "Step limit reached:"
+	case TimeoutError:
+		result += "Timed out:"
+	case OutputLimitError:
+		result += "Output limit reached:"
+	case CanceledError:
+		result += "Canceled:"
+	case PermissionError:
+		result += "Permission denied:"
 	}
 
-	result += fmt.Sprintf("\t'%s' at line %d column %d in %s\n", err.Reason.Error(), err.Position.Line, err.Position.Column, err.FileName)
+	result += fmt.Sprintf("\t'%s' at line %d column %d in %s\n", err.reason(), err.Position.Line, err.Position.Column, err.FileName)
 	result += fmt.Sprintf("\t%s %d:%d\n", err.FilePath, err.Position.Line, err.Position.Column)
 
 	return result
 }
 
+func (err RuntimeError) Error() string {
+	return fmt.Sprintf("%s %d:%d: %s", err.FilePath, err.Position.Line, err.Position.Column, err.reason())
+}
+
+// reason is the text of the reason, EmptyError has none.
+func (err RuntimeError) reason() string {
+	if err.Reason == nil {
+		return "no error"
+	}
+	return err.Reason.Error()
+}
+
+func (err RuntimeError) Unwrap() error {
+	return err.Reason
+}
+
 func (err RuntimeError) Write(w io.Writer) {
 	w.Write([]byte(err.String()))
 }
@@ -0,0 +1,12 @@
+package bf_errors
+
+import "testing"
+
+func TestEmptyError(t *testing.T) {
+	if message := EmptyError.Error(); message != " 0:0: no error" {
+		t.Errorf("Expected the empty error to have no reason found '%s'", message)
+	}
+	if EmptyError.String() == "" || EmptyError.Unwrap() != nil {
+		t.Errorf("Expected the empty error to print without unwrapping to anything")
+	}
+}
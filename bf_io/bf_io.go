@@ -2,7 +2,9 @@ package bf_io
 
 import (
 	"bufio"
+	"context"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"path"
@@ -40,9 +42,27 @@ func (io *RuntimeIO) Init(value RuntimeIO) *RuntimeIO {
 	return io
 }
 
+// Rewind moves the file under a file target back over what its reader
+// buffered and nobody read yet, so that a write lands where reading stopped.
+func (target *RuntimeIO) Rewind() error {
+	file, ok := target.In.(*os.File)
+	if !ok || target.Reader.Buffered() == 0 {
+		return nil
+	}
+
+	if _, err := file.Seek(-int64(target.Reader.Buffered()), io.SeekCurrent); err != nil {
+		return err
+	}
+	target.Reader.Reset(file)
+	return nil
+}
+
 type IOSourceList struct {
 	File string
 	Http string
+	Tcp  string
+	// TcpDial connects to the tcp address instead of listening on it
+	TcpDial bool
 }
 
 func FileIO(fileName string) (RuntimeIO, func() error, error) {
@@ -107,6 +127,116 @@ func HttpIO(port string, file_resource string, io_targets *[]RuntimeIO, waiters
 	return srv
 }
 
+// TcpIO serves one tcp connection at a time. When listening, clients that
+// connect while another one is being served are queued and served in the
+// order they connected. When dialing, a new connection is dialed whenever
+// the previous one has ended.
+type TcpIO struct {
+	address     string
+	dial        bool
+	listener    net.Listener
+	connections chan net.Conn
+	current     net.Conn
+	// runtime is kept with the connection so that switching away from tcp
+	// and back doesn't lose buffered input
+	runtime RuntimeIO
+}
+
+func NewTcpIO(address string, dial bool) (*TcpIO, error) {
+	t := &TcpIO{address: address, dial: dial}
+
+	if dial {
+		return t, nil
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	t.listener = listener
+	t.connections = make(chan net.Conn, 16)
+
+	go func() {
+		defer close(t.connections)
+
+		for {
+			connection, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			t.connections <- connection
+		}
+	}()
+
+	return t, nil
+}
+
+// Addr is the address the tcp io is listening on or dialing to.
+func (t *TcpIO) Addr() string {
+	if t.listener != nil {
+		return t.listener.Addr().String()
+	}
+	return t.address
+}
+
+// Connection returns the connection that is being served, waiting for the
+// next one if there isn't any. It gives up with the error of ctx when ctx
+// is done first.
+func (t *TcpIO) Connection(ctx context.Context) (RuntimeIO, error) {
+	if t.current != nil {
+		return t.runtime, nil
+	}
+
+	if t.dial {
+		var dialer net.Dialer
+		connection, err := dialer.DialContext(ctx, "tcp", t.address)
+		if err != nil {
+			return t.runtime, err
+		}
+		t.current = connection
+	} else {
+		select {
+		case connection, ok := <-t.connections:
+			if !ok {
+				return t.runtime, net.ErrClosed
+			}
+			t.current = connection
+		case <-ctx.Done():
+			return t.runtime, ctx.Err()
+		}
+	}
+
+	io := RuntimeIO{
+		Out: t.current,
+		Err: os.Stderr,
+		In:  t.current,
+	}
+	t.runtime = *io.Init(io)
+
+	return t.runtime, nil
+}
+
+// End closes the connection that is being served.
+func (t *TcpIO) End() error {
+	if t.current == nil {
+		return nil
+	}
+
+	err := t.current.Close()
+	t.current = nil
+	return err
+}
+
+func (t *TcpIO) Close() error {
+	t.End()
+
+	if t.listener != nil {
+		return t.listener.Close()
+	}
+	return nil
+}
+
 type IOTargetType = string
 
 var (
@@ -0,0 +1,143 @@
+package compile
+
+import "text/template"
+
+var cLanguage = language{
+	indent:   "\t",
+	loop:     "while (t[p]) {",
+	guard:    "if (t[p]) {",
+	end:      "}",
+	add:      "t[p] += %d;",
+	setZero:  "t[p] = 0;",
+	clear:    "memset(t, 0, sizeof t);",
+	move:     "p += %d;",
+	check:    "if (%s) fault(%d, p);",
+	multiply: "t[%s] += t[p] * %d;",
+	output:   "output(t[p]);",
+	input:    "t[p] = input();",
+	switchIO: "switch_io(%s, %d, %d);",
+	source:   template.Must(template.New("c").Funcs(functions).Parse(cSource)),
+}
+
+const cSource = `// Code generated by bfi compile from {{.Name}}. DO NOT EDIT.
+
+#include <errno.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+#define TAPE_SIZE {{.TapeSize}}
+
+static const char *file_path = {{quote .File}};
+static const char *file_name = {{quote .Name}};
+static const char *io_file = {{quote .IOFile}};
+
+struct origin {
+	int delta, line, column;
+};
+
+static const struct origin origins[] = {
+{{- range .Faults}}{{range .}}
+	{ {{- .Delta}}, {{.Line}}, {{.Column -}} },
+{{- end}}{{end}}
+	{0, 0, 0},
+};
+
+static const int faults[][2] = {
+{{- $start := 0}}{{range .Faults}}
+	{ {{- $start}}, {{len .}}},{{$start = add $start (len .)}}
+{{- end}}
+	{0, 0},
+};
+
+static unsigned char t[TAPE_SIZE];
+static FILE *in, *out, *io;
+/* reads and writes to io file share its position, it has to be seeked
+   between the two */
+static int writing;
+
+static _Noreturn void fail(const char *type, const char *reason, int line, int column) {
+	fflush(out);
+	fprintf(stderr, "%s\t'%s' at line %d column %d in %s\n\t%s %d:%d\n", type, reason, line, column, file_name, file_path, line, column);
+	exit(1);
+}
+
+static _Noreturn void fault(int id, long p) {
+	for (int i = faults[id][0]; i < faults[id][0] + faults[id][1]; i++) {
+		p += origins[i].delta;
+		if (p >= 0 && p < TAPE_SIZE) {
+			continue;
+		}
+
+		if (origins[i].delta > 0) {
+			fail("Stack overflow:", "stack overflow", origins[i].line, origins[i].column);
+		}
+		fail("Stack underflow:", "stack overflow", origins[i].line, origins[i].column);
+	}
+
+	/* the check before a fault makes sure some symbol moves off the tape */
+	abort();
+}
+
+static void output(unsigned char c) {
+	if (out == io && !writing) {
+		fseek(io, 0, SEEK_CUR);
+		writing = 1;
+	}
+	putc(c, out);
+}
+
+static unsigned char input(void) {
+	if (in == io && writing) {
+		fseek(io, 0, SEEK_CUR);
+		writing = 0;
+	}
+	if (out == stdout) {
+		fflush(stdout);
+	}
+
+	int c = getc(in);
+	return c == EOF ? 0 : c;
+}
+
+static void switch_io(const char *target, int line, int column) {
+	char reason[256];
+
+	fflush(out);
+	if (strcmp(target, "std") == 0) {
+		in = stdin;
+		out = stdout;
+	} else if (strcmp(target, "file") == 0) {
+		if (io != NULL) {
+			fclose(io);
+		}
+		io = fopen(io_file, "r+");
+		if (io == NULL && errno == ENOENT) {
+			io = fopen(io_file, "w+");
+		}
+		if (io == NULL) {
+			snprintf(reason, sizeof reason, "open %s: %s", io_file, strerror(errno));
+			fail("Program threw an error:\n", reason, line, column);
+		}
+		in = out = io;
+		writing = 0;
+	} else {
+		snprintf(reason, sizeof reason, "io %s is not supported by compiled programs", target);
+		fail("Program threw an error:\n", reason, line, column);
+	}
+}
+
+int main(void) {
+	long p = 0;
+	in = stdin;
+	out = stdout;
+	(void)fault;
+	(void)output;
+	(void)input;
+	(void)switch_io;
+
+{{.Body}}
+	fflush(out);
+	return 0;
+}
+`
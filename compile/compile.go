@@ -0,0 +1,291 @@
+// Package compile turns brainfuck programs into C, Go or JavaScript source,
+// or into WebAssembly modules, that run them the way engine.Run does with its default options: a
+// bounded tape of 8 bit cells that wrap around, stack overflow and
+// underflow errors at the symbol that moves off the tape and zero once the
+// input has ended.
+//
+// The program is compiled through ir first, so the generated source is
+// folded the same way the virtual machine's bytecode is.
+package compile
+
+import (
+	"fmt"
+	"go/format"
+	"go/token"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/CanPacis/brainfuck-interpreter/bf_errors"
+	"github.com/CanPacis/brainfuck-interpreter/engine"
+	"github.com/CanPacis/brainfuck-interpreter/ir"
+	"github.com/CanPacis/brainfuck-interpreter/parser"
+)
+
+// Target is the language a program is compiled to.
+type Target string
+
+const (
+	// C is a program for any C11 compiler that reads stdin and writes
+	// stdout
+	C Target = "c"
+	// Go is a package with a Run(in io.Reader, out io.Writer) error function
+	Go Target = "go"
+	// JS is a script for node
+	JS Target = "js"
+	// Wasm is a WebAssembly module that imports its io from the host
+	Wasm Target = "wasm"
+	// WASI is a WebAssembly module for WASI runtimes
+	WASI Target = "wasi"
+)
+
+// Targets are all the targets in the order they are documented.
+var Targets = []Target{C, Go, JS, Wasm, WASI}
+
+type Options struct {
+	Target Target
+	// File is the path of the program, errors are reported against it
+	File string
+	// TapeSize is the number of cells, it defaults to engine.DefaultTapeSize
+	TapeSize int
+	// IOFile is what io file reads and writes, it defaults to io.txt like
+	// it does for the engine
+	IOFile string
+	// Package is the name of the generated Go package, it defaults to the
+	// name of the file
+	Package string
+}
+
+// origin is a symbol a fault walks through to find the one that moved off
+// the tape.
+type origin struct {
+	Delta, Line, Column int
+}
+
+// unit is what the templates of a language are executed with.
+type unit struct {
+	Options
+	Name   string
+	Faults [][]origin
+	Body   string
+}
+
+// language is how a target spells each instruction. Statement formats get
+// the cell index or the value of their instruction.
+type language struct {
+	indent           string
+	loop, guard, end string
+	add, setZero     string
+	clear            string
+	move             string
+	// check gets a condition and a fault id
+	check    string
+	multiply string
+	output   string
+	input    string
+	// switchIO gets the quoted target, the line and the column
+	switchIO string
+	source   *template.Template
+}
+
+var languages = map[Target]language{
+	C:  cLanguage,
+	Go: goLanguage,
+	JS: jsLanguage,
+}
+
+type generator struct {
+	language
+	tapeSize int
+	faults   [][]origin
+	b        strings.Builder
+	depth    int
+}
+
+func (g *generator) line(format string, args ...interface{}) {
+	g.b.WriteString(strings.Repeat(g.indent, g.depth+1))
+	fmt.Fprintf(&g.b, format, args...)
+	g.b.WriteByte('\n')
+}
+
+// index is the cell at offset from the cursor.
+func index(offset int) string {
+	switch {
+	case offset > 0:
+		return fmt.Sprintf("p + %d", offset)
+	case offset < 0:
+		return fmt.Sprintf("p - %d", -offset)
+	}
+	return "p"
+}
+
+// bounds checks that the cursor stays on the tape when it moves between
+// low and high cells from where it is, the fault finds the symbol that
+// moved off it when it doesn't.
+func (g *generator) bounds(low, high int, moves []ir.Instruction) {
+	conditions := []string{}
+	if low < 0 {
+		conditions = append(conditions, fmt.Sprintf("p < %d", -low))
+	}
+	if high > 0 {
+		conditions = append(conditions, fmt.Sprintf("p >= %d", g.tapeSize-high))
+	}
+	if len(conditions) == 0 {
+		return
+	}
+
+	fault := []origin{}
+	for _, move := range moves {
+		if move.Op != ir.Move {
+			continue
+		}
+		for _, o := range move.Origins {
+			fault = append(fault, origin{Delta: o.Delta, Line: int(o.Line), Column: int(o.Column)})
+		}
+	}
+
+	g.line(g.check, strings.Join(conditions, " || "), len(g.faults))
+	g.faults = append(g.faults, fault)
+}
+
+func (g *generator) generate(instructions []ir.Instruction) {
+	for _, instruction := range instructions {
+		switch instruction.Op {
+		case ir.Add:
+			if value := instruction.Value & 0xff; value != 0 {
+				g.line(g.add, value)
+			}
+		case ir.Move:
+			if instruction.Value != 0 || instruction.Min != 0 || instruction.Max != 0 {
+				g.bounds(instruction.Min, instruction.Max, []ir.Instruction{instruction})
+			}
+			if instruction.Value != 0 {
+				g.line(g.move, instruction.Value)
+			}
+		case ir.SetZero:
+			g.line(g.setZero)
+		case ir.Clear:
+			g.line(g.clear)
+		case ir.Output:
+			g.line(g.output)
+		case ir.Input:
+			g.line(g.input)
+		case ir.SwitchIO:
+			g.line(g.switchIO, fmt.Sprintf("%q", instruction.IOTarget), instruction.Range.Start.Line, instruction.Range.Start.Column)
+		case ir.Multiply:
+			g.line(g.guard)
+			g.depth++
+			g.bounds(instruction.Min, instruction.Max, instruction.Body)
+			for _, term := range instruction.Terms {
+				g.line(g.multiply, index(term.Offset), term.Factor&0xff)
+			}
+			g.line(g.setZero)
+			g.depth--
+			g.line(g.end)
+		case ir.Scan:
+			g.line(g.loop)
+			g.depth++
+			g.bounds(min(instruction.Value, 0), max(instruction.Value, 0), instruction.Body)
+			g.line(g.move, instruction.Value)
+			g.depth--
+			g.line(g.end)
+		case ir.Loop:
+			g.line(g.loop)
+			g.depth++
+			g.generate(instruction.Body)
+			g.depth--
+			g.line(g.end)
+		}
+	}
+}
+
+// Compile compiles a parsed program to the source of the target, or to the
+// binary module of a WebAssembly target. Compiled programs only switch to
+// io std and io file, the wasm host being the exception that decides what
+// every target means, so switching to another one is returned as a
+// bf_errors.RuntimeError at the switch.
+func Compile(program []parser.Statement, options Options) ([]byte, error) {
+	language, ok := languages[options.Target]
+	if !ok && options.Target != Wasm && options.Target != WASI {
+		return nil, fmt.Errorf("unknown target '%s'", options.Target)
+	}
+
+	if len(options.File) == 0 {
+		options.File = "<source>"
+	}
+	if options.TapeSize == 0 {
+		options.TapeSize = engine.DefaultTapeSize
+	}
+	if options.TapeSize < 0 {
+		return nil, fmt.Errorf("compiled programs need a bounded tape, %d cells is not one", options.TapeSize)
+	}
+	if len(options.IOFile) == 0 {
+		options.IOFile = "io.txt"
+	}
+	if len(options.Package) == 0 {
+		options.Package = packageName(options.File)
+	}
+
+	if options.Target != Wasm {
+		for _, statement := range parser.IOTargets(program) {
+			if statement.IOTarget != "std" && statement.IOTarget != "file" {
+				return nil, bf_errors.CreateUncaughtError(fmt.Errorf("io %s is not supported by compiled programs", statement.IOTarget), statement.Position, options.File)
+			}
+		}
+	}
+
+	if options.Target == Wasm || options.Target == WASI {
+		return compileWasm(ir.Compile(program, ir.Options{}), options), nil
+	}
+
+	g := &generator{language: language, tapeSize: options.TapeSize}
+	g.generate(ir.Compile(program, ir.Options{}))
+
+	var source strings.Builder
+	err := language.source.Execute(&source, unit{
+		Options: options,
+		Name:    path.Base(options.File),
+		Faults:  g.faults,
+		Body:    g.b.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Target == Go {
+		return format.Source([]byte(source.String()))
+	}
+	return []byte(source.String()), nil
+}
+
+// packageName makes a Go package name out of the name of a file.
+func packageName(file string) string {
+	name := strings.TrimSuffix(path.Base(file), path.Ext(file))
+
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z', r == '_', r >= '0' && r <= '9' && b.Len() > 0:
+			b.WriteRune(r)
+		case b.Len() > 0:
+			b.WriteByte('_')
+		}
+	}
+
+	if b.Len() == 0 {
+		return "program"
+	}
+	if token.IsKeyword(b.String()) {
+		return b.String() + "_"
+	}
+	return b.String()
+}
+
+var functions = template.FuncMap{
+	"quote": func(s string) string {
+		return fmt.Sprintf("%q", s)
+	},
+	"add": func(a, b int) int {
+		return a + b
+	},
+}
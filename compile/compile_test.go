@@ -0,0 +1,364 @@
+package compile
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/CanPacis/brainfuck-interpreter/bf_errors"
+	"github.com/CanPacis/brainfuck-interpreter/bf_io"
+	"github.com/CanPacis/brainfuck-interpreter/engine"
+	"github.com/CanPacis/brainfuck-interpreter/parser"
+)
+
+type program struct {
+	source   string
+	stdin    string
+	tapeSize int
+	// ioFile is the io file of the engine, compiled programs run next to
+	// it so that they find it as io.txt
+	ioFile string
+}
+
+var programs = []program{
+	{source: "++++++++[>++++[>++>+++>+++>+<<<<-]>+>+>->>+[<]<-]>>.>---.+++++++..+++.>>.<-.<.+++.------.--------.>>+.>++."},
+	{source: ",[.,]", stdin: "echo"},
+	{source: "+++[->++>+++<<]>>."},
+	{source: ">+>+>+[<]>."},
+	{source: "+++*.>"},
+	{source: "io std\n+++."},
+	{source: "+>>>", tapeSize: 2},
+	{source: "+\n<", tapeSize: 2},
+	{source: "+[>+]", tapeSize: 5},
+	{source: "+[>]", tapeSize: 5},
+	{source: "+[->+<]<", tapeSize: 5},
+}
+
+type result struct {
+	stdout, stderr string
+	status         int
+}
+
+// run runs a program on the engine, the error is what Run returned.
+func run(p program) (result, error) {
+	var stdout, stderr bytes.Buffer
+	status, err := engine.NewEngineFromSource(p.source, engine.EngineOptions{
+		FilePath: "test.bf",
+		TapeSize: p.tapeSize,
+		Stdin:    strings.NewReader(p.stdin),
+		Stdout:   &stdout,
+		Stderr:   &stderr,
+		IOSourceList: bf_io.IOSourceList{
+			File: p.ioFile,
+		},
+	}).Run()
+
+	return result{stdout.String(), stderr.String(), status}, err
+}
+
+func compile(t *testing.T, p program, target Target) []byte {
+	parsed := parser.NewParser("test.bf")
+	if err := parsed.Parse(p.source); err.Reason != nil {
+		t.Fatal(err)
+	}
+
+	source, err := Compile(parsed.Program, Options{Target: target, File: "test.bf", TapeSize: p.tapeSize, Package: "program"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return source
+}
+
+func execute(t *testing.T, p program, name string, args ...string) result {
+	var stdout, stderr bytes.Buffer
+	command := exec.Command(name, args...)
+	command.Stdin = strings.NewReader(p.stdin)
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+	if len(p.ioFile) > 0 {
+		command.Dir = filepath.Dir(p.ioFile)
+	}
+
+	err := command.Run()
+	var exitError *exec.ExitError
+	if err != nil && !errors.As(err, &exitError) {
+		t.Fatal(err)
+	}
+
+	return result{stdout.String(), stderr.String(), command.ProcessState.ExitCode()}
+}
+
+func compare(t *testing.T, target Target, p program, expected, found result) {
+	if found != expected {
+		t.Errorf("Expected %q to run the same in %s\nexpected %+v\nfound    %+v", p.source, target, expected, found)
+	}
+}
+
+// examples are the programs in bf that don't serve http and a program that
+// reads io.txt, writes to it and reads it again, all of them with io.txt in
+// dir as their io file.
+func examples(t *testing.T, dir string) []program {
+	ioFile := filepath.Join(dir, "io.txt")
+
+	examples := []program{}
+	for _, name := range []string{"add.bfi", "hello_world.bfi", "message.bfi"} {
+		source, err := os.ReadFile(filepath.Join("..", "bf", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		examples = append(examples, program{source: string(source), ioFile: ioFile})
+	}
+
+	return append(examples, program{source: "io file\n,>,+.io file\n,>,>,io std\n<<.>.>.", ioFile: ioFile})
+}
+
+// reset puts back the io file every run starts with.
+func reset(p program) {
+	if len(p.ioFile) > 0 {
+		os.WriteFile(p.ioFile, []byte("from io.txt"), 0644)
+	}
+}
+
+// expect runs a program on the engine from a fresh io file and resets it
+// again for the compiled program.
+func expect(p program) (result, error) {
+	reset(p)
+	expected, err := run(p)
+	reset(p)
+	return expected, err
+}
+
+func TestC(t *testing.T) {
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("there is no c compiler")
+	}
+
+	dir := t.TempDir()
+	for i, p := range append(programs, examples(t, dir)...) {
+		source := filepath.Join(dir, fmt.Sprintf("%d.c", i))
+		binary := filepath.Join(dir, fmt.Sprint(i))
+		os.WriteFile(source, compile(t, p, C), 0644)
+
+		if output, err := exec.Command(cc, "-std=c11", "-O2", "-Wall", "-Werror", "-o", binary, source).CombinedOutput(); err != nil {
+			t.Fatalf("Failed to compile %q: %s", p.source, output)
+		}
+
+		expected, _ := expect(p)
+		compare(t, C, p, expected, execute(t, p, binary))
+	}
+}
+
+func TestJS(t *testing.T) {
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("there is no node")
+	}
+
+	dir := t.TempDir()
+	for i, p := range append(programs, examples(t, dir)...) {
+		script := filepath.Join(dir, fmt.Sprintf("%d.js", i))
+		os.WriteFile(script, compile(t, p, JS), 0644)
+
+		expected, _ := expect(p)
+		compare(t, JS, p, expected, execute(t, p, node, script))
+	}
+}
+
+// goMain runs the program package given as its argument and prints the
+// error it returns, which is the error engine.Run returns.
+const goMain = `package main
+
+import (
+	"fmt"
+	"os"
+%s)
+
+func main() {
+	programs := map[string]func() error{
+%s	}
+
+	if err := programs[os.Args[1]](); err != nil {
+		fmt.Fprint(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+`
+
+func TestGo(t *testing.T) {
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("there is no go tool")
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module programs\n\ngo 1.21\n"), 0644)
+
+	cases := append(programs, examples(t, dir)...)
+	var imports, calls strings.Builder
+	for i, p := range cases {
+		os.Mkdir(filepath.Join(dir, fmt.Sprintf("p%d", i)), 0755)
+		os.WriteFile(filepath.Join(dir, fmt.Sprintf("p%d", i), "program.go"), compile(t, p, Go), 0644)
+
+		fmt.Fprintf(&imports, "\tp%d \"programs/p%d\"\n", i, i)
+		fmt.Fprintf(&calls, "\t\t\"%d\": func() error { return p%d.Run(os.Stdin, os.Stdout) },\n", i, i)
+	}
+	os.WriteFile(filepath.Join(dir, "main.go"), []byte(fmt.Sprintf(goMain, imports.String(), calls.String())), 0644)
+
+	build := exec.Command(goTool, "build", "-o", "programs", ".")
+	build.Dir = dir
+	build.Env = append(os.Environ(), "GOWORK=off", "GOFLAGS=-mod=mod")
+	if output, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("Failed to build the programs: %s", output)
+	}
+
+	for i, p := range cases {
+		expected, err := expect(p)
+		if err != nil {
+			expected.stderr = err.Error()
+		}
+
+		compare(t, Go, p, expected, execute(t, p, filepath.Join(dir, "programs"), fmt.Sprint(i)))
+	}
+}
+
+// wasmHost runs a Wasm module under node, doing what switch_io asks for
+// with io.txt as io file.
+const wasmHost = `"use strict";
+const fs = require("fs");
+
+class Failure extends Error {}
+
+let memory;
+let io = null;
+let inputFd = 0;
+let outputFd = 1;
+
+const imports = {
+  bf: {
+    output(c) {
+      fs.writeSync(outputFd, Buffer.of(c));
+    },
+    input() {
+      const c = Buffer.alloc(1);
+      try {
+        return fs.readSync(inputFd, c, 0, 1, null) === 1 ? c[0] : -1;
+      } catch (error) {
+        if (error.code === "EOF") {
+          return -1;
+        }
+        throw error;
+      }
+    },
+    switch_io(target, line, column) {
+      if (io !== null) {
+        fs.closeSync(io);
+        io = null;
+      }
+      if (target === 0) {
+        inputFd = 0;
+        outputFd = 1;
+      } else if (target === 1) {
+        io = fs.openSync("io.txt", fs.constants.O_RDWR | fs.constants.O_CREAT, 0o644);
+        inputFd = outputFd = io;
+      } else {
+        throw new Error("unsupported io target " + target + " at " + line + ":" + column);
+      }
+    },
+    fail(type, line, column, message, length) {
+      process.stderr.write(Buffer.from(memory.buffer, message, length));
+      throw new Failure();
+    },
+  },
+};
+
+const wasm = new WebAssembly.Module(fs.readFileSync(process.argv[2]));
+const instance = new WebAssembly.Instance(wasm, imports);
+memory = instance.exports.memory;
+try {
+  instance.exports.run();
+} catch (error) {
+  if (!(error instanceof Failure)) {
+    throw error;
+  }
+  process.exitCode = 1;
+}
+`
+
+// wasiHost runs a WASI module under node with the working directory as
+// its first preopened directory.
+const wasiHost = `"use strict";
+const fs = require("fs");
+const { WASI } = require("wasi");
+
+const wasi = new WASI({ version: "preview1", preopens: { "/": "." }, returnOnExit: true });
+const wasm = new WebAssembly.Module(fs.readFileSync(process.argv[2]));
+process.exitCode = wasi.start(new WebAssembly.Instance(wasm, wasi.getImportObject()));
+`
+
+func testWasm(t *testing.T, target Target, host string) {
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("there is no node")
+	}
+
+	dir := t.TempDir()
+	script := filepath.Join(dir, "host.js")
+	os.WriteFile(script, []byte(host), 0644)
+
+	for i, p := range append(programs, examples(t, dir)...) {
+		module := filepath.Join(dir, fmt.Sprintf("%d.wasm", i))
+		os.WriteFile(module, compile(t, p, target), 0644)
+
+		expected, _ := expect(p)
+		compare(t, target, p, expected, execute(t, p, node, "--no-warnings", script, module))
+	}
+}
+
+func TestWasm(t *testing.T) {
+	testWasm(t, Wasm, wasmHost)
+}
+
+func TestWASI(t *testing.T) {
+	testWasm(t, WASI, wasiHost)
+}
+
+func TestPackageName(t *testing.T) {
+	names := map[string]string{
+		"bf/hello_world.bfi": "hello_world",
+		"Hello-World.bf":     "hello_world",
+		"2048.bf":            "program",
+		"func.bf":            "func_",
+	}
+
+	for file, expected := range names {
+		if name := packageName(file); name != expected {
+			t.Errorf("Expected %s to be named %s found %s", file, expected, name)
+		}
+	}
+}
+
+func TestUnsupportedIO(t *testing.T) {
+	parsed := parser.NewParser("test.bf")
+	if err := parsed.Parse("+[io file\n-]io tcp\n.io http"); err.Reason != nil {
+		t.Fatal(err)
+	}
+
+	for _, target := range []Target{C, Go, JS, WASI} {
+		_, err := Compile(parsed.Program, Options{Target: target, File: "test.bf"})
+
+		var runtimeError bf_errors.RuntimeError
+		if !errors.As(err, &runtimeError) || runtimeError.Position.Line != 2 || runtimeError.Position.Column != 3 {
+			t.Errorf("Expected %s to fail at the io tcp switch found %v", target, err)
+		}
+	}
+
+	if _, err := Compile(parsed.Program, Options{Target: Wasm}); err != nil {
+		t.Errorf("Expected the wasm host to decide what io tcp means found %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+package compile
+
+import "text/template"
+
+var goLanguage = language{
+	indent:   "\t",
+	loop:     "for t[p] != 0 {",
+	guard:    "if t[p] != 0 {",
+	end:      "}",
+	add:      "t[p] += %d",
+	setZero:  "t[p] = 0",
+	clear:    "clear(t[:])",
+	move:     "p += %d",
+	check:    "if %s { r.fault(%d, p) }",
+	multiply: "t[%s] += t[p] * %d",
+	output:   "r.output(t[p])",
+	input:    "t[p] = r.input()",
+	switchIO: "r.switchIO(%s, %d, %d)",
+	source:   template.Must(template.New("go").Funcs(functions).Parse(goSource)),
+}
+
+const goSource = `// Code generated by bfi compile from {{.Name}}. DO NOT EDIT.
+
+// Package {{.Package}} runs {{.Name}}.
+package {{.Package}}
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	tapeSize = {{.TapeSize}}
+	filePath = {{quote .File}}
+	ioFile   = {{quote .IOFile}}
+)
+
+// Error is a runtime error of the program, Type is one of "stack
+// overflow", "stack underflow" or "uncaught".
+type Error struct {
+	Type   string
+	Reason string
+	Line   int
+	Column int
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("%s %d:%d: %s", filePath, err.Line, err.Column, err.Reason)
+}
+
+type origin struct {
+	delta, line, column int
+}
+
+var faults = [][]origin{
+{{- range .Faults}}
+	{ {{- range .}}{ {{- .Delta}}, {{.Line}}, {{.Column -}} },{{end -}} },
+{{- end}}
+}
+
+type runtime struct {
+	tape    [tapeSize]byte
+	stdin   *bufio.Reader
+	stdout  *bufio.Writer
+	in      *bufio.Reader
+	out     *bufio.Writer
+	file    *os.File
+	failure error
+}
+
+func (r *runtime) fail(err *Error) {
+	panic(err)
+}
+
+func (r *runtime) fault(id int, p int) {
+	for _, o := range faults[id] {
+		p += o.delta
+		if p >= 0 && p < tapeSize {
+			continue
+		}
+
+		if o.delta > 0 {
+			r.fail(&Error{Type: "stack overflow", Reason: "stack overflow", Line: o.line, Column: o.column})
+		}
+		r.fail(&Error{Type: "stack underflow", Reason: "stack overflow", Line: o.line, Column: o.column})
+	}
+}
+
+func (r *runtime) output(c byte) {
+	// like the engine, writes to io file land right away where reading
+	// stopped
+	if r.file != nil && r.in.Buffered() > 0 {
+		r.file.Seek(-int64(r.in.Buffered()), io.SeekCurrent)
+		r.in.Reset(r.file)
+	}
+
+	r.out.WriteByte(c)
+	if r.file != nil {
+		r.out.Flush()
+	}
+}
+
+func (r *runtime) input() byte {
+	if r.file == nil {
+		r.out.Flush()
+	}
+
+	c, err := r.in.ReadByte()
+	if err != nil {
+		return 0
+	}
+	return c
+}
+
+func (r *runtime) close() {
+	if err := r.out.Flush(); err != nil && r.failure == nil {
+		r.failure = err
+	}
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+}
+
+func (r *runtime) switchIO(target string, line, column int) {
+	r.close()
+
+	switch target {
+	case "std":
+		r.in, r.out = r.stdin, r.stdout
+	case "file":
+		file, err := os.OpenFile(ioFile, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			r.fail(&Error{Type: "uncaught", Reason: err.Error(), Line: line, Column: column})
+		}
+		r.file = file
+		r.in, r.out = bufio.NewReader(file), bufio.NewWriter(file)
+	default:
+		r.fail(&Error{Type: "uncaught", Reason: fmt.Sprintf("io %s is not supported by compiled programs", target), Line: line, Column: column})
+	}
+}
+
+// Run runs {{.Name}}, reading from in and writing to out until it
+// switches to another io target. It returns an *Error when the program
+// fails.
+func Run(in io.Reader, out io.Writer) (err error) {
+	r := &runtime{stdin: bufio.NewReader(in), stdout: bufio.NewWriter(out)}
+	r.in, r.out = r.stdin, r.stdout
+	defer func() {
+		if failure := recover(); failure != nil {
+			runtimeError, ok := failure.(*Error)
+			if !ok {
+				panic(failure)
+			}
+			err = runtimeError
+		}
+
+		r.close()
+		if err == nil {
+			err = r.failure
+		}
+	}()
+
+	t, p := &r.tape, 0
+	_, _ = t, p
+
+{{.Body}}
+	return nil
+}
+`
@@ -0,0 +1,143 @@
+package compile
+
+import "text/template"
+
+var jsLanguage = language{
+	indent:   "  ",
+	loop:     "while (t[p]) {",
+	guard:    "if (t[p]) {",
+	end:      "}",
+	add:      "t[p] += %d;",
+	setZero:  "t[p] = 0;",
+	clear:    "t.fill(0);",
+	move:     "p += %d;",
+	check:    "if (%s) fault(%d, p);",
+	multiply: "t[%s] += t[p] * %d;",
+	output:   "output(t[p]);",
+	input:    "t[p] = input();",
+	switchIO: "switchIO(%s, %d, %d);",
+	source:   template.Must(template.New("js").Funcs(functions).Parse(jsSource)),
+}
+
+const jsSource = `// Code generated by bfi compile from {{.Name}}. DO NOT EDIT.
+"use strict";
+
+const fs = require("fs");
+
+const TAPE_SIZE = {{.TapeSize}};
+const filePath = {{quote .File}};
+const fileName = {{quote .Name}};
+const ioFile = {{quote .IOFile}};
+
+const faults = [
+{{- range .Faults}}
+  [{{range $i, $o := .}}{{if $i}}, {{end}}[{{$o.Delta}}, {{$o.Line}}, {{$o.Column}}]{{end}}],
+{{- end}}
+];
+
+const t = new Uint8Array(TAPE_SIZE);
+const buffer = Buffer.alloc(4096);
+let buffered = 0;
+let input_fd = 0;
+let output_fd = 1;
+let io = null;
+
+class Failure {
+  constructor(type, reason, line, column) {
+    this.type = type;
+    this.reason = reason;
+    this.line = line;
+    this.column = column;
+  }
+}
+
+function fault(id, p) {
+  for (const [delta, line, column] of faults[id]) {
+    p += delta;
+    if (p >= 0 && p < TAPE_SIZE) {
+      continue;
+    }
+
+    throw new Failure(delta > 0 ? "Stack overflow:" : "Stack underflow:", "stack overflow", line, column);
+  }
+}
+
+function flush() {
+  let written = 0;
+  while (written < buffered) {
+    written += fs.writeSync(output_fd, buffer, written, buffered - written);
+  }
+  buffered = 0;
+}
+
+function output(c) {
+  buffer[buffered++] = c;
+  // like the engine, writes to io file land right away
+  if (buffered === buffer.length || io !== null) {
+    flush();
+  }
+}
+
+function input() {
+  flush();
+
+  const c = Buffer.alloc(1);
+  for (;;) {
+    try {
+      return fs.readSync(input_fd, c, 0, 1, null) === 1 ? c[0] : 0;
+    } catch (error) {
+      if (error.code === "EAGAIN") {
+        continue;
+      }
+      if (error.code === "EOF") {
+        return 0;
+      }
+      throw error;
+    }
+  }
+}
+
+function switchIO(target, line, column) {
+  flush();
+  if (io !== null) {
+    fs.closeSync(io);
+    io = null;
+  }
+
+  switch (target) {
+    case "std":
+      input_fd = 0;
+      output_fd = 1;
+      break;
+    case "file":
+      try {
+        io = fs.openSync(ioFile, fs.constants.O_RDWR | fs.constants.O_CREAT, 0o644);
+      } catch (error) {
+        throw new Failure("Program threw an error:\n", error.message, line, column);
+      }
+      input_fd = output_fd = io;
+      break;
+    default:
+      throw new Failure("Program threw an error:\n", "io " + target + " is not supported by compiled programs", line, column);
+  }
+}
+
+function run() {
+  let p = 0;
+
+{{.Body}}}
+
+try {
+  run();
+  flush();
+} catch (error) {
+  flush();
+  if (!(error instanceof Failure)) {
+    throw error;
+  }
+
+  const { type, reason, line, column } = error;
+  process.stderr.write(type + "\t'" + reason + "' at line " + line + " column " + column + " in " + fileName + "\n\t" + filePath + " " + line + ":" + column + "\n");
+  process.exitCode = 1;
+}
+`
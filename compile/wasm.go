@@ -0,0 +1,605 @@
+package compile
+
+import (
+	"errors"
+	"math"
+
+	"github.com/CanPacis/brainfuck-interpreter/bf_errors"
+	"github.com/CanPacis/brainfuck-interpreter/ir"
+	"github.com/CanPacis/brainfuck-interpreter/lexer"
+)
+
+// The memory of a module starts with the tape, so a cell's address is its
+// index. Scratch space for the io calls, the output buffer and the data
+// segment come after it.
+const (
+	scratchSize = 32
+	bufferSize  = 4096
+	// faultSize is the size of a fault record: the delta of the symbol and
+	// the arguments of fail for when it moves off the tape
+	faultSize = 24
+	pageSize  = 65536
+)
+
+// The host of a Wasm module tells the io targets apart by these numbers.
+var ioTargets = map[string]int32{"std": 0, "file": 1, "http": 2, "tcp": 3}
+
+const (
+	i32 byte = 0x7f
+	i64 byte = 0x7e
+)
+
+// Opcodes of the WebAssembly instructions the backend uses.
+const (
+	opUnreachable = 0x00
+	opBlock       = 0x02
+	opLoop        = 0x03
+	opIf          = 0x04
+	opEnd         = 0x0b
+	opBr          = 0x0c
+	opBrIf        = 0x0d
+	opReturn      = 0x0f
+	opCall        = 0x10
+	opDrop        = 0x1a
+	opSelect      = 0x1b
+	opLocalGet    = 0x20
+	opLocalSet    = 0x21
+	opLocalTee    = 0x22
+	opGlobalGet   = 0x23
+	opGlobalSet   = 0x24
+	opI32Load     = 0x28
+	opI32Load8U   = 0x2d
+	opI32Store    = 0x36
+	opI32Store8   = 0x3a
+	opI32Const    = 0x41
+	opI64Const    = 0x42
+	opI32Eqz      = 0x45
+	opI32LtS      = 0x48
+	opI32GeS      = 0x4e
+	opI32GeU      = 0x4f
+	opI32Add      = 0x6a
+	opI32Sub      = 0x6b
+	opI32Mul      = 0x6c
+	opI32Or       = 0x72
+	opPrefix      = 0xfc
+	opMemoryFill  = 0x0b
+	emptyBlock    = 0x40
+)
+
+// wasmCode is the encoding of a WebAssembly module or a part of one.
+type wasmCode []byte
+
+func (c wasmCode) uleb(value uint64) wasmCode {
+	for value >= 0x80 {
+		c = append(c, byte(value)|0x80)
+		value >>= 7
+	}
+	return append(c, byte(value))
+}
+
+func (c wasmCode) sleb(value int64) wasmCode {
+	for {
+		b := byte(value & 0x7f)
+		value >>= 7
+		if (value == 0 && b&0x40 == 0) || (value == -1 && b&0x40 != 0) {
+			return append(c, b)
+		}
+		c = append(c, b|0x80)
+	}
+}
+
+func (c wasmCode) name(name string) wasmCode {
+	return append(c.uleb(uint64(len(name))), name...)
+}
+
+// vector prefixes items with their count.
+func (c wasmCode) vector(items ...wasmCode) wasmCode {
+	c = c.uleb(uint64(len(items)))
+	for _, item := range items {
+		c = append(c, item...)
+	}
+	return c
+}
+
+func (c wasmCode) section(id byte, content wasmCode) wasmCode {
+	return append(append(c, id), wasmCode{}.uleb(uint64(len(content)))...).add(content)
+}
+
+func (c wasmCode) add(code wasmCode) wasmCode {
+	return append(c, code...)
+}
+
+func (c wasmCode) op(ops ...byte) wasmCode {
+	return append(c, ops...)
+}
+
+func (c wasmCode) i32(value int32) wasmCode {
+	return append(c, opI32Const).sleb(int64(value))
+}
+
+func (c wasmCode) i64(value int64) wasmCode {
+	return append(c, opI64Const).sleb(value)
+}
+
+func (c wasmCode) get(local uint32) wasmCode {
+	return append(c, opLocalGet).uleb(uint64(local))
+}
+
+func (c wasmCode) set(local uint32) wasmCode {
+	return append(c, opLocalSet).uleb(uint64(local))
+}
+
+func (c wasmCode) tee(local uint32) wasmCode {
+	return append(c, opLocalTee).uleb(uint64(local))
+}
+
+func (c wasmCode) global(op byte, global uint32) wasmCode {
+	return append(c, op).uleb(uint64(global))
+}
+
+func (c wasmCode) call(function uint32) wasmCode {
+	return append(c, opCall).uleb(uint64(function))
+}
+
+// memory is a load or a store, align is the log2 of the alignment.
+func (c wasmCode) memory(op byte, align, offset uint32) wasmCode {
+	return append(c, op).uleb(uint64(align)).uleb(uint64(offset))
+}
+
+type signature struct {
+	params, results string
+}
+
+type wasmImport struct {
+	module, name string
+	signature
+}
+
+type wasmFunction struct {
+	name string
+	signature
+	// locals are the types of the locals after the parameters
+	locals string
+	body   wasmCode
+}
+
+// wasmGenerator lowers instructions into the body of the function that
+// runs the program.
+type wasmGenerator struct {
+	options   Options
+	wasi      bool
+	imports   []wasmImport
+	functions []wasmFunction
+	// data starts at the address base
+	base uint32
+	data []byte
+	body wasmCode
+}
+
+// Locals of the function that runs the program.
+const (
+	localCursor uint32 = iota
+	localTemporary
+)
+
+// Globals of a WASI module.
+const (
+	globalBuffered uint32 = iota
+	globalIn
+	globalOut
+	globalFile
+)
+
+func (g *wasmGenerator) scratch() uint32 {
+	return uint32(g.options.TapeSize+7) &^ 7
+}
+
+func (g *wasmGenerator) buffer() uint32 {
+	return g.scratch() + scratchSize
+}
+
+// function finds the index of an imported or defined function.
+func (g *wasmGenerator) function(name string) uint32 {
+	for i, imported := range g.imports {
+		if imported.name == name {
+			return uint32(i)
+		}
+	}
+	for i, function := range g.functions {
+		if function.name == name {
+			return uint32(len(g.imports) + i)
+		}
+	}
+	panic("unknown function " + name)
+}
+
+// intern puts a string into the data segment.
+func (g *wasmGenerator) intern(s string) (int32, int32) {
+	address := g.base + uint32(len(g.data))
+	g.data = append(g.data, s...)
+	return int32(address), int32(len(s))
+}
+
+// fail calls fail with the error a statement at position fails with.
+func (g *wasmGenerator) fail(code wasmCode, typ int, reason string, position lexer.Position) wasmCode {
+	message, length := g.intern(bf_errors.CreateError(errors.New(reason), position, typ, g.options.File).String())
+	return code.
+		i32(int32(typ)).i32(int32(position.Line)).i32(int32(position.Column)).
+		i32(message).i32(length).
+		call(g.function("fail")).
+		op(opUnreachable)
+}
+
+// bounds checks that the cursor stays on the tape when it moves between
+// low and high cells from where it is, the fault records of the moves
+// find the symbol that moved off it when it doesn't.
+func (g *wasmGenerator) bounds(low, high int, moves []ir.Instruction) {
+	conditions := 0
+	if low < 0 {
+		g.body = g.body.get(localCursor).i32(int32(-low)).op(opI32LtS)
+		conditions++
+	}
+	if high > 0 {
+		g.body = g.body.get(localCursor).i32(int32(g.options.TapeSize - high)).op(opI32GeS)
+		conditions++
+	}
+	if conditions == 0 {
+		return
+	}
+	if conditions == 2 {
+		g.body = g.body.op(opI32Or)
+	}
+
+	records := []ir.Origin{}
+	for _, move := range moves {
+		if move.Op == ir.Move {
+			records = append(records, move.Origins...)
+		}
+	}
+
+	// the messages go first so that the records are contiguous
+	type message struct{ address, length int32 }
+	messages := []message{}
+	for _, origin := range records {
+		typ := bf_errors.StackUnderflowError
+		if origin.Delta > 0 {
+			typ = bf_errors.StackOverflowError
+		}
+		address, length := g.intern(bf_errors.CreateError(errors.New("stack overflow"), origin.Position, typ, g.options.File).String())
+		messages = append(messages, message{address, length})
+	}
+
+	for len(g.data)%4 != 0 {
+		g.data = append(g.data, 0)
+	}
+	table := g.base + uint32(len(g.data))
+	for i, origin := range records {
+		typ := bf_errors.StackUnderflowError
+		if origin.Delta > 0 {
+			typ = bf_errors.StackOverflowError
+		}
+		for _, value := range []int32{int32(origin.Delta), int32(typ), int32(origin.Line), int32(origin.Column), messages[i].address, messages[i].length} {
+			g.data = append(g.data, byte(value), byte(value>>8), byte(value>>16), byte(value>>24))
+		}
+	}
+
+	g.body = g.body.op(opIf, emptyBlock).
+		get(localCursor).i32(int32(table)).i32(int32(len(records))).
+		call(g.function("fault")).
+		op(opEnd)
+}
+
+func (g *wasmGenerator) move(value int) {
+	g.body = g.body.get(localCursor).i32(int32(value)).op(opI32Add).set(localCursor)
+}
+
+// cell pushes the address of the cell at offset from the cursor.
+func (g *wasmGenerator) cell(offset int) {
+	g.body = g.body.get(localCursor)
+	if offset != 0 {
+		g.body = g.body.i32(int32(offset)).op(opI32Add)
+	}
+}
+
+func (g *wasmGenerator) loop(body func()) {
+	g.body = g.body.op(opBlock, emptyBlock, opLoop, emptyBlock).
+		get(localCursor).memory(opI32Load8U, 0, 0).op(opI32Eqz, opBrIf, 1)
+	body()
+	g.body = g.body.op(opBr, 0, opEnd, opEnd)
+}
+
+func (g *wasmGenerator) generate(instructions []ir.Instruction) {
+	for _, instruction := range instructions {
+		switch instruction.Op {
+		case ir.Add:
+			if value := instruction.Value & 0xff; value != 0 {
+				g.body = g.body.get(localCursor).
+					get(localCursor).memory(opI32Load8U, 0, 0).i32(int32(value)).op(opI32Add).
+					memory(opI32Store8, 0, 0)
+			}
+		case ir.Move:
+			if instruction.Value != 0 || instruction.Min != 0 || instruction.Max != 0 {
+				g.bounds(instruction.Min, instruction.Max, []ir.Instruction{instruction})
+			}
+			if instruction.Value != 0 {
+				g.move(instruction.Value)
+			}
+		case ir.SetZero:
+			g.body = g.body.get(localCursor).i32(0).memory(opI32Store8, 0, 0)
+		case ir.Clear:
+			g.body = g.body.i32(0).i32(0).i32(int32(g.options.TapeSize)).op(opPrefix, opMemoryFill, 0)
+		case ir.Output:
+			g.body = g.body.get(localCursor).memory(opI32Load8U, 0, 0).call(g.function("output"))
+		case ir.Input:
+			g.body = g.body.get(localCursor).call(g.function("input"))
+			if !g.wasi {
+				// the host returns a negative number once the input has ended
+				g.body = g.body.tee(localTemporary).i32(0).get(localTemporary).i32(0).op(opI32GeS, opSelect)
+			}
+			g.body = g.body.memory(opI32Store8, 0, 0)
+		case ir.SwitchIO:
+			g.switchIO(instruction.IOTarget, instruction.Range.Start)
+		case ir.Multiply:
+			g.body = g.body.get(localCursor).memory(opI32Load8U, 0, 0).op(opIf, emptyBlock)
+			g.bounds(instruction.Min, instruction.Max, instruction.Body)
+			for _, term := range instruction.Terms {
+				g.cell(term.Offset)
+				g.body = g.body.tee(localTemporary).
+					get(localTemporary).memory(opI32Load8U, 0, 0).
+					get(localCursor).memory(opI32Load8U, 0, 0).i32(int32(term.Factor&0xff)).op(opI32Mul).
+					op(opI32Add).memory(opI32Store8, 0, 0)
+			}
+			g.body = g.body.get(localCursor).i32(0).memory(opI32Store8, 0, 0).op(opEnd)
+		case ir.Scan:
+			g.loop(func() {
+				g.bounds(min(instruction.Value, 0), max(instruction.Value, 0), instruction.Body)
+				g.move(instruction.Value)
+			})
+		case ir.Loop:
+			g.loop(func() {
+				g.generate(instruction.Body)
+			})
+		}
+	}
+}
+
+func (g *wasmGenerator) switchIO(target string, position lexer.Position) {
+	if !g.wasi {
+		g.body = g.body.i32(ioTargets[target]).i32(int32(position.Line)).i32(int32(position.Column)).call(g.function("switch_io"))
+		return
+	}
+
+	switch target {
+	case "std":
+		g.body = g.body.call(g.function("use_std"))
+	case "file":
+		g.body = g.body.call(g.function("use_file")).op(opIf, emptyBlock)
+		g.body = g.fail(g.body, bf_errors.UncaughtError, "open "+g.options.IOFile+": failed", position)
+		g.body = g.body.op(opEnd)
+	default:
+		g.body = g.fail(g.body, bf_errors.UncaughtError, "io "+target+" is not supported by compiled programs", position)
+	}
+}
+
+// fault walks the fault records of a move from the cursor, failing at the
+// first symbol that moves off the tape.
+func (g *wasmGenerator) fault() wasmCode {
+	const cursor, record, count = 0, 1, 2
+
+	return wasmCode{}.
+		op(opBlock, emptyBlock, opLoop, emptyBlock).
+		get(count).op(opI32Eqz, opBrIf, 1).
+		get(cursor).get(record).memory(opI32Load, 2, 0).op(opI32Add).tee(cursor).
+		i32(0).op(opI32LtS).
+		get(cursor).i32(int32(g.options.TapeSize)).op(opI32GeS).
+		op(opI32Or, opIf, emptyBlock).
+		get(record).memory(opI32Load, 2, 4).
+		get(record).memory(opI32Load, 2, 8).
+		get(record).memory(opI32Load, 2, 12).
+		get(record).memory(opI32Load, 2, 16).
+		get(record).memory(opI32Load, 2, 20).
+		call(g.function("fail")).op(opUnreachable, opEnd).
+		get(record).i32(faultSize).op(opI32Add).set(record).
+		get(count).i32(1).op(opI32Sub).set(count).
+		op(opBr, 0, opEnd, opEnd, opUnreachable)
+}
+
+// wasiFunctions are the io functions of a WASI module, the first
+// preopened directory is where io file is opened.
+func (g *wasmGenerator) wasiFunctions() []wasmFunction {
+	scratch, buffer := int32(g.scratch()), int32(g.buffer())
+	iovec, size, fd, read := scratch, scratch+8, scratch+12, scratch+16
+	name, length := g.intern(g.options.IOFile)
+	// fd_read, fd_seek, fd_tell and fd_write
+	const rights = 1<<1 | 1<<2 | 1<<5 | 1<<6
+
+	fail := wasmCode{}.
+		call(g.function("flush")).
+		i32(iovec).get(3).memory(opI32Store, 2, 0).
+		i32(iovec).get(4).memory(opI32Store, 2, 4).
+		i32(2).i32(iovec).i32(1).i32(size).call(g.function("fd_write")).op(opDrop).
+		i32(1).call(g.function("proc_exit")).op(opUnreachable)
+
+	const written = 0
+	flush := wasmCode{}.
+		op(opBlock, emptyBlock, opLoop, emptyBlock).
+		get(written).global(opGlobalGet, globalBuffered).op(opI32GeU, opBrIf, 1).
+		i32(iovec).i32(buffer).get(written).op(opI32Add).memory(opI32Store, 2, 0).
+		i32(iovec).global(opGlobalGet, globalBuffered).get(written).op(opI32Sub).memory(opI32Store, 2, 4).
+		global(opGlobalGet, globalOut).i32(iovec).i32(1).i32(size).call(g.function("fd_write")).
+		op(opBrIf, 1).
+		get(written).i32(size).memory(opI32Load, 2, 0).op(opI32Add).set(written).
+		op(opBr, 0, opEnd, opEnd).
+		i32(0).global(opGlobalSet, globalBuffered)
+
+	// writes to io file land right away like they do for the engine
+	output := wasmCode{}.
+		i32(buffer).global(opGlobalGet, globalBuffered).op(opI32Add).get(0).memory(opI32Store8, 0, 0).
+		global(opGlobalGet, globalBuffered).i32(1).op(opI32Add).global(opGlobalSet, globalBuffered).
+		global(opGlobalGet, globalBuffered).i32(bufferSize).op(opI32GeU).
+		global(opGlobalGet, globalFile).i32(0).op(opI32GeS).
+		op(opI32Or, opIf, emptyBlock).call(g.function("flush")).op(opEnd)
+
+	input := wasmCode{}.
+		call(g.function("flush")).
+		i32(iovec).i32(read).memory(opI32Store, 2, 0).
+		i32(iovec).i32(1).memory(opI32Store, 2, 4).
+		global(opGlobalGet, globalIn).i32(iovec).i32(1).i32(size).call(g.function("fd_read")).
+		op(opIf, emptyBlock).i32(0).op(opReturn, opEnd).
+		i32(size).memory(opI32Load, 2, 0).op(opI32Eqz).
+		op(opIf, emptyBlock).i32(0).op(opReturn, opEnd).
+		i32(read).memory(opI32Load8U, 0, 0)
+
+	closeFile := wasmCode{}.
+		global(opGlobalGet, globalFile).i32(0).op(opI32GeS, opIf, emptyBlock).
+		global(opGlobalGet, globalFile).call(g.function("fd_close")).op(opDrop).
+		i32(-1).global(opGlobalSet, globalFile).
+		op(opEnd)
+
+	useStd := wasmCode{}.
+		call(g.function("flush")).
+		call(g.function("close_file")).
+		i32(0).global(opGlobalSet, globalIn).
+		i32(1).global(opGlobalSet, globalOut)
+
+	const errno = 0
+	useFile := wasmCode{}.
+		call(g.function("flush")).
+		call(g.function("close_file")).
+		i32(3).i32(1).i32(name).i32(length).i32(1).i64(rights).i64(rights).i32(0).i32(fd).
+		call(g.function("path_open")).tee(errno).
+		op(opIf, emptyBlock).get(errno).op(opReturn, opEnd).
+		i32(fd).memory(opI32Load, 2, 0).global(opGlobalSet, globalFile).
+		global(opGlobalGet, globalFile).global(opGlobalSet, globalIn).
+		global(opGlobalGet, globalFile).global(opGlobalSet, globalOut).
+		i32(0)
+
+	return []wasmFunction{
+		{name: "fail", signature: signature{"iiiii", ""}, body: fail},
+		{name: "flush", signature: signature{"", ""}, locals: "i", body: flush},
+		{name: "output", signature: signature{"i", ""}, body: output},
+		{name: "input", signature: signature{"", "i"}, body: input},
+		{name: "close_file", signature: signature{"", ""}, body: closeFile},
+		{name: "use_std", signature: signature{"", ""}, body: useStd},
+		{name: "use_file", signature: signature{"", "i"}, locals: "i", body: useFile},
+	}
+}
+
+func types(spec string) wasmCode {
+	code := wasmCode{}.uleb(uint64(len(spec)))
+	for _, t := range spec {
+		if t == 'I' {
+			code = append(code, i64)
+		} else {
+			code = append(code, i32)
+		}
+	}
+	return code
+}
+
+// compileWasm compiles a program to a WebAssembly module, for a host that
+// provides its io or for WASI.
+func compileWasm(instructions []ir.Instruction, options Options) []byte {
+	g := &wasmGenerator{options: options, wasi: options.Target == WASI}
+	g.base = g.buffer()
+	if g.wasi {
+		g.base += bufferSize
+
+		const wasi = "wasi_snapshot_preview1"
+		g.imports = []wasmImport{
+			{wasi, "fd_write", signature{"iiii", "i"}},
+			{wasi, "fd_read", signature{"iiii", "i"}},
+			{wasi, "fd_close", signature{"i", "i"}},
+			{wasi, "path_open", signature{"iiiiiIIii", "i"}},
+			{wasi, "proc_exit", signature{"i", ""}},
+		}
+		// the functions refer to each other, they are named before they are
+		// made
+		g.functions = []wasmFunction{
+			{name: "fail"}, {name: "flush"}, {name: "output"}, {name: "input"},
+			{name: "close_file"}, {name: "use_std"}, {name: "use_file"},
+		}
+		g.functions = g.wasiFunctions()
+	} else {
+		g.imports = []wasmImport{
+			{"bf", "output", signature{"i", ""}},
+			{"bf", "input", signature{"", "i"}},
+			{"bf", "switch_io", signature{"iii", ""}},
+			{"bf", "fail", signature{"iiiii", ""}},
+		}
+	}
+
+	g.functions = append(g.functions, wasmFunction{name: "fault", signature: signature{"iii", ""}})
+	g.functions[len(g.functions)-1].body = g.fault()
+
+	entry := "run"
+	if g.wasi {
+		entry = "_start"
+	}
+	g.functions = append(g.functions, wasmFunction{name: entry, signature: signature{"", ""}, locals: "ii"})
+	g.generate(instructions)
+	if g.wasi {
+		g.body = g.body.call(g.function("flush"))
+	}
+	g.functions[len(g.functions)-1].body = g.body
+
+	signatures := []signature{}
+	typeOf := func(s signature) uint64 {
+		for i, known := range signatures {
+			if known == s {
+				return uint64(i)
+			}
+		}
+		signatures = append(signatures, s)
+		return uint64(len(signatures) - 1)
+	}
+
+	imports := []wasmCode{}
+	for _, imported := range g.imports {
+		imports = append(imports, wasmCode{}.name(imported.module).name(imported.name).op(0x00).uleb(typeOf(imported.signature)))
+	}
+
+	functions, bodies := []wasmCode{}, []wasmCode{}
+	for _, function := range g.functions {
+		functions = append(functions, wasmCode{}.uleb(typeOf(function.signature)))
+
+		// every local is a group of its own
+		locals := []wasmCode{}
+		for _, t := range types(function.locals)[1:] {
+			locals = append(locals, wasmCode{1, t})
+		}
+		body := wasmCode{}.vector(locals...).add(function.body).op(opEnd)
+		bodies = append(bodies, wasmCode{}.uleb(uint64(len(body))).add(body))
+	}
+
+	typeSection := []wasmCode{}
+	for _, s := range signatures {
+		typeSection = append(typeSection, wasmCode{}.op(0x60).add(types(s.params)).add(types(s.results)))
+	}
+
+	pages := uint64(math.Ceil(float64(int(g.base)+len(g.data)) / pageSize))
+	exports := []wasmCode{
+		wasmCode{}.name("memory").op(0x02).uleb(0),
+		wasmCode{}.name(entry).op(0x00).uleb(uint64(g.function(entry))),
+	}
+
+	module := wasmCode{0x00, 'a', 's', 'm', 0x01, 0x00, 0x00, 0x00}.
+		section(1, wasmCode{}.vector(typeSection...)).
+		section(2, wasmCode{}.vector(imports...)).
+		section(3, wasmCode{}.vector(functions...)).
+		section(5, wasmCode{}.vector(wasmCode{0x00}.uleb(pages)))
+
+	if g.wasi {
+		global := func(value int32) wasmCode {
+			return wasmCode{i32, 0x01}.i32(value).op(opEnd)
+		}
+		// the output buffered so far, the fds of the input, the output and
+		// the open io file
+		module = module.section(6, wasmCode{}.vector(global(0), global(0), global(1), global(-1)))
+	}
+
+	return module.
+		section(7, wasmCode{}.vector(exports...)).
+		section(10, wasmCode{}.vector(bodies...)).
+		section(11, wasmCode{}.vector(wasmCode{0x00}.i32(int32(g.base)).op(opEnd).name(string(g.data))))
+}
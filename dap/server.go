@@ -0,0 +1,837 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/CanPacis/brainfuck-interpreter/debugger"
+)
+
+const (
+	threadId = 1
+	frameId  = 1
+
+	tapeReference      = 1
+	registersReference = 2
+	// chunks of the tape are referenced from chunkReference onwards
+	chunkReference = 100
+	chunkSize      = 100
+)
+
+// debuggee is a program running with a debugger attached, speaking the
+// debugger package's protocol.
+type debuggee struct {
+	reader *bufio.Reader
+	writer io.Writer
+	close  func() error
+}
+
+func (d *debuggee) send(operation interface{}) error {
+	encoded, err := json.Marshal(operation)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.writer.Write(append(encoded, 10))
+	return err
+}
+
+// Server adapts the debugger protocol to the Debug Adapter Protocol, so
+// editors can debug programs run with `run --debug`.
+type Server struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	// writes guards the output and its sequence numbers
+	writes sync.Mutex
+	seq    int
+
+	// lock guards everything below, which is shared with the goroutine
+	// reading from the debuggee
+	lock        sync.Mutex
+	debuggee    *debuggee
+	meta        debugger.MetaData
+	state       *debugger.State
+	configured  bool
+	entered     bool
+	stopOnEntry bool
+	// the debuggee only reads operations while paused, so breakpoints are
+	// sent to it the next time it pauses
+	breakpoints []SourceBreakpoint
+	ids         []int
+	watchpoints []DataBreakpoint
+	watchIds    []int
+	lastId      int
+	dirty       bool
+	// requests counts the ids of the operations sent to the debuggee and
+	// pending handles the answers to the ones that don't resume it
+	requests uint64
+	pending  map[uint64]answer
+	// resumed is the operation the program was last resumed with, states
+	// answering earlier operations are stale. last is the state it was
+	// resumed from, in case the operation fails.
+	resumed uint64
+	last    *debugger.State
+}
+
+// answer handles the frame or the error response an operation gets.
+type answer func(response []byte, failure *debugger.ErrorResponse)
+
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{in: bufio.NewReader(in), out: out, pending: map[uint64]answer{}}
+}
+
+// request sends an operation made with the next request id to the
+// debuggee, handle is called with its answer.
+func (s *Server) request(operation func(id uint64) interface{}, handle answer) error {
+	s.requests++
+	if handle != nil {
+		s.pending[s.requests] = handle
+	}
+	return s.debuggee.send(operation(s.requests))
+}
+
+func (s *Server) write(message interface{}) {
+	s.writes.Lock()
+	defer s.writes.Unlock()
+
+	s.seq++
+	switch m := message.(type) {
+	case *Response:
+		m.Seq = s.seq
+	case *Event:
+		m.Seq = s.seq
+	}
+	WriteMessage(s.out, message)
+}
+
+func (s *Server) respond(request Request, body interface{}) {
+	s.write(&Response{Type: "response", RequestSeq: request.Seq, Success: true, Command: request.Command, Body: body})
+}
+
+func (s *Server) fail(request Request, err error) {
+	s.write(&Response{Type: "response", RequestSeq: request.Seq, Success: false, Command: request.Command, Message: err.Error()})
+}
+
+func (s *Server) event(event string, body interface{}) {
+	s.write(&Event{Type: "event", Event: event, Body: body})
+}
+
+// Serve handles requests until the client disconnects.
+func (s *Server) Serve() error {
+	for {
+		content, err := ReadMessage(s.in)
+		if err == io.EOF {
+			s.terminate()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var request Request
+		if err := json.Unmarshal(content, &request); err != nil {
+			return err
+		}
+
+		if done := s.handle(request); done {
+			return nil
+		}
+	}
+}
+
+func (s *Server) handle(request Request) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var err error
+
+	switch request.Command {
+	case "initialize":
+		s.respond(request, Capabilities{
+			SupportsConfigurationDoneRequest:  true,
+			SupportsSetVariable:               true,
+			SupportsTerminateRequest:          true,
+			SupportsConditionalBreakpoints:    true,
+			SupportsHitConditionalBreakpoints: true,
+			SupportsDataBreakpoints:           true,
+			SupportsStepBack:                  true,
+		})
+	case "launch":
+		var arguments LaunchArguments
+		if err = json.Unmarshal(request.Arguments, &arguments); err == nil {
+			err = s.launch(arguments)
+		}
+		if err == nil {
+			s.respond(request, nil)
+			s.event("initialized", nil)
+		}
+	case "attach":
+		var arguments AttachArguments
+		if err = json.Unmarshal(request.Arguments, &arguments); err == nil {
+			err = s.attach(arguments)
+		}
+		if err == nil {
+			s.respond(request, nil)
+			s.event("initialized", nil)
+		}
+	case "setBreakpoints":
+		var arguments SetBreakpointsArguments
+		if err = json.Unmarshal(request.Arguments, &arguments); err == nil {
+			s.breakpoints = arguments.Breakpoints
+			s.ids = []int{}
+			s.dirty = true
+
+			// verified once the debuggee has resolved them
+			breakpoints := []Breakpoint{}
+			for _, breakpoint := range arguments.Breakpoints {
+				s.lastId++
+				s.ids = append(s.ids, s.lastId)
+				breakpoints = append(breakpoints, Breakpoint{Id: s.lastId, Line: breakpoint.Line, Column: breakpoint.Column})
+			}
+			s.respond(request, map[string]interface{}{"breakpoints": breakpoints})
+
+			if s.state != nil && s.entered {
+				s.sync(func() {})
+			}
+		}
+	case "dataBreakpointInfo":
+		var arguments DataBreakpointInfoArguments
+		if err = json.Unmarshal(request.Arguments, &arguments); err == nil {
+			s.respond(request, s.dataBreakpointInfo(arguments))
+		}
+	case "setDataBreakpoints":
+		var arguments SetDataBreakpointsArguments
+		if err = json.Unmarshal(request.Arguments, &arguments); err == nil {
+			s.watchpoints = arguments.Breakpoints
+			s.watchIds = []int{}
+			s.dirty = true
+
+			breakpoints := []Breakpoint{}
+			for range arguments.Breakpoints {
+				s.lastId++
+				s.watchIds = append(s.watchIds, s.lastId)
+				breakpoints = append(breakpoints, Breakpoint{Id: s.lastId})
+			}
+			s.respond(request, map[string]interface{}{"breakpoints": breakpoints})
+
+			if s.state != nil && s.entered {
+				s.sync(func() {})
+			}
+		}
+	case "configurationDone":
+		s.configured = true
+		s.respond(request, nil)
+		if s.state != nil && !s.entered {
+			s.sync(s.entry)
+		}
+	case "threads":
+		s.respond(request, map[string]interface{}{"threads": []Thread{{Id: threadId, Name: "main"}}})
+	case "stackTrace":
+		frames := []StackFrame{}
+		if s.state != nil {
+			frames = append(frames, StackFrame{
+				Id:     frameId,
+				Name:   s.state.Statement.Type,
+				Source: Source{Name: s.meta.FileName, Path: s.meta.FilePath},
+				Line:   int(s.state.Statement.Line),
+				Column: int(s.state.Statement.Column),
+			})
+		}
+		s.respond(request, map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)})
+	case "scopes":
+		scopes := []Scope{{Name: "Registers", PresentationHint: "registers", VariablesReference: registersReference}}
+		if s.state != nil {
+			scopes = append(scopes, Scope{Name: "Tape", VariablesReference: tapeReference, IndexedVariables: len(s.state.Tape)})
+		}
+		s.respond(request, map[string]interface{}{"scopes": scopes})
+	case "variables":
+		var arguments VariablesArguments
+		if err = json.Unmarshal(request.Arguments, &arguments); err == nil {
+			s.respond(request, map[string]interface{}{"variables": s.variables(arguments.VariablesReference)})
+		}
+	case "setVariable":
+		var arguments SetVariableArguments
+		if err = json.Unmarshal(request.Arguments, &arguments); err == nil {
+			err = s.setVariable(request, arguments)
+		}
+	case "evaluate":
+		var arguments EvaluateArguments
+		if err = json.Unmarshal(request.Arguments, &arguments); err == nil {
+			err = s.evaluate(request, arguments)
+		}
+	case "continue":
+		if err = s.resume(); err == nil {
+			s.respond(request, map[string]interface{}{"allThreadsContinued": true})
+		}
+	case "next":
+		if err = s.step(debugger.StepOver); err == nil {
+			s.respond(request, nil)
+		}
+	case "stepIn":
+		if err = s.step(debugger.Step); err == nil {
+			s.respond(request, nil)
+		}
+	case "stepOut":
+		if err = s.step(debugger.StepOut); err == nil {
+			s.respond(request, nil)
+		}
+	case "stepBack":
+		if err = s.step(debugger.StepBack); err == nil {
+			s.respond(request, nil)
+		}
+	case "reverseContinue":
+		if err = s.step(debugger.ReverseContinue); err == nil {
+			s.respond(request, nil)
+		}
+	case "disconnect", "terminate":
+		s.respond(request, nil)
+		s.close()
+		return true
+	default:
+		err = fmt.Errorf("%s is not supported", request.Command)
+	}
+
+	if err != nil {
+		s.fail(request, err)
+	}
+	return false
+}
+
+// executable is the interpreter launch runs the program with.
+var executable = os.Executable
+
+func (s *Server) launch(arguments LaunchArguments) error {
+	executable, err := executable()
+	if err != nil {
+		return err
+	}
+
+	s.stopOnEntry = arguments.StopOnEntry
+	// the entry pause is where the breakpoints are set, the client only
+	// sees it with stopOnEntry
+	args := append([]string{"run", "--debug", "--debug-stop-on-entry"}, arguments.Args...)
+	command := exec.Command(executable, append(args, arguments.Program)...)
+
+	stdin, err := command.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := command.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := command.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := command.Start(); err != nil {
+		return err
+	}
+
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			s.event("output", map[string]interface{}{"category": "stderr", "output": scanner.Text() + "\n"})
+		}
+	}()
+
+	s.connect(&debuggee{
+		reader: bufio.NewReader(stdout),
+		writer: stdin,
+		close: func() error {
+			stdin.Close()
+			return command.Process.Kill()
+		},
+	})
+	return nil
+}
+
+func (s *Server) attach(arguments AttachArguments) error {
+	network, address := "tcp", arguments.Address
+	if strings.HasPrefix(address, "unix://") {
+		network, address = "unix", strings.TrimPrefix(address, "unix://")
+	} else {
+		address = strings.TrimPrefix(address, "tcp://")
+	}
+
+	connection, err := net.Dial(network, address)
+	if err != nil {
+		return err
+	}
+
+	s.stopOnEntry = arguments.StopOnEntry
+	s.connect(&debuggee{
+		reader: bufio.NewReader(connection),
+		writer: connection,
+		close:  connection.Close,
+	})
+	return nil
+}
+
+func (s *Server) connect(d *debuggee) {
+	s.debuggee = d
+
+	go func() {
+		for {
+			line, err := d.reader.ReadBytes(10)
+			if err != nil {
+				s.event("terminated", nil)
+				return
+			}
+			s.receive(line)
+		}
+	}()
+}
+
+func (s *Server) terminate() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.close()
+}
+
+func (s *Server) close() {
+	if s.debuggee != nil {
+		s.debuggee.close()
+		s.debuggee = nil
+	}
+}
+
+// receive handles a single frame from the debuggee.
+func (s *Server) receive(line []byte) {
+	var frame struct {
+		Operation debugger.ServerAction `json:"operation"`
+		Id        uint64                `json:"id"`
+	}
+	if err := json.Unmarshal(line, &frame); err != nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	switch frame.Operation {
+	case debugger.HelloAction:
+		if frame.Id == 0 {
+			s.handshake()
+		} else {
+			s.answered(frame.Id, line)
+		}
+	case debugger.EvaluationAction, debugger.TapeAction, debugger.SearchResultAction:
+		s.answered(frame.Id, line)
+	case debugger.ErrorAction:
+		var response debugger.ErrorResponse
+		if err := json.Unmarshal(line, &response); err != nil {
+			return
+		}
+		s.failed(response)
+	case debugger.DiscloseMetaData:
+		json.Unmarshal(line, &s.meta)
+	case debugger.DiscloseDebugState:
+		var state debugger.State
+		if err := json.Unmarshal(line, &state); err != nil {
+			return
+		}
+		s.paused(state, line)
+	case debugger.StdOutAction, debugger.StdErrAction:
+		var output debugger.StdOut
+		json.Unmarshal(line, &output)
+		category := map[debugger.ServerAction]string{debugger.StdOutAction: "stdout", debugger.StdErrAction: "stderr"}[frame.Operation]
+		s.event("output", map[string]interface{}{"category": category, "output": output.Value})
+	case debugger.ExitAction:
+		var exit debugger.Exit
+		json.Unmarshal(line, &exit)
+		s.state = nil
+		s.event("exited", map[string]interface{}{"exitCode": exit.Code})
+	}
+}
+
+// handshake answers the hello of the debuggee, every capability it offers
+// is used.
+func (s *Server) handshake() {
+	s.request(func(id uint64) interface{} {
+		return debugger.HelloOperation{Operation: debugger.Handshake, Id: id, Version: debugger.ProtocolVersion}
+	}, func(response []byte, failure *debugger.ErrorResponse) {
+		if failure != nil {
+			s.event("output", map[string]interface{}{"category": "stderr", "output": failure.Message + "\n"})
+			s.close()
+		}
+	})
+}
+
+// answered hands a frame to the operation it answers.
+func (s *Server) answered(id uint64, line []byte) {
+	if handle, ok := s.pending[id]; ok {
+		delete(s.pending, id)
+		handle(line, nil)
+	}
+}
+
+func (s *Server) paused(state debugger.State, line []byte) {
+	if _, ok := s.pending[state.Id]; ok {
+		if state.Id > s.resumed {
+			s.state = &state
+		}
+		s.answered(state.Id, line)
+		return
+	}
+
+	s.state = &state
+	s.sync(func() {
+		if s.state == nil || !s.configured {
+			return
+		}
+
+		if !s.entered {
+			s.entry()
+			return
+		}
+
+		s.stopped()
+	})
+}
+
+// failed handles an error response. When the operation the program was
+// resumed with fails it is still paused where it was.
+func (s *Server) failed(response debugger.ErrorResponse) {
+	if handle, ok := s.pending[response.Id]; ok {
+		delete(s.pending, response.Id)
+		handle(nil, &response)
+		return
+	}
+
+	if response.Id == s.resumed && s.state == nil && s.last != nil {
+		s.state = s.last
+		s.event("output", map[string]interface{}{"category": "stderr", "output": response.Message + "\n"})
+		s.stopped()
+	}
+}
+
+// sync sends the breakpoints set since the debuggee last paused, replacing
+// the ones it has, then calls done once it has answered.
+func (s *Server) sync(done func()) {
+	if !s.dirty {
+		done()
+		return
+	}
+	s.dirty = false
+
+	operations := []func(id uint64) interface{}{}
+	answers := []answer{}
+	ignore := func(response []byte, failure *debugger.ErrorResponse) {}
+
+	for _, breakpoint := range s.state.Breakpoints {
+		line, column := breakpoint.Line, breakpoint.Column
+		operations = append(operations, func(id uint64) interface{} {
+			return debugger.BreakpointOperation{Operation: debugger.ClearBreakpoint, Id: id, Line: line, Column: column}
+		})
+		answers = append(answers, ignore)
+	}
+	for _, watchpoint := range s.state.Watchpoints {
+		start, end := watchpoint.Start, watchpoint.End
+		operations = append(operations, func(id uint64) interface{} {
+			return debugger.WatchpointOperation{Operation: debugger.ClearWatchpoint, Id: id, Start: start, End: end}
+		})
+		answers = append(answers, ignore)
+	}
+
+	for i, breakpoint := range s.breakpoints {
+		requested := Breakpoint{Id: s.ids[i], Line: breakpoint.Line, Column: breakpoint.Column}
+
+		hitCount, err := hitCount(breakpoint.HitCondition)
+		if err != nil {
+			requested.Message = err.Error()
+			s.event("breakpoint", map[string]interface{}{"reason": "changed", "breakpoint": requested})
+			continue
+		}
+
+		operation := debugger.BreakpointOperation{
+			Operation: debugger.SetBreakpoint,
+			Line:      uint(breakpoint.Line),
+			Column:    uint(breakpoint.Column),
+			Condition: breakpoint.Condition,
+			HitCount:  hitCount,
+		}
+		operations = append(operations, func(id uint64) interface{} {
+			operation.Id = id
+			return operation
+		})
+		answers = append(answers, func(response []byte, failure *debugger.ErrorResponse) {
+			s.event("breakpoint", map[string]interface{}{"reason": "changed", "breakpoint": resolved(requested, response, failure)})
+		})
+	}
+
+	for i, watchpoint := range s.watchpoints {
+		requested := Breakpoint{Id: s.watchIds[i]}
+
+		cell, err := strconv.ParseUint(watchpoint.DataId, 10, 32)
+		if err != nil || watchpoint.AccessType == "read" {
+			requested.Message = "only writes to cells can be watched"
+			s.event("breakpoint", map[string]interface{}{"reason": "changed", "breakpoint": requested})
+			continue
+		}
+
+		operations = append(operations, func(id uint64) interface{} {
+			return debugger.WatchpointOperation{Operation: debugger.SetWatchpoint, Id: id, Start: uint(cell)}
+		})
+		answers = append(answers, func(response []byte, failure *debugger.ErrorResponse) {
+			requested.Verified = failure == nil
+			if failure != nil {
+				requested.Message = failure.Message
+			}
+			s.event("breakpoint", map[string]interface{}{"reason": "changed", "breakpoint": requested})
+		})
+	}
+
+	if len(operations) == 0 {
+		done()
+		return
+	}
+
+	last := answers[len(answers)-1]
+	answers[len(answers)-1] = func(response []byte, failure *debugger.ErrorResponse) {
+		last(response, failure)
+		done()
+	}
+
+	for i, operation := range operations {
+		s.request(operation, answers[i])
+	}
+}
+
+// hitCount parses a hit condition, which is the number of hits to pause at.
+func hitCount(condition string) (uint, error) {
+	condition = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(condition), ">="))
+	if len(condition) == 0 {
+		return 0, nil
+	}
+
+	count, err := strconv.ParseUint(condition, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' is not a hit count", condition)
+	}
+	return uint(count), nil
+}
+
+// resolved reports where the debuggee put a breakpoint, the state
+// answering a set-breakpoint has the statement it moved to.
+func resolved(requested Breakpoint, response []byte, failure *debugger.ErrorResponse) Breakpoint {
+	if failure != nil {
+		requested.Message = failure.Message
+		return requested
+	}
+
+	var state debugger.State
+	json.Unmarshal(response, &state)
+
+	if state.Resolved != nil {
+		requested.Verified = true
+		requested.Line, requested.Column = int(state.Resolved.Line), int(state.Resolved.Column)
+	}
+	return requested
+}
+
+var stopReasons = map[debugger.PauseReason]string{
+	debugger.EntryPause:      "entry",
+	debugger.StepPause:       "step",
+	debugger.DebugPause:      "breakpoint",
+	debugger.BreakpointPause: "breakpoint",
+	debugger.WatchpointPause: "data breakpoint",
+}
+
+func (s *Server) stopped() {
+	body := map[string]interface{}{"reason": stopReasons[s.state.Reason], "threadId": threadId, "allThreadsStopped": true}
+	if s.state.Reason == debugger.DebugPause {
+		body["description"] = "Paused at a debug statement"
+	}
+	if replay := s.state.Replay; replay.Step < replay.Live {
+		body["description"] = fmt.Sprintf("Step %d of the history, the program is at step %d", replay.Step, replay.Live)
+	}
+	if watch := s.state.Watch; watch != nil {
+		body["description"] = fmt.Sprintf("Cell %d changed from %d to %d", watch.Cell, watch.Old, watch.New)
+	}
+	if len(s.state.Error) > 0 {
+		body["text"] = s.state.Error
+	}
+
+	s.event("stopped", body)
+}
+
+// entry resumes from the entry pause unless the client asked to stop
+// there. A debuggee started without an entry pause first pauses for a
+// reason of its own, which is a stop.
+func (s *Server) entry() {
+	s.entered = true
+
+	if s.stopOnEntry || s.state.Reason != debugger.EntryPause {
+		s.stopped()
+		return
+	}
+
+	s.resume()
+}
+
+func (s *Server) running() error {
+	if s.debuggee == nil || s.state == nil {
+		return fmt.Errorf("the program is not paused")
+	}
+
+	s.last, s.state = s.state, nil
+	return nil
+}
+
+func (s *Server) resume() error {
+	return s.step(debugger.Resume)
+}
+
+func (s *Server) step(action debugger.ClientAction) error {
+	if err := s.running(); err != nil {
+		return err
+	}
+
+	return s.request(func(id uint64) interface{} {
+		s.resumed = id
+		return debugger.PlayerOperation{Operation: action, Id: id}
+	}, nil)
+}
+
+func (s *Server) variables(reference int) []Variable {
+	variables := []Variable{}
+	if s.state == nil {
+		return variables
+	}
+
+	tape := s.state.Tape
+	switch {
+	case reference == registersReference:
+		variables = append(variables, Variable{Name: "cursor", Value: strconv.Itoa(int(s.state.Cursor)), Type: "uint"})
+		if int(s.state.Cursor) < len(tape) {
+			variables = append(variables, Variable{Name: "cell", Value: cell(tape[s.state.Cursor]), Type: "uint"})
+		}
+	case reference == tapeReference && len(tape) > chunkSize:
+		for start := 0; start < len(tape); start += chunkSize {
+			end := min(start+chunkSize, len(tape))
+			variables = append(variables, Variable{
+				Name:               fmt.Sprintf("[%d..%d]", start, end-1),
+				VariablesReference: chunkReference + start/chunkSize,
+				IndexedVariables:   end - start,
+			})
+		}
+	case reference == tapeReference:
+		variables = cells(tape, 0, len(tape))
+	case reference >= chunkReference:
+		start := (reference - chunkReference) * chunkSize
+		if start < len(tape) {
+			variables = cells(tape, start, min(start+chunkSize, len(tape)))
+		}
+	}
+
+	return variables
+}
+
+func cell(value uint32) string {
+	if value >= 32 && value < 127 {
+		return fmt.Sprintf("%d '%c'", value, rune(value))
+	}
+	return strconv.Itoa(int(value))
+}
+
+func cells(tape []uint32, start, end int) []Variable {
+	variables := []Variable{}
+	for i := start; i < end; i++ {
+		variables = append(variables, Variable{Name: fmt.Sprintf("[%d]", i), Value: cell(tape[i]), Type: "uint"})
+	}
+	return variables
+}
+
+// dataBreakpointInfo lets cells be watched, the current cell is watched by
+// its index at the time.
+func (s *Server) dataBreakpointInfo(arguments DataBreakpointInfoArguments) map[string]interface{} {
+	cell := -1
+	if arguments.VariablesReference == registersReference && arguments.Name == "cell" && s.state != nil {
+		cell = int(s.state.Cursor)
+	} else if arguments.VariablesReference != registersReference {
+		if index, err := strconv.Atoi(strings.Trim(arguments.Name, "[]")); err == nil {
+			cell = index
+		}
+	}
+
+	if cell < 0 {
+		return map[string]interface{}{"dataId": nil, "description": "Only cells can be watched"}
+	}
+	return map[string]interface{}{
+		"dataId":      strconv.Itoa(cell),
+		"description": fmt.Sprintf("Cell %d", cell),
+		"accessTypes": []string{"write"},
+	}
+}
+
+func (s *Server) setVariable(request Request, arguments SetVariableArguments) error {
+	if s.debuggee == nil || s.state == nil {
+		return fmt.Errorf("the program is not paused")
+	}
+
+	value, err := strconv.ParseUint(strings.TrimSpace(arguments.Value), 10, 32)
+	if err != nil {
+		return fmt.Errorf("'%s' is not a cell value", arguments.Value)
+	}
+
+	var operation func(id uint64) interface{}
+	name := arguments.Name
+	if arguments.VariablesReference == registersReference && name == "cursor" {
+		operation = func(id uint64) interface{} {
+			return debugger.MoveOperation{Operation: debugger.Move, Id: id, Cell: uint(value)}
+		}
+	} else if arguments.VariablesReference == registersReference && name == "cell" {
+		cursor := s.state.Cursor
+		operation = func(id uint64) interface{} {
+			return debugger.AssignOperation{Operation: debugger.Assign, Id: id, Cell: cursor, Value: uint32(value)}
+		}
+	} else if index, err := strconv.Atoi(strings.Trim(name, "[]")); err == nil {
+		operation = func(id uint64) interface{} {
+			return debugger.AssignOperation{Operation: debugger.Assign, Id: id, Cell: uint(index), Value: uint32(value)}
+		}
+	} else {
+		return fmt.Errorf("%s cannot be set", name)
+	}
+
+	return s.request(operation, func(response []byte, failure *debugger.ErrorResponse) {
+		if failure != nil {
+			s.fail(request, failure)
+			return
+		}
+		s.respond(request, map[string]interface{}{"value": arguments.Value})
+		s.event("invalidated", map[string]interface{}{"areas": []string{"variables"}})
+	})
+}
+
+// evaluate asks the debuggee for the value of an expression over the tape,
+// like `c[cursor-1] + c[4]`.
+func (s *Server) evaluate(request Request, arguments EvaluateArguments) error {
+	if s.debuggee == nil || s.state == nil {
+		return fmt.Errorf("the program is not paused")
+	}
+
+	return s.request(func(id uint64) interface{} {
+		return debugger.EvaluateOperation{Operation: debugger.Evaluate, Id: id, Expression: arguments.Expression}
+	}, func(response []byte, failure *debugger.ErrorResponse) {
+		if failure != nil {
+			s.fail(request, failure)
+			return
+		}
+
+		var evaluation debugger.Evaluation
+		json.Unmarshal(response, &evaluation)
+		s.respond(request, map[string]interface{}{"result": strconv.FormatInt(evaluation.Value, 10), "variablesReference": 0})
+	})
+}
@@ -0,0 +1,255 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/CanPacis/brainfuck-interpreter/debugger"
+	"github.com/CanPacis/brainfuck-interpreter/lexer"
+	"github.com/CanPacis/brainfuck-interpreter/parser"
+)
+
+// fakeDebuggee speaks the debugger protocol for a program with one statement
+// on every line given, pausing on entry and at breakpoints.
+func fakeDebuggee(t *testing.T, lines []uint) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		connection, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer connection.Close()
+
+		encoder := json.NewEncoder(connection)
+		reader := bufio.NewReader(connection)
+		encoder.Encode(debugger.Hello{Operation: debugger.HelloAction, Version: debugger.ProtocolVersion, Capabilities: debugger.Capabilities})
+		encoder.Encode(debugger.MetaData{Operation: debugger.DiscloseMetaData, FileName: "fake.bfi", FilePath: "/fake.bfi"})
+
+		breakpoints := map[uint]bool{}
+		reason := debugger.EntryPause
+		var id uint64
+		var resolved *lexer.Position
+		for _, line := range lines {
+			if reason == "" && !breakpoints[line] {
+				continue
+			}
+			if reason == "" {
+				reason = debugger.BreakpointPause
+			}
+
+			for paused := true; paused; {
+				state := debugger.State{
+					Operation:   debugger.DiscloseDebugState,
+					Id:          id,
+					Reason:      reason,
+					Statement:   parser.Statement{Type: "Increment Statement", Position: lexer.Position{Line: line, Column: 1}},
+					Tape:        []uint32{72, 105},
+					Breakpoints: []debugger.Breakpoint{},
+					Resolved:    resolved,
+				}
+				for line := range breakpoints {
+					state.Breakpoints = append(state.Breakpoints, debugger.Breakpoint{Position: lexer.Position{Line: line, Column: 1}})
+				}
+				encoder.Encode(state)
+
+				var operation debugger.BreakpointOperation
+				for operation.Operation == "" || operation.Operation == debugger.Handshake || operation.Operation == debugger.Evaluate {
+					switch operation.Operation {
+					case debugger.Handshake:
+						encoder.Encode(debugger.Hello{Operation: debugger.HelloAction, Id: operation.Id, Version: debugger.ProtocolVersion, Capabilities: debugger.Capabilities})
+					case debugger.Evaluate:
+						encoder.Encode(debugger.Evaluation{Operation: debugger.EvaluationAction, Id: operation.Id, Value: 72 + 105})
+					}
+
+					content, err := reader.ReadBytes(10)
+					if err != nil {
+						return
+					}
+					operation = debugger.BreakpointOperation{}
+					json.Unmarshal(content, &operation)
+				}
+				id, resolved = operation.Id, nil
+
+				switch operation.Operation {
+				case debugger.SetBreakpoint:
+					breakpoints[operation.Line] = true
+					resolved = &lexer.Position{Line: operation.Line, Column: 1}
+				case debugger.ClearBreakpoint:
+					delete(breakpoints, operation.Line)
+				case debugger.Step:
+					reason, paused = debugger.StepPause, false
+				default:
+					reason, paused = "", false
+				}
+			}
+		}
+		encoder.Encode(debugger.Exit{Operation: debugger.ExitAction})
+	}()
+
+	return listener.Addr().String()
+}
+
+type client struct {
+	t      *testing.T
+	seq    int
+	writer io.Writer
+	reader *bufio.Reader
+}
+
+func (c *client) send(command string, arguments interface{}) {
+	c.seq++
+	encoded, _ := json.Marshal(arguments)
+	WriteMessage(c.writer, Request{Seq: c.seq, Type: "request", Command: command, Arguments: encoded})
+}
+
+// until reads messages until one matches the response command or event.
+func (c *client) until(name string) map[string]interface{} {
+	for {
+		message := c.next(name)
+		if message["command"] == name || message["event"] == name {
+			return message
+		}
+	}
+}
+
+// next reads the next message, expecting one while waiting for name.
+func (c *client) next(name string) map[string]interface{} {
+	content, err := ReadMessage(c.reader)
+	if err != nil {
+		c.t.Fatalf("Expected %s found %v", name, err)
+	}
+
+	var message map[string]interface{}
+	json.Unmarshal(content, &message)
+	return message
+}
+
+func TestBreakpoints(t *testing.T) {
+	address := fakeDebuggee(t, []uint{1, 2, 3, 4})
+
+	requests, requestWriter := io.Pipe()
+	responseReader, responses := io.Pipe()
+	go NewServer(requests, responses).Serve()
+
+	c := &client{t: t, writer: requestWriter, reader: bufio.NewReader(responseReader)}
+
+	c.send("initialize", nil)
+	c.until("initialize")
+	c.send("attach", AttachArguments{Address: "tcp://" + address})
+	c.until("initialized")
+	c.send("setBreakpoints", SetBreakpointsArguments{Breakpoints: []SourceBreakpoint{{Line: 3}}})
+	c.until("setBreakpoints")
+	c.send("configurationDone", nil)
+
+	changed := c.until("breakpoint")["body"].(map[string]interface{})["breakpoint"].(map[string]interface{})
+	if changed["verified"] != true || changed["column"] != 1.0 {
+		t.Errorf("Expected the breakpoint to be verified at 3:1 found %v", changed)
+	}
+
+	stopped := c.until("stopped")
+	if stopped["body"].(map[string]interface{})["reason"] != "breakpoint" {
+		t.Errorf("Expected to stop at a breakpoint found %v", stopped)
+	}
+
+	c.send("stackTrace", nil)
+	frames := c.until("stackTrace")["body"].(map[string]interface{})["stackFrames"].([]interface{})
+	if len(frames) != 1 || frames[0].(map[string]interface{})["line"] != 3.0 {
+		t.Errorf("Expected to stop at line 3 found %v", frames)
+	}
+
+	c.send("variables", VariablesArguments{VariablesReference: tapeReference})
+	variables := c.until("variables")["body"].(map[string]interface{})["variables"].([]interface{})
+	if len(variables) != 2 || variables[0].(map[string]interface{})["value"] != "72 'H'" {
+		t.Errorf("Incorrect tape variables %v", variables)
+	}
+
+	c.send("evaluate", EvaluateArguments{Expression: "c[0] + c[1]"})
+	if result := c.until("evaluate")["body"].(map[string]interface{})["result"]; result != "177" {
+		t.Errorf("Expected the expression to be 177 found %v", result)
+	}
+
+	c.send("continue", nil)
+	c.until("exited")
+	c.send("disconnect", nil)
+	c.until("disconnect")
+}
+
+func TestLaunch(t *testing.T) {
+	if testing.Short() {
+		t.Skip("building the interpreter takes a while")
+	}
+
+	dir := t.TempDir()
+	interpreter := filepath.Join(dir, "bfi")
+	if output, err := exec.Command("go", "build", "-o", interpreter, "..").CombinedOutput(); err != nil {
+		t.Fatalf("%s: %s", err, output)
+	}
+	executable = func() (string, error) { return interpreter, nil }
+	t.Cleanup(func() { executable = os.Executable })
+
+	program := filepath.Join(dir, "program.bf")
+	os.WriteFile(program, []byte("++++++++[>++++++++<-]\n>+.\n+."), 0644)
+
+	requests, requestWriter := io.Pipe()
+	responseReader, responses := io.Pipe()
+	go NewServer(requests, responses).Serve()
+
+	c := &client{t: t, writer: requestWriter, reader: bufio.NewReader(responseReader)}
+
+	c.send("initialize", nil)
+	c.until("initialize")
+	c.send("launch", LaunchArguments{Program: program})
+	c.until("initialized")
+	c.send("setBreakpoints", SetBreakpointsArguments{Breakpoints: []SourceBreakpoint{{Line: 3}}})
+	c.until("setBreakpoints")
+	c.send("configurationDone", nil)
+
+	changed := c.until("breakpoint")["body"].(map[string]interface{})["breakpoint"].(map[string]interface{})
+	if changed["verified"] != true || changed["line"] != 3.0 || changed["column"] != 1.0 {
+		t.Errorf("Expected the breakpoint to be verified at 3:1 found %v", changed)
+	}
+
+	// the entry pause is only used to set the breakpoints
+	stopped := c.until("stopped")["body"].(map[string]interface{})
+	if stopped["reason"] != "breakpoint" {
+		t.Errorf("Expected to stop at the breakpoint found %v", stopped)
+	}
+
+	c.send("stackTrace", nil)
+	frames := c.until("stackTrace")["body"].(map[string]interface{})["stackFrames"].([]interface{})
+	if len(frames) != 1 || frames[0].(map[string]interface{})["line"] != 3.0 {
+		t.Errorf("Expected to stop at line 3 found %v", frames)
+	}
+
+	c.send("continue", nil)
+	output := ""
+	for {
+		message := c.next("exited")
+		if message["event"] == "exited" {
+			if code := message["body"].(map[string]interface{})["exitCode"]; code != 0.0 {
+				t.Errorf("Expected the program to exit with 0 found %v", code)
+			}
+			break
+		}
+		if message["event"] == "output" {
+			output += message["body"].(map[string]interface{})["output"].(string)
+		}
+	}
+	if output != "B" {
+		t.Errorf("Expected the program to write B after the breakpoint found %q", output)
+	}
+
+	c.send("disconnect", nil)
+	c.until("disconnect")
+}
@@ -0,0 +1,65 @@
+package debugger
+
+import (
+	"fmt"
+
+	"github.com/CanPacis/brainfuck-interpreter/lexer"
+)
+
+// Breakpoint pauses the program before the statement at its position runs.
+// A breakpoint with a condition only counts the hits where the condition is
+// true, and one with a hit count pauses once it has been hit that many
+// times.
+type Breakpoint struct {
+	lexer.Position
+	// Requested is where the client asked for the breakpoint, Position is
+	// the statement it moved to
+	Requested lexer.Position `json:"requested"`
+	Condition string         `json:"condition,omitempty"`
+	HitCount  uint           `json:"hit_count,omitempty"`
+	Hits      uint           `json:"hits"`
+
+	condition *Expression
+}
+
+// NewBreakpoint compiles the condition of a breakpoint, an empty condition
+// is always true.
+func NewBreakpoint(position, requested lexer.Position, condition string, hitCount uint) (*Breakpoint, error) {
+	breakpoint := &Breakpoint{Position: position, Requested: requested, Condition: condition, HitCount: hitCount}
+
+	if len(condition) > 0 {
+		expression, err := Compile(condition)
+		if err != nil {
+			return nil, fmt.Errorf("invalid condition: %w", err)
+		}
+		breakpoint.condition = &expression
+	}
+
+	return breakpoint, nil
+}
+
+// Matches reports whether the condition holds without counting a hit. A
+// condition that fails to evaluate matches along with the error.
+func (b *Breakpoint) Matches(env Env) (bool, error) {
+	if b.condition == nil {
+		return true, nil
+	}
+
+	ok, err := b.condition.Test(env)
+	if err != nil {
+		return true, fmt.Errorf("condition of the breakpoint at %d:%d failed: %w", b.Line, b.Column, err)
+	}
+	return ok, nil
+}
+
+// Hit counts a hit when the condition holds and reports whether the program
+// should pause.
+func (b *Breakpoint) Hit(env Env) (bool, error) {
+	ok, err := b.Matches(env)
+	if err != nil || !ok {
+		return ok, err
+	}
+
+	b.Hits++
+	return b.Hits >= b.HitCount, nil
+}
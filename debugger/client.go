@@ -2,11 +2,43 @@ package debugger
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
-	"os"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"sync"
 )
 
+// ErrDetached is returned when a client connected over a socket goes away,
+// the program keeps running until another one attaches.
+var ErrDetached = errors.New("the debugger client detached")
+
+// connection is where frames go, it is shared by every copy of a Debugger.
+type connection struct {
+	lock   sync.Mutex
+	reader *bufio.Reader
+	// writer is nil while no client is attached
+	writer io.Writer
+	closer io.Closer
+	// listener accepts clients, it is nil when the debugger uses stdio
+	listener net.Listener
+	meta     *MetaData
+	// offered are the capabilities of the debugger, agreed the ones the
+	// attached client agreed on, which are nil until its handshake
+	offered []Capability
+	agreed  []Capability
+	// reattached is set when a client attaches until the program pauses
+	// for it
+	reattached bool
+	ready      chan struct{}
+	readyOnce  sync.Once
+}
+
 type Client struct {
+	connection *connection
 }
 
 type ErrorClient struct {
@@ -27,6 +59,8 @@ func (c *Client) Write(p []byte) (int, error) {
 	})
 }
 
+// WriteOperation sends a frame to the client, frames sent while no client
+// is attached are dropped.
 func (c *Client) WriteOperation(data interface{}) (int, error) {
 	encoded, err := json.Marshal(data)
 
@@ -36,18 +70,121 @@ func (c *Client) WriteOperation(data interface{}) (int, error) {
 
 	encoded = append(encoded, 10)
 
-	return os.Stdout.Write(encoded)
+	c.connection.lock.Lock()
+	defer c.connection.lock.Unlock()
+
+	if c.connection.writer == nil {
+		return len(encoded), nil
+	}
+
+	n, err := c.connection.writer.Write(encoded)
+	if err != nil && c.connection.listener != nil {
+		c.connection.detach()
+		return n, ErrDetached
+	}
+	return n, err
 }
 
 func (c *Client) Read(p []byte) (int, error) {
-	reader := bufio.NewReader(os.Stdin)
-	line, _, err := reader.ReadLine()
-
+	line, err := c.ReadLine()
 	if err != nil {
 		return 0, err
 	}
 
-	copy(p, line)
+	return copy(p, line), nil
+}
+
+// ReadLine reads a whole line from the client without its line ending.
+func (c *Client) ReadLine() ([]byte, error) {
+	c.connection.lock.Lock()
+	reader := c.connection.reader
+	c.connection.lock.Unlock()
+
+	if reader == nil {
+		return nil, ErrDetached
+	}
+
+	line, err := reader.ReadBytes(10)
+	if err != nil && (len(line) == 0 || err != io.EOF) {
+		if c.connection.listener != nil {
+			c.connection.lock.Lock()
+			c.connection.detach()
+			c.connection.lock.Unlock()
+			return nil, ErrDetached
+		}
+		return nil, err
+	}
+
+	return bytes.TrimRight(line, "\r\n"), nil
+}
+
+// admit checks a request is allowed by the handshake.
+func (c *connection) admit(request Request) *ErrorResponse {
+	if request.Action == Handshake {
+		return nil
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.agreed == nil {
+		return Errorf(HandshakeRequired, "the client has to send a hello operation first")
+	}
+	if capability, ok := requires[request.Action]; ok && !slices.Contains(c.agreed, capability) {
+		return Errorf(Unsupported, "%s needs the %s capability", request.Action, capability)
+	}
+	return nil
+}
+
+func (c *connection) hello() Hello {
+	return Hello{Operation: HelloAction, Version: ProtocolVersion, Capabilities: c.offered}
+}
+
+// detach forgets the attached client, the lock must be held.
+func (c *connection) detach() {
+	if c.closer != nil {
+		c.closer.Close()
+	}
+	c.reader, c.writer, c.closer = nil, nil, nil
+}
+
+// accept attaches clients one at a time, others are turned away while one
+// is attached.
+func (c *connection) accept() {
+	for {
+		conn, err := c.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		c.lock.Lock()
+		if c.writer != nil {
+			c.lock.Unlock()
+			conn.Close()
+			continue
+		}
+
+		c.reader, c.writer, c.closer = bufio.NewReader(conn), conn, conn
+		c.reattached = true
+		c.agreed = nil
+
+		hello, _ := json.Marshal(c.hello())
+		conn.Write(append(hello, 10))
+		if c.meta != nil {
+			encoded, _ := json.Marshal(c.meta)
+			conn.Write(append(encoded, 10))
+		}
+		c.lock.Unlock()
 
-	return len(line), nil
+		c.readyOnce.Do(func() { close(c.ready) })
+	}
+}
+
+// listen parses addresses like tcp://localhost:4711, unix:///tmp/bf.sock
+// or just localhost:4711.
+func listen(address string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(address, "unix://"); ok {
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", strings.TrimPrefix(address, "tcp://"))
 }
@@ -1,8 +1,14 @@
 package debugger
 
 import (
-	"encoding/json"
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"slices"
 
+	"github.com/CanPacis/brainfuck-interpreter/lexer"
 	"github.com/CanPacis/brainfuck-interpreter/parser"
 )
 
@@ -20,6 +26,11 @@ var (
 	ExitAction         ServerAction = "exit"
 	StdOutAction       ServerAction = "std-out"
 	StdErrAction       ServerAction = "std-err"
+	HelloAction        ServerAction = "hello"
+	ErrorAction        ServerAction = "error"
+	TapeAction         ServerAction = "tape"
+	SearchResultAction ServerAction = "search-result"
+	EvaluationAction   ServerAction = "evaluation"
 )
 
 type MetaData struct {
@@ -29,11 +40,54 @@ type MetaData struct {
 	Content   string       `json:"content"`
 }
 
+// PauseReason tells the client why the program paused.
+type PauseReason string
+
+var (
+	EntryPause      PauseReason = "entry"
+	StepPause       PauseReason = "step"
+	DebugPause      PauseReason = "debug"
+	BreakpointPause PauseReason = "breakpoint"
+	WatchpointPause PauseReason = "watchpoint"
+)
+
 type State struct {
-	Operation ServerAction     `json:"operation"`
+	Operation ServerAction `json:"operation"`
+	// Id is the request the state answers, states shared when the program
+	// pauses on its own have none
+	Id     uint64      `json:"id,omitempty"`
+	Reason PauseReason `json:"reason"`
+	// Statement is about to run, or has just written a watched cell when
+	// paused at a watchpoint
 	Statement parser.Statement `json:"statement"`
-	Tape      []byte           `json:"tape"`
-	Cursor    uint             `json:"cursor"`
+	// Tape goes up to the last cell that isn't zero or the cursor, the rest
+	// of the TapeSize cells can be read with a read-tape operation
+	Tape     []uint32 `json:"tape"`
+	TapeSize uint     `json:"tape_size"`
+	Cursor   uint     `json:"cursor"`
+	// Breakpoint is the breakpoint the program paused at
+	Breakpoint  *Breakpoint  `json:"breakpoint,omitempty"`
+	Breakpoints []Breakpoint `json:"breakpoints"`
+	// Resolved is where the breakpoint set by the request the state answers
+	// moved to
+	Resolved    *lexer.Position `json:"resolved,omitempty"`
+	Watch       *WatchHit       `json:"watch,omitempty"`
+	Watchpoints []Watchpoint    `json:"watchpoints"`
+	Replay      Replay          `json:"replay"`
+	// Error is why the condition of the breakpoint failed to evaluate
+	Error string `json:"error,omitempty"`
+}
+
+// Replay is where the shared state is in the execution history. The state
+// is from the past while Step is before Live, and only the steps from
+// Oldest can be gone back to.
+type Replay struct {
+	Step   uint64 `json:"step"`
+	Live   uint64 `json:"live"`
+	Oldest uint64 `json:"oldest"`
+	// IO is the byte the statement read or wrote when it ran, only known
+	// in the past
+	IO string `json:"io,omitempty"`
 }
 
 type Exit struct {
@@ -55,68 +109,344 @@ var (
 	StepOver ClientAction = "step-over"
 	Assign   ClientAction = "assign"
 	Move     ClientAction = "move"
-)
 
-type ClientOperation struct {
-	Operation ClientAction `json:"operation"`
-}
+	SetBreakpoint   ClientAction = "set-breakpoint"
+	ClearBreakpoint ClientAction = "clear-breakpoint"
+	SetWatchpoint   ClientAction = "set-watchpoint"
+	ClearWatchpoint ClientAction = "clear-watchpoint"
+
+	StepBack        ClientAction = "step-back"
+	ReverseContinue ClientAction = "reverse-continue"
+	Jump            ClientAction = "jump"
+
+	Handshake ClientAction = "hello"
+
+	ReadTape   ClientAction = "read-tape"
+	SearchTape ClientAction = "search-tape"
+	Evaluate   ClientAction = "evaluate"
+)
 
 type PlayerOperation struct {
 	Operation ClientAction `json:"operation"`
+	Id        uint64       `json:"id"`
 }
 
 type AssignOperation struct {
 	Operation ClientAction `json:"operation"`
+	Id        uint64       `json:"id"`
 	Cell      uint         `json:"cell"`
-	Value     byte         `json:"value"`
+	Value     uint32       `json:"value"`
 }
 
 type MoveOperation struct {
 	Operation ClientAction `json:"operation"`
+	Id        uint64       `json:"id"`
 	Cell      uint         `json:"cell"`
 }
 
+// JumpOperation goes to a step in the execution history, going to the live
+// step comes back to the present.
+type JumpOperation struct {
+	Operation ClientAction `json:"operation"`
+	Id        uint64       `json:"id"`
+	Step      uint64       `json:"step"`
+}
+
+// BreakpointOperation sets or clears the breakpoint at the first statement
+// at or after a position. A column of 0 is the start of the line.
+type BreakpointOperation struct {
+	Operation ClientAction `json:"operation"`
+	Id        uint64       `json:"id"`
+	Line      uint         `json:"line"`
+	Column    uint         `json:"column"`
+	Condition string       `json:"condition,omitempty"`
+	HitCount  uint         `json:"hit_count,omitempty"`
+}
+
+// WatchpointOperation sets or clears the watchpoint on the cells from Start
+// to End.
+type WatchpointOperation struct {
+	Operation ClientAction `json:"operation"`
+	Id        uint64       `json:"id"`
+	Start     uint         `json:"start"`
+	End       uint         `json:"end,omitempty"`
+	Access    WatchAccess  `json:"access,omitempty"`
+	From      *uint32      `json:"from,omitempty"`
+	To        *uint32      `json:"to,omitempty"`
+}
+
+// ReadTapeOperation reads Count cells from Start, rendered as text in
+// Format as well.
+type ReadTapeOperation struct {
+	Operation ClientAction `json:"operation"`
+	Id        uint64       `json:"id"`
+	Start     uint         `json:"start"`
+	Count     uint         `json:"count"`
+	Format    TapeFormat   `json:"format,omitempty"`
+}
+
+// TapeRange answers a read-tape operation.
+type TapeRange struct {
+	Operation ServerAction `json:"operation"`
+	Id        uint64       `json:"id"`
+	Start     uint         `json:"start"`
+	Cells     []uint32     `json:"cells"`
+	Format    TapeFormat   `json:"format"`
+	Text      string       `json:"text"`
+}
+
+// SearchOperation finds where a sequence of values appears on the tape,
+// given as Values or as the bytes of Text. Only matches from Start on are
+// reported, at most Limit of them which defaults to DefaultSearchLimit.
+type SearchOperation struct {
+	Operation ClientAction `json:"operation"`
+	Id        uint64       `json:"id"`
+	Values    []uint32     `json:"values,omitempty"`
+	Text      string       `json:"text,omitempty"`
+	Start     uint         `json:"start,omitempty"`
+	Limit     uint         `json:"limit,omitempty"`
+}
+
+// SearchResult answers a search-tape operation with the first cell of
+// every match, More is set when the limit left some out.
+type SearchResult struct {
+	Operation ServerAction `json:"operation"`
+	Id        uint64       `json:"id"`
+	Matches   []uint       `json:"matches"`
+	More      bool         `json:"more"`
+}
+
+// EvaluateOperation evaluates an expression over the tape like a
+// breakpoint condition, see Expression.
+type EvaluateOperation struct {
+	Operation  ClientAction `json:"operation"`
+	Id         uint64       `json:"id"`
+	Expression string       `json:"expression"`
+}
+
+// Evaluation answers an evaluate operation.
+type Evaluation struct {
+	Operation  ServerAction `json:"operation"`
+	Id         uint64       `json:"id"`
+	Expression string       `json:"expression"`
+	Value      int64        `json:"value"`
+}
+
+// Stdio reports whether the debugger speaks over stdout and stdin, in which
+// case the program's own io goes through it too.
+func (d Debugger) Stdio() bool {
+	return d.Client.connection.listener == nil
+}
+
+// Addr is the address a debugger listens on for clients.
+func (d Debugger) Addr() net.Addr {
+	if d.Stdio() {
+		return nil
+	}
+	return d.Client.connection.listener.Addr()
+}
+
+// Disclose sends the meta data to the client, and to every client that
+// attaches later. A client over stdio gets the hello frame first, socket
+// clients get it as soon as they attach.
+func (d Debugger) Disclose(meta MetaData) error {
+	c := d.Client.connection
+	c.lock.Lock()
+	c.meta = &meta
+	c.lock.Unlock()
+
+	if d.Stdio() {
+		if _, err := d.Client.WriteOperation(c.hello()); err != nil {
+			return err
+		}
+	}
+
+	_, err := d.Client.WriteOperation(meta)
+	if errors.Is(err, ErrDetached) {
+		return nil
+	}
+	return err
+}
+
+// Wait blocks until a client attaches.
+func (d Debugger) Wait(ctx context.Context) error {
+	select {
+	case <-d.Client.connection.ready:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Attached reports whether a client is attached.
+func (d Debugger) Attached() bool {
+	c := d.Client.connection
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.writer != nil
+}
+
+// Agreed reports whether the attached client agreed on a capability.
+func (d Debugger) Agreed(capability Capability) bool {
+	c := d.Client.connection
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.writer != nil && slices.Contains(c.agreed, capability)
+}
+
+// Reattached reports whether a client attached since the last time it was
+// asked, the program pauses for it like it does on entry.
+func (d Debugger) Reattached() bool {
+	c := d.Client.connection
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	reattached := c.reattached
+	c.reattached = false
+	return reattached
+}
+
 func (d Debugger) Close(code int) error {
 	d.Client.WriteOperation(Exit{Operation: ExitAction, Code: code})
-	return nil
+
+	c := d.Client.connection
+	if c.listener == nil {
+		return nil
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.detach()
+	return c.listener.Close()
 }
 
-func (d Debugger) ShareState(state State) (string, interface{}, error) {
-	d.Client.WriteOperation(state)
+// ShareState sends the state to the client and waits for its next
+// operation. Requests that fail to decode, come before the handshake or
+// need a capability the client didn't agree on are answered with an error
+// response without ever being returned.
+func (d Debugger) ShareState(state State) (Request, error) {
+	if _, err := d.Client.WriteOperation(state); err != nil {
+		return Request{}, err
+	}
+	return d.next()
+}
 
-	response := make([]byte, 1024)
-	n, err := d.Client.Read(response)
-	response = response[:n]
+// Respond answers a request with a frame other than the state, then
+// waits for the next operation like ShareState does.
+func (d Debugger) Respond(response interface{}) (Request, error) {
+	if _, err := d.Client.WriteOperation(response); err != nil {
+		return Request{}, err
+	}
+	return d.next()
+}
 
-	if err != nil {
-		return "", nil, err
+// Fail answers a request with an error response, then waits for the next
+// operation like ShareState does.
+func (d Debugger) Fail(id uint64, err error) (Request, error) {
+	response := AsErrorResponse(err)
+	response.Id = id
+	return d.Respond(response)
+}
+
+func (d Debugger) next() (Request, error) {
+	c := d.Client.connection
+
+	for {
+		line, err := d.Client.ReadLine()
+		if err != nil {
+			return Request{}, err
+		}
+
+		request, failure := Decode(line)
+		if failure == nil {
+			failure = c.admit(request)
+		}
+		if failure == nil && request.Action == Handshake {
+			failure = d.greet(request)
+			if failure == nil {
+				continue
+			}
+		}
+
+		if failure == nil {
+			return request, nil
+		}
+
+		failure.Id = request.Id
+		if _, err := d.Client.WriteOperation(failure); err != nil {
+			return Request{}, err
+		}
 	}
+}
 
-	var action map[string]interface{}
-	err = json.Unmarshal(response, &action)
+// greet answers the hello operation of a client with the capabilities
+// they agreed on.
+func (d Debugger) greet(request Request) *ErrorResponse {
+	c := d.Client.connection
 
-	if err != nil {
-		return "", action, err
+	c.lock.Lock()
+	agreed, failure := agree(c.offered, request.Operation.(HelloOperation))
+	if failure == nil {
+		c.agreed = agreed
 	}
+	c.lock.Unlock()
 
-	switch action["operation"] {
-	case "resume", "step", "step-out", "step-over":
-		return action["operation"].(string), PlayerOperation{Operation: ClientAction(action["operation"].(string))}, nil
-	case "move":
-		return "move", MoveOperation{Operation: "move", Cell: uint(action["cell"].(float64))}, nil
-	case "assign":
-		return "assign", AssignOperation{Operation: "assign", Cell: uint(action["cell"].(float64)), Value: byte(action["value"].(float64))}, nil
+	if failure != nil {
+		return failure
 	}
 
-	return "", action, nil
+	d.Client.WriteOperation(Hello{Operation: HelloAction, Id: request.Id, Version: ProtocolVersion, Capabilities: agreed})
+	return nil
 }
 
-func NewDebugger() (Debugger, error) {
-	client := &Client{}
+// NewDebugger speaks to a client over stdout and stdin. It offers the
+// given capabilities, or all of them when there are none.
+func NewDebugger(capabilities ...Capability) (Debugger, error) {
+	return newDebugger(&connection{
+		reader:  bufio.NewReader(os.Stdin),
+		writer:  os.Stdout,
+		ready:   closed(),
+		offered: offer(capabilities),
+	}), nil
+}
+
+// Listen waits for clients on a tcp or unix socket, leaving stdout and
+// stdin to the program. Addresses look like tcp://localhost:4711,
+// unix:///tmp/bf.sock or localhost:4711. Like NewDebugger it offers the
+// given capabilities.
+func Listen(address string, capabilities ...Capability) (Debugger, error) {
+	listener, err := listen(address)
+	if err != nil {
+		return Debugger{}, err
+	}
+
+	c := &connection{listener: listener, ready: make(chan struct{}), offered: offer(capabilities)}
+	go c.accept()
+
+	return newDebugger(c), nil
+}
+
+func newDebugger(c *connection) Debugger {
+	client := &Client{connection: c}
 
 	return Debugger{
 		Exists:      true,
 		Client:      *client,
 		ErrorClient: ErrorClient{client},
-	}, nil
+	}
+}
+
+func offer(capabilities []Capability) []Capability {
+	if len(capabilities) == 0 {
+		return Capabilities
+	}
+	return capabilities
+}
+
+func closed() chan struct{} {
+	ready := make(chan struct{})
+	close(ready)
+	return ready
 }
@@ -0,0 +1,321 @@
+package debugger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Env is what expressions read from the paused program.
+type Env interface {
+	// Cell returns the value of a cell, or false when the cell is not on
+	// the tape
+	Cell(index int64) (int64, bool)
+	Cursor() int64
+}
+
+// Expression is a compiled expression over the tape and the cursor, like
+// `cell[3] == 72` or `cursor > 100 && cell != 0`. `cell` alone is the cell
+// under the cursor and `c` is short for `cell`. Comparisons and logical
+// operators result in 1 or 0.
+type Expression struct {
+	source string
+	root   node
+}
+
+func (e Expression) String() string {
+	return e.source
+}
+
+// Evaluate runs the expression against env.
+func (e Expression) Evaluate(env Env) (int64, error) {
+	return e.root.evaluate(env)
+}
+
+// Test evaluates the expression as a condition, any value other than zero
+// is true.
+func (e Expression) Test(env Env) (bool, error) {
+	value, err := e.Evaluate(env)
+	return value != 0, err
+}
+
+// Compile parses an expression.
+func Compile(source string) (Expression, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return Expression{}, err
+	}
+
+	p := &expressionParser{tokens: tokens}
+	root, err := p.parse(0)
+	if err != nil {
+		return Expression{}, err
+	}
+	if p.peek() != "" {
+		return Expression{}, fmt.Errorf("unexpected '%s' in expression", p.peek())
+	}
+
+	return Expression{source: source, root: root}, nil
+}
+
+type node interface {
+	evaluate(env Env) (int64, error)
+}
+
+type literal int64
+
+func (l literal) evaluate(env Env) (int64, error) {
+	return int64(l), nil
+}
+
+type cursorNode struct{}
+
+func (cursorNode) evaluate(env Env) (int64, error) {
+	return env.Cursor(), nil
+}
+
+type cellNode struct {
+	// index is nil for the cell under the cursor
+	index node
+}
+
+func (c cellNode) evaluate(env Env) (int64, error) {
+	index := env.Cursor()
+	if c.index != nil {
+		var err error
+		if index, err = c.index.evaluate(env); err != nil {
+			return 0, err
+		}
+	}
+
+	value, ok := env.Cell(index)
+	if !ok {
+		return 0, fmt.Errorf("cell %d is not on the tape", index)
+	}
+	return value, nil
+}
+
+type unary struct {
+	operator string
+	operand  node
+}
+
+func (u unary) evaluate(env Env) (int64, error) {
+	value, err := u.operand.evaluate(env)
+	if err != nil {
+		return 0, err
+	}
+
+	if u.operator == "-" {
+		return -value, nil
+	}
+	return truth(value == 0), nil
+}
+
+type binary struct {
+	operator    string
+	left, right node
+}
+
+func (b binary) evaluate(env Env) (int64, error) {
+	left, err := b.left.evaluate(env)
+	if err != nil {
+		return 0, err
+	}
+
+	// logical operators short circuit so `cursor < 10 && cell[cursor + 1]`
+	// does not read past the tape
+	switch b.operator {
+	case "&&":
+		if left == 0 {
+			return 0, nil
+		}
+	case "||":
+		if left != 0 {
+			return 1, nil
+		}
+	}
+
+	right, err := b.right.evaluate(env)
+	if err != nil {
+		return 0, err
+	}
+
+	switch b.operator {
+	case "&&", "||":
+		return truth(right != 0), nil
+	case "==":
+		return truth(left == right), nil
+	case "!=":
+		return truth(left != right), nil
+	case "<":
+		return truth(left < right), nil
+	case "<=":
+		return truth(left <= right), nil
+	case ">":
+		return truth(left > right), nil
+	case ">=":
+		return truth(left >= right), nil
+	case "+":
+		return left + right, nil
+	case "-":
+		return left - right, nil
+	case "*":
+		return left * right, nil
+	case "/", "%":
+		if right == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		if b.operator == "/" {
+			return left / right, nil
+		}
+		return left % right, nil
+	}
+
+	return 0, fmt.Errorf("unknown operator '%s'", b.operator)
+}
+
+func truth(value bool) int64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+// precedence of binary operators, higher binds tighter
+var precedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3, "<": 3, "<=": 3, ">": 3, ">=": 3,
+	"+": 4, "-": 4,
+	"*": 5, "/": 5, "%": 5,
+}
+
+var symbols = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "+", "-", "*", "/", "%", "!", "(", ")", "[", "]"}
+
+func tokenize(source string) ([]string, error) {
+	tokens := []string{}
+
+	for i := 0; i < len(source); {
+		char := rune(source[i])
+
+		switch {
+		case unicode.IsSpace(char):
+			i++
+		case unicode.IsDigit(char) || unicode.IsLetter(char):
+			start := i
+			for i < len(source) && (unicode.IsDigit(rune(source[i])) || unicode.IsLetter(rune(source[i]))) {
+				i++
+			}
+			tokens = append(tokens, source[start:i])
+		default:
+			found := false
+			for _, symbol := range symbols {
+				if strings.HasPrefix(source[i:], symbol) {
+					tokens = append(tokens, symbol)
+					i += len(symbol)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unexpected '%c' in expression", char)
+			}
+		}
+	}
+
+	return tokens, nil
+}
+
+type expressionParser struct {
+	tokens []string
+	index  int
+}
+
+func (p *expressionParser) peek() string {
+	if p.index < len(p.tokens) {
+		return p.tokens[p.index]
+	}
+	return ""
+}
+
+func (p *expressionParser) next() string {
+	token := p.peek()
+	p.index++
+	return token
+}
+
+func (p *expressionParser) expect(token string) error {
+	if found := p.next(); found != token {
+		if found == "" {
+			return fmt.Errorf("expected '%s' at the end of the expression", token)
+		}
+		return fmt.Errorf("expected '%s' found '%s'", token, found)
+	}
+	return nil
+}
+
+// parse reads binary operators that bind tighter than minimum.
+func (p *expressionParser) parse(minimum int) (node, error) {
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		operator := p.peek()
+		level, ok := precedence[operator]
+		if !ok || level <= minimum {
+			return left, nil
+		}
+		p.next()
+
+		right, err := p.parse(level)
+		if err != nil {
+			return nil, err
+		}
+		left = binary{operator: operator, left: left, right: right}
+	}
+}
+
+func (p *expressionParser) operand() (node, error) {
+	token := p.next()
+
+	switch {
+	case token == "":
+		return nil, fmt.Errorf("unexpected end of the expression")
+	case token == "-" || token == "!":
+		operand, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		return unary{operator: token, operand: operand}, nil
+	case token == "(":
+		inner, err := p.parse(0)
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expect(")")
+	case token == "cursor":
+		return cursorNode{}, nil
+	case token == "cell" || token == "c":
+		if p.peek() != "[" {
+			return cellNode{}, nil
+		}
+		p.next()
+		index, err := p.parse(0)
+		if err != nil {
+			return nil, err
+		}
+		return cellNode{index: index}, p.expect("]")
+	case unicode.IsDigit(rune(token[0])):
+		value, err := strconv.ParseInt(token, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is not a number", token)
+		}
+		return literal(value), nil
+	}
+
+	return nil, fmt.Errorf("unexpected '%s' in expression", token)
+}
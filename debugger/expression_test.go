@@ -0,0 +1,98 @@
+package debugger
+
+import (
+	"testing"
+
+	"github.com/CanPacis/brainfuck-interpreter/lexer"
+)
+
+type tape struct {
+	cells  []int64
+	cursor int64
+}
+
+func (t tape) Cell(index int64) (int64, bool) {
+	if index < 0 || index >= int64(len(t.cells)) {
+		return 0, false
+	}
+	return t.cells[index], true
+}
+
+func (t tape) Cursor() int64 {
+	return t.cursor
+}
+
+func TestExpressions(t *testing.T) {
+	env := tape{cells: []int64{0, 5, 0, 72}, cursor: 1}
+
+	tests := []struct {
+		source string
+		result int64
+	}{
+		{"cell[3] == 72", 1},
+		{"cell[3] == 0x48", 1},
+		{"cursor > 100", 0},
+		{"cell", 5},
+		{"cell[cursor + 2]", 72},
+		{"c[cursor + 2] + c[1]", 77},
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"10 - 4 - 3", 3},
+		{"-cell % 3", -2},
+		{"!cell || cell[0] == 0", 1},
+		{"cursor < 4 && cell[cursor] != 0", 1},
+		{"cursor > 10 && cell[cursor]", 0},
+	}
+
+	for _, test := range tests {
+		expression, err := Compile(test.source)
+		if err != nil {
+			t.Errorf("Failed to compile %s: %v", test.source, err)
+			continue
+		}
+
+		result, err := expression.Evaluate(env)
+		if err != nil {
+			t.Errorf("Failed to evaluate %s: %v", test.source, err)
+		} else if result != test.result {
+			t.Errorf("Expected %s to be %d found %d", test.source, test.result, result)
+		}
+	}
+
+	for _, source := range []string{"cell[", "cell ==", "tape[0]", "1 $ 2", "(1", "3a"} {
+		if _, err := Compile(source); err == nil {
+			t.Errorf("Expected %s to fail to compile", source)
+		}
+	}
+
+	for _, source := range []string{"cell[4]", "cell / cell[0]"} {
+		expression, _ := Compile(source)
+		if _, err := expression.Evaluate(env); err == nil {
+			t.Errorf("Expected %s to fail to evaluate", source)
+		}
+	}
+}
+
+func TestBreakpointHits(t *testing.T) {
+	env := tape{cells: []int64{0, 5}}
+
+	breakpoint, err := NewBreakpoint(lexer.Position{Line: 1, Column: 1}, lexer.Position{Line: 1}, "cell == 0", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pauses := []bool{}
+	for _, cursor := range []int64{0, 1, 0, 0} {
+		env.cursor = cursor
+		pause, _ := breakpoint.Hit(env)
+		pauses = append(pauses, pause)
+	}
+
+	if pauses[0] || pauses[1] || !pauses[2] || !pauses[3] || breakpoint.Hits != 3 {
+		t.Errorf("Expected to pause from the second time the condition holds found %v after %d hits", pauses, breakpoint.Hits)
+	}
+
+	if _, err := NewBreakpoint(lexer.Position{}, lexer.Position{}, "cell ==", 0); err == nil {
+		t.Error("Expected an invalid condition to fail")
+	}
+}
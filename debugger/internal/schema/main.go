@@ -0,0 +1,26 @@
+// Command schema writes the JSON Schema of the debugger protocol to the
+// file it is given, it is run by go generate in the debugger package.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/CanPacis/brainfuck-interpreter/debugger"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: schema FILE")
+		os.Exit(2)
+	}
+
+	schema, err := debugger.Schema()
+	if err == nil {
+		err = os.WriteFile(os.Args[1], append(schema, 10), 0644)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,279 @@
+package debugger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// ProtocolVersion is the version of the protocol the debugger speaks, it
+// changes whenever a frame changes in a way older clients can't read.
+const ProtocolVersion = 1
+
+// Capability is a part of the protocol a client has to agree on in the
+// handshake before it can use it.
+type Capability string
+
+var (
+	// BreakpointsCapability allows set-breakpoint and clear-breakpoint
+	BreakpointsCapability Capability = "breakpoints"
+	// WatchpointsCapability allows set-watchpoint and clear-watchpoint
+	WatchpointsCapability Capability = "watchpoints"
+	// HistoryCapability allows step-back, reverse-continue and jump, it is
+	// only offered while the execution history is on
+	HistoryCapability Capability = "history"
+	// EditCapability allows assign and move
+	EditCapability Capability = "edit"
+	// InspectCapability allows read-tape, search-tape and evaluate
+	InspectCapability Capability = "inspect"
+)
+
+// Capabilities are all the capabilities a debugger can offer.
+var Capabilities = []Capability{BreakpointsCapability, WatchpointsCapability, HistoryCapability, EditCapability, InspectCapability}
+
+// requires is the capability each operation needs.
+var requires = map[ClientAction]Capability{
+	Assign:          EditCapability,
+	Move:            EditCapability,
+	SetBreakpoint:   BreakpointsCapability,
+	ClearBreakpoint: BreakpointsCapability,
+	SetWatchpoint:   WatchpointsCapability,
+	ClearWatchpoint: WatchpointsCapability,
+	StepBack:        HistoryCapability,
+	ReverseContinue: HistoryCapability,
+	Jump:            HistoryCapability,
+	ReadTape:        InspectCapability,
+	SearchTape:      InspectCapability,
+	Evaluate:        InspectCapability,
+}
+
+// Hello is the first frame a client gets, offering the version and the
+// capabilities of the debugger. The client answers with a HelloOperation
+// and gets another Hello with the capabilities both sides agreed on.
+type Hello struct {
+	Operation    ServerAction `json:"operation"`
+	Id           uint64       `json:"id,omitempty"`
+	Version      int          `json:"version"`
+	Capabilities []Capability `json:"capabilities"`
+}
+
+// HelloOperation is the first operation of a client, it asks for the
+// capabilities it uses or for every one offered when it names none.
+type HelloOperation struct {
+	Operation    ClientAction `json:"operation"`
+	Id           uint64       `json:"id"`
+	Version      int          `json:"version"`
+	Capabilities []Capability `json:"capabilities,omitempty"`
+}
+
+// ErrorCode tells a client why an operation failed.
+type ErrorCode string
+
+var (
+	// InvalidRequest is a frame that isn't valid json or doesn't match the
+	// schema of its operation
+	InvalidRequest     ErrorCode = "invalid-request"
+	UnknownOperation   ErrorCode = "unknown-operation"
+	UnsupportedVersion ErrorCode = "unsupported-version"
+	// HandshakeRequired is an operation sent before the hello operation
+	HandshakeRequired ErrorCode = "handshake-required"
+	// Unsupported is an operation whose capability wasn't agreed on
+	Unsupported ErrorCode = "unsupported"
+	// InvalidArgument is an operation the program can't carry out, like a
+	// breakpoint with a condition that doesn't compile
+	InvalidArgument ErrorCode = "invalid-argument"
+	// OutOfRange is a cell outside the tape, a value too large for a cell
+	// or a step outside the history
+	OutOfRange ErrorCode = "out-of-range"
+	// NotFound is a breakpoint or watchpoint that can't be found
+	NotFound ErrorCode = "not-found"
+	// ReadOnly is a change to the tape while going through the past
+	ReadOnly ErrorCode = "read-only"
+)
+
+// ErrorResponse answers an operation that failed, the program stays paused
+// where it was and the client can send another one.
+type ErrorResponse struct {
+	Operation ServerAction `json:"operation"`
+	Id        uint64       `json:"id"`
+	Code      ErrorCode    `json:"code"`
+	Message   string       `json:"message"`
+}
+
+func (e *ErrorResponse) Error() string {
+	return e.Message
+}
+
+// Errorf creates an error response, the id is filled in once it is sent.
+func Errorf(code ErrorCode, format string, a ...interface{}) *ErrorResponse {
+	return &ErrorResponse{Operation: ErrorAction, Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+// Request is an operation a client sent, Operation is one of the
+// operation types like AssignOperation.
+type Request struct {
+	Id        uint64
+	Action    ClientAction
+	Operation interface{}
+}
+
+// frame is a kind of frame in the protocol, with the operations it is
+// sent with.
+type frame struct {
+	value      interface{}
+	operations []string
+}
+
+// serverFrames and clientFrames are every frame of the protocol, they are
+// what requests are decoded with and what the schema is generated from.
+var serverFrames = []frame{
+	{Hello{}, []string{string(HelloAction)}},
+	{MetaData{}, []string{string(DiscloseMetaData)}},
+	{State{}, []string{string(DiscloseDebugState)}},
+	{StdOut{}, []string{string(StdOutAction), string(StdErrAction)}},
+	{Exit{}, []string{string(ExitAction)}},
+	{ErrorResponse{}, []string{string(ErrorAction)}},
+	{TapeRange{}, []string{string(TapeAction)}},
+	{SearchResult{}, []string{string(SearchResultAction)}},
+	{Evaluation{}, []string{string(EvaluationAction)}},
+}
+
+var clientFrames = []frame{
+	{HelloOperation{}, []string{string(Handshake)}},
+	{PlayerOperation{}, []string{string(Resume), string(Step), string(StepOver), string(StepOut), string(StepBack), string(ReverseContinue)}},
+	{AssignOperation{}, []string{string(Assign)}},
+	{MoveOperation{}, []string{string(Move)}},
+	{JumpOperation{}, []string{string(Jump)}},
+	{BreakpointOperation{}, []string{string(SetBreakpoint), string(ClearBreakpoint)}},
+	{WatchpointOperation{}, []string{string(SetWatchpoint), string(ClearWatchpoint)}},
+	{ReadTapeOperation{}, []string{string(ReadTape)}},
+	{SearchOperation{}, []string{string(SearchTape)}},
+	{EvaluateOperation{}, []string{string(Evaluate)}},
+}
+
+// Decode reads a request from a client. Fields of the wrong type, unknown
+// fields and missing required fields are all invalid requests.
+func Decode(line []byte) (Request, *ErrorResponse) {
+	var envelope struct {
+		Operation ClientAction `json:"operation"`
+		Id        uint64       `json:"id"`
+	}
+	if err := json.Unmarshal(line, &envelope); err != nil {
+		return Request{}, Errorf(InvalidRequest, "the request is not valid: %s", err)
+	}
+	request := Request{Id: envelope.Id, Action: envelope.Operation}
+
+	for _, f := range clientFrames {
+		if !slices.Contains(f.operations, string(envelope.Operation)) {
+			continue
+		}
+
+		t := reflect.TypeOf(f.value)
+		operation := reflect.New(t)
+
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(operation.Interface()); err != nil {
+			return request, Errorf(InvalidRequest, "the %s operation is not valid: %s", envelope.Operation, err)
+		}
+		if missing := missingFields(line, t); len(missing) > 0 {
+			return request, Errorf(InvalidRequest, "the %s operation is missing %s", envelope.Operation, strings.Join(missing, ", "))
+		}
+
+		request.Operation = operation.Elem().Interface()
+		return request, nil
+	}
+
+	if len(envelope.Operation) == 0 {
+		return request, Errorf(InvalidRequest, "the request has no operation")
+	}
+	return request, Errorf(UnknownOperation, "unknown operation '%s'", envelope.Operation)
+}
+
+// missingFields finds the required fields of a type a frame lacks.
+func missingFields(line []byte, t reflect.Type) []string {
+	var present map[string]json.RawMessage
+	json.Unmarshal(line, &present)
+
+	missing := []string{}
+	for _, field := range fields(t) {
+		if _, ok := present[field.name]; field.required && !ok {
+			missing = append(missing, field.name)
+		}
+	}
+	return missing
+}
+
+// jsonField is a field of a struct as it is encoded.
+type jsonField struct {
+	name     string
+	required bool
+	field    reflect.StructField
+}
+
+// fields lists the encoded fields of a struct in order, embedded structs
+// are flattened like encoding/json does. Fields that aren't omitted when
+// empty are required.
+func fields(t reflect.Type) []jsonField {
+	result := []jsonField{}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+
+		if field.Anonymous && len(name) == 0 && field.Type.Kind() == reflect.Struct {
+			result = append(result, fields(field.Type)...)
+			continue
+		}
+		if len(name) == 0 {
+			name = field.Name
+		}
+
+		result = append(result, jsonField{name: name, required: !strings.Contains(options, "omitempty"), field: field})
+	}
+
+	return result
+}
+
+// agree finds the capabilities a client asked for that are offered, all
+// the offered ones if it asked for none.
+func agree(offered []Capability, operation HelloOperation) ([]Capability, *ErrorResponse) {
+	if operation.Version != ProtocolVersion {
+		return nil, Errorf(UnsupportedVersion, "the debugger speaks version %d of the protocol, not %d", ProtocolVersion, operation.Version)
+	}
+	if len(operation.Capabilities) == 0 {
+		return offered, nil
+	}
+
+	agreed := []Capability{}
+	for _, capability := range operation.Capabilities {
+		if !slices.Contains(offered, capability) {
+			return nil, Errorf(Unsupported, "the debugger does not offer %s", capability)
+		}
+		agreed = append(agreed, capability)
+	}
+	return agreed, nil
+}
+
+// AsErrorResponse turns any error into an error response, errors that
+// aren't one already are invalid arguments.
+func AsErrorResponse(err error) *ErrorResponse {
+	var response *ErrorResponse
+	if errors.As(err, &response) {
+		copied := *response
+		return &copied
+	}
+	return Errorf(InvalidArgument, "%s", err.Error())
+}
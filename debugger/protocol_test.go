@@ -0,0 +1,106 @@
+package debugger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		line string
+		code ErrorCode
+	}{
+		{`{"operation":"assign","id":1,"cell":2,"value":65}`, ""},
+		{`{"operation":"resume","id":2}`, ""},
+		{`{"operation":"assign","id":3,"cell":"2","value":65}`, InvalidRequest},
+		{`{"operation":"assign","id":4,"cell":2}`, InvalidRequest},
+		{`{"operation":"assign","id":5,"cell":-1,"value":65}`, InvalidRequest},
+		{`{"operation":"move","id":6,"cell":2,"value":65}`, InvalidRequest},
+		{`{"operation":"step"}`, InvalidRequest},
+		{`{"operation":"fly","id":7}`, UnknownOperation},
+		{`{"id":8}`, InvalidRequest},
+		{`not json`, InvalidRequest},
+	}
+
+	for _, test := range tests {
+		request, err := Decode([]byte(test.line))
+		if len(test.code) == 0 && err != nil {
+			t.Errorf("Expected %s to decode found '%s'", test.line, err.Message)
+		}
+		if len(test.code) > 0 && (err == nil || err.Code != test.code) {
+			t.Errorf("Expected %s to fail with %s found %v", test.line, test.code, err)
+		}
+		if err == nil && request.Action == Assign && request.Operation.(AssignOperation).Value != 65 {
+			t.Errorf("Incorrect operation %+v", request.Operation)
+		}
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	lines := []string{
+		`{"operation":"step","id":1}`,
+		`{"operation":"hello","id":2,"version":99}`,
+		`{"operation":"hello","id":3,"version":1,"capabilities":["edit"]}`,
+		`{"operation":"set-watchpoint","id":4,"start":0}`,
+		`{"operation":"assign","id":5,"cell":"x","value":1}`,
+		`{"operation":"assign","id":6,"cell":0,"value":1}`,
+	}
+
+	var out bytes.Buffer
+	d := newDebugger(&connection{
+		reader:  bufio.NewReader(strings.NewReader(strings.Join(lines, "\n") + "\n")),
+		writer:  &out,
+		ready:   closed(),
+		offered: Capabilities,
+	})
+
+	request, err := d.ShareState(State{Operation: DiscloseDebugState})
+	if err != nil || request.Id != 6 || request.Operation.(AssignOperation).Value != 1 {
+		t.Fatalf("Expected the assign operation found %+v with %v", request, err)
+	}
+
+	expected := []struct {
+		operation ServerAction
+		id        uint64
+		code      ErrorCode
+	}{
+		{DiscloseDebugState, 0, ""},
+		{ErrorAction, 1, HandshakeRequired},
+		{ErrorAction, 2, UnsupportedVersion},
+		{HelloAction, 3, ""},
+		{ErrorAction, 4, Unsupported},
+		{ErrorAction, 5, InvalidRequest},
+	}
+
+	frames := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(frames) != len(expected) {
+		t.Fatalf("Expected %d frames found %q", len(expected), frames)
+	}
+	for i, frame := range frames {
+		var response ErrorResponse
+		json.Unmarshal([]byte(frame), &response)
+		if response.Operation != expected[i].operation || response.Id != expected[i].id || response.Code != expected[i].code {
+			t.Errorf("Expected %+v found %s", expected[i], frame)
+		}
+	}
+}
+
+func TestSchema(t *testing.T) {
+	schema, err := Schema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	committed, err := os.ReadFile("schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(append(schema, 10), committed) {
+		t.Error("schema.json is out of date, run go generate ./debugger")
+	}
+}
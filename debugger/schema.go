@@ -0,0 +1,149 @@
+package debugger
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+//go:generate go run ./internal/schema schema.json
+
+// enums are the values of the string types of the protocol. The operations
+// are taken from the frames instead.
+var enums = map[reflect.Type][]string{
+	reflect.TypeOf(PauseReason("")): {string(EntryPause), string(StepPause), string(DebugPause), string(BreakpointPause), string(WatchpointPause)},
+	reflect.TypeOf(WatchAccess("")): {string(WatchWrite), string(WatchChange)},
+	reflect.TypeOf(TapeFormat("")):  {string(DecimalFormat), string(HexFormat), string(ASCIIFormat)},
+	reflect.TypeOf(Capability("")):  capabilityNames(),
+	reflect.TypeOf(ErrorCode("")): {
+		string(InvalidRequest), string(UnknownOperation), string(UnsupportedVersion), string(HandshakeRequired),
+		string(Unsupported), string(InvalidArgument), string(OutOfRange), string(NotFound), string(ReadOnly),
+	},
+}
+
+func capabilityNames() []string {
+	names := []string{}
+	for _, capability := range Capabilities {
+		names = append(names, string(capability))
+	}
+	return names
+}
+
+// Schema is the JSON Schema of every frame of the protocol, generated from
+// the types of this package. The committed schema.json is kept up to date
+// with go generate.
+func Schema() ([]byte, error) {
+	b := schemaBuilder{defs: map[string]interface{}{}}
+
+	b.defs["ServerFrame"] = map[string]interface{}{
+		"description": "A frame sent by the debugger.",
+		"oneOf":       b.frames(serverFrames),
+	}
+	b.defs["ClientFrame"] = map[string]interface{}{
+		"description": "A frame sent by a client, every one but hello needs the handshake first.",
+		"oneOf":       b.frames(clientFrames),
+	}
+
+	return json.MarshalIndent(map[string]interface{}{
+		"$schema":            "https://json-schema.org/draft/2020-12/schema",
+		"$id":                "https://github.com/CanPacis/brainfuck-interpreter/debugger/schema.json",
+		"title":              "brainfuck-interpreter debugger protocol",
+		"description":        fmt.Sprintf("Version %d of the debugger protocol, frames are json objects sent one per line.", ProtocolVersion),
+		"x-protocol-version": ProtocolVersion,
+		"anyOf": []interface{}{
+			map[string]interface{}{"$ref": "#/$defs/ServerFrame"},
+			map[string]interface{}{"$ref": "#/$defs/ClientFrame"},
+		},
+		"$defs": b.defs,
+	}, "", "  ")
+}
+
+type schemaBuilder struct {
+	defs map[string]interface{}
+}
+
+func ref(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/$defs/" + name}
+}
+
+// frames defines each frame with its operation field limited to the
+// operations it is sent with.
+func (b *schemaBuilder) frames(frames []frame) []interface{} {
+	refs := []interface{}{}
+
+	for _, f := range frames {
+		t := reflect.TypeOf(f.value)
+		object := b.object(t)
+
+		operation := map[string]interface{}{"enum": f.operations}
+		if len(f.operations) == 1 {
+			operation = map[string]interface{}{"const": f.operations[0]}
+		}
+		object["properties"].(map[string]interface{})["operation"] = operation
+
+		b.defs[t.Name()] = object
+		refs = append(refs, ref(t.Name()))
+	}
+
+	return refs
+}
+
+func (b *schemaBuilder) object(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+
+	for _, field := range fields(t) {
+		properties[field.name] = b.schema(field.field.Type)
+		if field.required {
+			required = append(required, field.name)
+		}
+	}
+
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
+
+func (b *schemaBuilder) schema(t reflect.Type) map[string]interface{} {
+	if values, ok := enums[t]; ok {
+		if _, defined := b.defs[t.Name()]; !defined {
+			b.defs[t.Name()] = map[string]interface{}{"type": "string", "enum": values}
+		}
+		return ref(t.Name())
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return b.schema(t.Elem())
+	case reflect.Struct:
+		if _, defined := b.defs[t.Name()]; !defined {
+			// defined before its fields so recursive types end
+			b.defs[t.Name()] = nil
+			b.defs[t.Name()] = b.object(t)
+		}
+		return ref(t.Name())
+	case reflect.Slice:
+		// empty slices may be encoded as null
+		return map[string]interface{}{"type": []string{"array", "null"}, "items": b.schema(t.Elem())}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint8:
+		return map[string]interface{}{"type": "integer", "minimum": 0, "maximum": math.MaxUint8}
+	case reflect.Uint16:
+		return map[string]interface{}{"type": "integer", "minimum": 0, "maximum": math.MaxUint16}
+	case reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "minimum": 0, "maximum": math.MaxUint32}
+	case reflect.Uint, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	}
+
+	return map[string]interface{}{}
+}
@@ -0,0 +1,95 @@
+package debugger
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// TapeFormat is how a range of the tape is rendered as text.
+type TapeFormat string
+
+var (
+	// DecimalFormat renders cells as decimal numbers separated by spaces
+	DecimalFormat TapeFormat = "decimal"
+	// HexFormat renders cells as hexadecimal numbers separated by spaces
+	HexFormat TapeFormat = "hex"
+	// ASCIIFormat renders cells as characters, cells that aren't printable
+	// are shown as dots
+	ASCIIFormat TapeFormat = "ascii"
+)
+
+// DefaultSearchLimit is how many matches a search reports unless it asks
+// for another limit.
+const DefaultSearchLimit = 100
+
+// Render renders cells as text, the format defaults to DecimalFormat.
+func Render(cells []uint32, format TapeFormat) (string, error) {
+	var b strings.Builder
+
+	switch format {
+	case "", DecimalFormat, HexFormat:
+		for i, value := range cells {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			if format == HexFormat {
+				fmt.Fprintf(&b, "%02x", value)
+			} else {
+				fmt.Fprintf(&b, "%d", value)
+			}
+		}
+	case ASCIIFormat:
+		for _, value := range cells {
+			if value >= 32 && value < 127 {
+				b.WriteRune(rune(value))
+			} else {
+				b.WriteByte('.')
+			}
+		}
+	default:
+		return "", Errorf(InvalidArgument, "unknown tape format '%s'", format)
+	}
+
+	return b.String(), nil
+}
+
+// Search finds where a sequence of values starts on the tape from start
+// on. It reports at most limit matches and whether there were more.
+func Search(tape []uint32, sequence []uint32, start uint, limit uint) ([]uint, bool) {
+	matches := []uint{}
+	if len(sequence) == 0 {
+		return matches, false
+	}
+
+	size, length := uint(len(tape)), uint(len(sequence))
+	for cell := start; cell < size && length <= size-cell; cell++ {
+		if !slices.Equal(tape[cell:cell+length], sequence) {
+			continue
+		}
+		if uint(len(matches)) == limit {
+			return matches, true
+		}
+		matches = append(matches, cell)
+	}
+
+	return matches, false
+}
+
+// Sequence is what a search operation looks for, its values or the bytes
+// of its text.
+func (o SearchOperation) Sequence() ([]uint32, error) {
+	switch {
+	case len(o.Values) > 0 && len(o.Text) > 0:
+		return nil, Errorf(InvalidArgument, "a search is either for values or for text, not both")
+	case len(o.Values) > 0:
+		return o.Values, nil
+	case len(o.Text) > 0:
+		sequence := []uint32{}
+		for _, b := range []byte(o.Text) {
+			sequence = append(sequence, uint32(b))
+		}
+		return sequence, nil
+	}
+	return nil, Errorf(InvalidArgument, "a search needs values or text to look for")
+}
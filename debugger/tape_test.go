@@ -0,0 +1,54 @@
+package debugger
+
+import "testing"
+
+func TestRender(t *testing.T) {
+	cells := []uint32{72, 105, 10, 255}
+
+	tests := []struct {
+		format TapeFormat
+		text   string
+	}{
+		{"", "72 105 10 255"},
+		{HexFormat, "48 69 0a ff"},
+		{ASCIIFormat, "Hi.."},
+	}
+
+	for _, test := range tests {
+		if text, err := Render(cells, test.format); err != nil || text != test.text {
+			t.Errorf("Expected %s to render %q found %q with %v", test.format, test.text, text, err)
+		}
+	}
+
+	if _, err := Render(cells, "octal"); err == nil {
+		t.Error("Expected an unknown format to fail")
+	}
+}
+
+func TestSearch(t *testing.T) {
+	tape := []uint32{1, 2, 1, 2, 1, 2, 0}
+
+	matches, more := Search(tape, []uint32{1, 2}, 0, 2)
+	if len(matches) != 2 || matches[1] != 2 || !more {
+		t.Errorf("Expected the first two matches and more found %v %v", matches, more)
+	}
+
+	matches, more = Search(tape, []uint32{2, 0}, 1, 10)
+	if len(matches) != 1 || matches[0] != 5 || more {
+		t.Errorf("Expected a match at 5 found %v %v", matches, more)
+	}
+
+	for _, start := range []uint{7, 1 << 63, ^uint(0)} {
+		if matches, more := Search(tape, []uint32{0}, start, 5); len(matches) != 0 || more {
+			t.Errorf("Expected no matches from %d found %v %v", start, matches, more)
+		}
+	}
+
+	sequence, err := SearchOperation{Text: "Hi"}.Sequence()
+	if err != nil || len(sequence) != 2 || sequence[0] != 72 {
+		t.Errorf("Incorrect sequence %v with %v", sequence, err)
+	}
+	if _, err := (SearchOperation{Text: "Hi", Values: []uint32{1}}).Sequence(); err == nil {
+		t.Error("Expected a search for both values and text to fail")
+	}
+}
@@ -0,0 +1,87 @@
+package debugger
+
+import "fmt"
+
+// WatchAccess is which writes to a watched cell pause the program.
+type WatchAccess string
+
+var (
+	// WatchWrite pauses on every write, even one that leaves the value as
+	// it was
+	WatchWrite WatchAccess = "write"
+	// WatchChange only pauses when the value changes
+	WatchChange WatchAccess = "change"
+)
+
+// Watchpoint pauses the program right after a statement writes to a cell
+// from Start to End. From and To only match changes from or to a value.
+type Watchpoint struct {
+	Start  uint        `json:"start"`
+	End    uint        `json:"end"`
+	Access WatchAccess `json:"access"`
+	From   *uint32     `json:"from,omitempty"`
+	To     *uint32     `json:"to,omitempty"`
+	Hits   uint        `json:"hits"`
+}
+
+// NewWatchpoint validates a watchpoint, an End of 0 only watches Start and
+// the access defaults to WatchWrite.
+func NewWatchpoint(operation WatchpointOperation) (*Watchpoint, error) {
+	watchpoint := &Watchpoint{
+		Start:  operation.Start,
+		End:    operation.End,
+		Access: operation.Access,
+		From:   operation.From,
+		To:     operation.To,
+	}
+
+	if watchpoint.End == 0 {
+		watchpoint.End = watchpoint.Start
+	}
+	if watchpoint.End < watchpoint.Start {
+		return nil, fmt.Errorf("the watched range %d..%d is empty", watchpoint.Start, watchpoint.End)
+	}
+
+	switch watchpoint.Access {
+	case "":
+		watchpoint.Access = WatchWrite
+	case WatchWrite, WatchChange:
+	default:
+		return nil, fmt.Errorf("unknown watch access '%s'", watchpoint.Access)
+	}
+
+	return watchpoint, nil
+}
+
+// Watches reports whether the watchpoint covers a cell.
+func (w *Watchpoint) Watches(cell uint) bool {
+	return cell >= w.Start && cell <= w.End
+}
+
+// Matches reports whether a write to a watched cell matches without
+// counting a hit.
+func (w *Watchpoint) Matches(before, after uint32) bool {
+	changed := before != after
+	if (w.Access == WatchChange || w.From != nil || w.To != nil) && !changed {
+		return false
+	}
+	return (w.From == nil || *w.From == before) && (w.To == nil || *w.To == after)
+}
+
+// Hit counts a write to a watched cell and reports whether it matches.
+func (w *Watchpoint) Hit(before, after uint32) bool {
+	if !w.Matches(before, after) {
+		return false
+	}
+
+	w.Hits++
+	return true
+}
+
+// WatchHit is the write that paused the program at a watchpoint.
+type WatchHit struct {
+	Watchpoint Watchpoint `json:"watchpoint"`
+	Cell       uint       `json:"cell"`
+	Old        uint32     `json:"old"`
+	New        uint32     `json:"new"`
+}
@@ -0,0 +1,42 @@
+package debugger
+
+import "testing"
+
+func TestWatchpoints(t *testing.T) {
+	to := uint32(4)
+
+	tests := []struct {
+		operation WatchpointOperation
+		writes    [][2]uint32
+		hits      []bool
+	}{
+		{WatchpointOperation{Start: 1}, [][2]uint32{{0, 0}, {0, 1}}, []bool{true, true}},
+		{WatchpointOperation{Start: 1, Access: WatchChange}, [][2]uint32{{0, 0}, {0, 1}}, []bool{false, true}},
+		{WatchpointOperation{Start: 1, To: &to}, [][2]uint32{{3, 4}, {4, 4}, {4, 5}}, []bool{true, false, false}},
+		{WatchpointOperation{Start: 1, From: &to}, [][2]uint32{{3, 4}, {4, 5}}, []bool{false, true}},
+	}
+
+	for _, test := range tests {
+		watchpoint, err := NewWatchpoint(test.operation)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i, write := range test.writes {
+			if hit := watchpoint.Hit(write[0], write[1]); hit != test.hits[i] {
+				t.Errorf("Expected %v for %v with %+v found %v", test.hits[i], write, test.operation, hit)
+			}
+		}
+	}
+
+	watchpoint, _ := NewWatchpoint(WatchpointOperation{Start: 2, End: 4})
+	if watchpoint.Watches(1) || !watchpoint.Watches(2) || !watchpoint.Watches(4) || watchpoint.Watches(5) {
+		t.Errorf("Incorrect range %d..%d", watchpoint.Start, watchpoint.End)
+	}
+
+	for _, operation := range []WatchpointOperation{{Start: 4, End: 2}, {Access: "read"}} {
+		if _, err := NewWatchpoint(operation); err == nil {
+			t.Errorf("Expected %+v to fail", operation)
+		}
+	}
+}
@@ -0,0 +1,508 @@
+package engine
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/CanPacis/brainfuck-interpreter/bf_errors"
+	"github.com/CanPacis/brainfuck-interpreter/debugger"
+	"github.com/CanPacis/brainfuck-interpreter/lexer"
+	"github.com/CanPacis/brainfuck-interpreter/parser"
+)
+
+type stepMode int
+
+const (
+	// stepNone runs until a debug statement or a breakpoint
+	stepNone stepMode = iota
+	stepIn
+	stepOver
+	stepOut
+)
+
+// debugSession decides where the tree-walker pauses for the debugger. It
+// tracks loop depth and breakpoints instead of flagging statements, so the
+// parsed program is never changed while debugging.
+type debugSession struct {
+	mode    stepMode
+	started bool
+	noWait  bool
+	// stopOnEntry pauses before the first statement and whenever a client
+	// attaches while the program runs
+	stopOnEntry bool
+	// depth is the loop depth of the statement being run and requested is
+	// the depth a step over or step out was requested at
+	depth     int
+	requested int
+	// request is the id of the operation the program was resumed with,
+	// which the state shared at the next pause answers
+	request uint64
+	// breakpoints are sorted by position
+	breakpoints []*debugger.Breakpoint
+	watchpoints []*debugger.Watchpoint
+}
+
+// debugEnv lets breakpoint conditions read the engine.
+type debugEnv struct {
+	e *Engine
+}
+
+func (d debugEnv) Cell(index int64) (int64, bool) {
+	if index < 0 || index >= int64(len(d.e.Tape)) {
+		return 0, false
+	}
+	return int64(d.e.Tape[index]), true
+}
+
+func (d debugEnv) Cursor() int64 {
+	return int64(d.e.Cursor)
+}
+
+// pauseReason decides whether the tree-walker pauses before a statement. A
+// breakpoint whose condition fails pauses with the error.
+func (e *Engine) pauseReason(statement parser.Statement) (debugger.PauseReason, *debugger.Breakpoint, error) {
+	if !e.debug.started {
+		e.debug.started = true
+		if e.debug.stopOnEntry {
+			return debugger.EntryPause, nil, nil
+		}
+	}
+
+	if breakpoint := e.breakpoint(statement.Position); breakpoint != nil {
+		if pause, err := breakpoint.Hit(debugEnv{e}); pause {
+			return debugger.BreakpointPause, breakpoint, err
+		}
+	}
+
+	if statement.DebugTarget {
+		return debugger.DebugPause, nil, nil
+	}
+
+	pause := false
+	switch e.debug.mode {
+	case stepIn:
+		pause = true
+	case stepOver:
+		pause = e.debug.depth <= e.debug.requested
+	case stepOut:
+		pause = e.debug.depth < e.debug.requested
+	}
+
+	if pause {
+		return debugger.StepPause, nil, nil
+	}
+	return "", nil, nil
+}
+
+func (e *Engine) breakpoint(position lexer.Position) *debugger.Breakpoint {
+	index, found := slices.BinarySearchFunc(e.debug.breakpoints, position, comparePositions)
+	if !found {
+		return nil
+	}
+	return e.debug.breakpoints[index]
+}
+
+func comparePositions(breakpoint *debugger.Breakpoint, position lexer.Position) int {
+	if breakpoint.Line != position.Line {
+		return int(breakpoint.Line) - int(position.Line)
+	}
+	return int(breakpoint.Column) - int(position.Column)
+}
+
+// resolve finds the first statement at or after a position, breakpoints
+// can only be on statements.
+func (e *Engine) resolve(line, column uint) (lexer.Position, bool) {
+	var found *lexer.Position
+
+	var walk func(program []parser.Statement)
+	walk = func(program []parser.Statement) {
+		for _, statement := range program {
+			if found != nil {
+				return
+			}
+			if statement.Type == "Loop Done" {
+				continue
+			}
+			if statement.Line > line || statement.Line == line && statement.Column >= column {
+				found = &statement.Position
+				return
+			}
+			walk(statement.Body)
+		}
+	}
+	walk(e.Parser.Program)
+
+	if found == nil {
+		return lexer.Position{}, false
+	}
+	return *found, true
+}
+
+// setBreakpoint returns the position the breakpoint moved to. Setting the
+// breakpoint again from where it was requested changes it, but one
+// requested elsewhere can't take over its statement.
+func (e *Engine) setBreakpoint(operation debugger.BreakpointOperation) (lexer.Position, error) {
+	position, ok := e.resolve(operation.Line, operation.Column)
+	if !ok {
+		return position, debugger.Errorf(debugger.NotFound, "there is no statement at or after %d:%d", operation.Line, operation.Column)
+	}
+
+	requested := lexer.Position{Line: operation.Line, Column: operation.Column}
+	breakpoint, err := debugger.NewBreakpoint(position, requested, operation.Condition, operation.HitCount)
+	if err != nil {
+		return position, err
+	}
+
+	index, found := slices.BinarySearchFunc(e.debug.breakpoints, position, comparePositions)
+	if found {
+		if existing := e.debug.breakpoints[index]; existing.Requested != requested {
+			return position, debugger.Errorf(debugger.InvalidArgument, "%d:%d moves to the statement at %d:%d, which already has the breakpoint requested at %d:%d", operation.Line, operation.Column, position.Line, position.Column, existing.Requested.Line, existing.Requested.Column)
+		}
+		e.debug.breakpoints[index] = breakpoint
+	} else {
+		e.debug.breakpoints = slices.Insert(e.debug.breakpoints, index, breakpoint)
+	}
+	return position, nil
+}
+
+func (e *Engine) clearBreakpoint(operation debugger.BreakpointOperation) error {
+	position, _ := e.resolve(operation.Line, operation.Column)
+
+	index, found := slices.BinarySearchFunc(e.debug.breakpoints, position, comparePositions)
+	if !found {
+		return debugger.Errorf(debugger.NotFound, "there is no breakpoint at %d:%d", operation.Line, operation.Column)
+	}
+
+	e.debug.breakpoints = slices.Delete(e.debug.breakpoints, index, index+1)
+	return nil
+}
+
+func (e *Engine) setWatchpoint(operation debugger.WatchpointOperation) error {
+	watchpoint, err := debugger.NewWatchpoint(operation)
+	if err != nil {
+		return err
+	}
+	if high := uint(e.high()); watchpoint.End > high {
+		return debugger.Errorf(debugger.OutOfRange, "the watched range %d..%d goes past cell %d, the last one on the tape", watchpoint.Start, watchpoint.End, high)
+	}
+
+	for i, existing := range e.debug.watchpoints {
+		if existing.Start == watchpoint.Start && existing.End == watchpoint.End {
+			e.debug.watchpoints[i] = watchpoint
+			return nil
+		}
+	}
+
+	e.debug.watchpoints = append(e.debug.watchpoints, watchpoint)
+	return nil
+}
+
+func (e *Engine) clearWatchpoint(operation debugger.WatchpointOperation) error {
+	end := max(operation.End, operation.Start)
+
+	for i, watchpoint := range e.debug.watchpoints {
+		if watchpoint.Start == operation.Start && watchpoint.End == end {
+			e.debug.watchpoints = slices.Delete(e.debug.watchpoints, i, i+1)
+			return nil
+		}
+	}
+
+	return debugger.Errorf(debugger.NotFound, "there is no watchpoint on %d..%d", operation.Start, end)
+}
+
+// cellWrite is a watched cell a statement is about to write and its value
+// before the write.
+type cellWrite struct {
+	cell uint
+	old  uint32
+}
+
+func (e *Engine) watched(cell uint) bool {
+	for _, watchpoint := range e.debug.watchpoints {
+		if watchpoint.Watches(cell) {
+			return true
+		}
+	}
+	return false
+}
+
+// writes finds the watched cells a statement is about to write.
+func (e *Engine) writes(statement parser.Statement) []cellWrite {
+	writes := []cellWrite{}
+
+	switch statement.Type {
+	case "Increment Statement", "Decrement Statement", "Stdin Statement":
+		if e.Cursor < uint(len(e.Tape)) && e.watched(e.Cursor) {
+			writes = append(writes, cellWrite{cell: e.Cursor, old: e.Tape[e.Cursor]})
+		}
+	case "Clear Statement":
+		for _, watchpoint := range e.debug.watchpoints {
+			for cell := watchpoint.Start; cell <= watchpoint.End && cell < uint(len(e.Tape)); cell++ {
+				writes = append(writes, cellWrite{cell: cell, old: e.Tape[cell]})
+			}
+		}
+	}
+
+	return writes
+}
+
+// r_watch_s pauses right after a statement that wrote a watched cell, the
+// state carries the statement that wrote it.
+func (e *Engine) r_watch_s(statement parser.Statement, writes []cellWrite) bf_errors.RuntimeError {
+	for _, write := range writes {
+		value := e.Tape[write.cell]
+
+		for _, watchpoint := range e.debug.watchpoints {
+			if !watchpoint.Watches(write.cell) || !watchpoint.Hit(write.old, value) {
+				continue
+			}
+
+			return e.pause(statement, debugger.State{
+				Reason: debugger.WatchpointPause,
+				Watch:  &debugger.WatchHit{Watchpoint: *watchpoint, Cell: write.cell, Old: write.old, New: value},
+			})
+		}
+	}
+
+	return bf_errors.EmptyError
+}
+
+// r_debug_s shares the state with the debugger before a statement runs and
+// waits until the client decides how to continue.
+func (e *Engine) r_debug_s(statement parser.Statement) bf_errors.RuntimeError {
+	// loop done statements only mark the end of a loop
+	if statement.Type == "Loop Done" || !e.Debugger.Attached() {
+		return bf_errors.EmptyError
+	}
+
+	// a client that attaches while the program runs can get an entry pause
+	if e.Debugger.Reattached() {
+		e.debug.started = false
+	}
+
+	reason, hit, failure := e.pauseReason(statement)
+	if len(reason) == 0 {
+		return bf_errors.EmptyError
+	}
+
+	pause := debugger.State{Reason: reason, Breakpoint: hit}
+	if failure != nil {
+		pause.Error = failure.Error()
+	}
+	return e.pause(statement, pause)
+}
+
+var modes = map[debugger.ClientAction]stepMode{
+	debugger.Step:     stepIn,
+	debugger.StepOver: stepOver,
+	debugger.StepOut:  stepOut,
+	debugger.Resume:   stepNone,
+}
+
+// pause shares the state until the client decides how to continue. The
+// reason, breakpoint, watch and error of pause are kept every time the
+// state is shared. While the client goes through the past the program
+// stays paused at the live step. Operations that fail are answered with an
+// error response instead of the state, and queries with their own response.
+func (e *Engine) pause(statement parser.Statement, pause debugger.State) bf_errors.RuntimeError {
+	live := moment{statement: statement, pause: pause}
+	now := live
+
+	// the first state answers the request the program was resumed with
+	id := e.debug.request
+	var failure error
+	var response interface{}
+	var resolved *lexer.Position
+
+	for {
+		var request debugger.Request
+		var err error
+
+		if failure != nil {
+			request, err = e.Debugger.Fail(id, failure)
+		} else if response != nil {
+			request, err = e.Debugger.Respond(response)
+		} else {
+			state := e.CreateDebugState(now.statement)
+			state.Id = id
+			state.Reason = now.pause.Reason
+			state.Breakpoint = now.pause.Breakpoint
+			state.Watch = now.pause.Watch
+			state.Error = now.pause.Error
+			state.Replay = e.replayState()
+			state.Resolved = resolved
+
+			request, err = e.Debugger.ShareState(state)
+		}
+
+		if errors.Is(err, debugger.ErrDetached) {
+			// nobody is left to pause for, so the program comes back to the
+			// present and runs on
+			e.jump(e.history.live, live)
+			e.debug.mode = stepNone
+			e.debug.request = 0
+			return bf_errors.EmptyError
+		}
+		if err != nil {
+			return bf_errors.CreateUncaughtError(err, statement.Position, e.Path)
+		}
+
+		id, failure, response, resolved = request.Id, nil, nil, nil
+		past := e.history.position < e.history.live
+
+		// the depth the client is looking at
+		depth := e.debug.depth
+		if past {
+			depth = e.history.entry(e.history.position).depth
+		}
+
+		switch operation := request.Operation.(type) {
+		case debugger.PlayerOperation:
+			switch request.Action {
+			case debugger.StepBack:
+				var earlier moment
+				if earlier, failure = e.stepBack(); failure == nil {
+					now = earlier
+				}
+				continue
+			case debugger.ReverseContinue:
+				earlier, err := e.reverseContinue()
+				if len(earlier.pause.Reason) == 0 {
+					failure = err
+					continue
+				}
+
+				// a failing breakpoint condition still goes back to it
+				now = earlier
+				if err != nil {
+					now.pause.Error = err.Error()
+				}
+				continue
+			}
+
+			mode := modes[request.Action]
+			if past {
+				var paused bool
+				if now, paused = e.forward(mode, depth, live); paused {
+					continue
+				}
+			}
+
+			e.debug.mode = mode
+			e.debug.requested = depth
+			e.debug.request = id
+			return bf_errors.EmptyError
+		case debugger.JumpOperation:
+			var then moment
+			if then, failure = e.jump(operation.Step, live); failure == nil {
+				now = then
+			}
+		case debugger.MoveOperation:
+			if failure = e.editable(past, operation.Cell); failure == nil {
+				e.Cursor = operation.Cell
+			}
+		case debugger.AssignOperation:
+			if failure = e.editable(past, operation.Cell); failure == nil {
+				if operation.Value > e.tape.mask {
+					failure = debugger.Errorf(debugger.OutOfRange, "%d does not fit in a cell, the largest value is %d", operation.Value, e.tape.mask)
+				} else {
+					e.Tape[operation.Cell] = operation.Value
+				}
+			}
+		case debugger.BreakpointOperation:
+			if request.Action == debugger.SetBreakpoint {
+				var position lexer.Position
+				if position, failure = e.setBreakpoint(operation); failure == nil {
+					resolved = &position
+				}
+			} else {
+				failure = e.clearBreakpoint(operation)
+			}
+		case debugger.WatchpointOperation:
+			if request.Action == debugger.SetWatchpoint {
+				failure = e.setWatchpoint(operation)
+			} else {
+				failure = e.clearWatchpoint(operation)
+			}
+		case debugger.ReadTapeOperation:
+			response, failure = e.readTape(operation)
+		case debugger.SearchOperation:
+			response, failure = e.searchTape(operation)
+		case debugger.EvaluateOperation:
+			response, failure = e.evaluate(operation)
+		}
+	}
+}
+
+// editable checks a cell can be changed by the client.
+func (e *Engine) editable(past bool, cell uint) error {
+	if past {
+		return debugger.Errorf(debugger.ReadOnly, "the past cannot be changed, jump to the live step first")
+	}
+	if cell >= uint(len(e.Tape)) {
+		return debugger.Errorf(debugger.OutOfRange, "cell %d is outside the tape of %d cells", cell, len(e.Tape))
+	}
+	return nil
+}
+
+// readTape reads a range of the tape as it is at the step the client is
+// looking at.
+func (e *Engine) readTape(operation debugger.ReadTapeOperation) (interface{}, error) {
+	size := uint(len(e.Tape))
+	if operation.Start >= size || operation.Count > size-operation.Start {
+		return nil, debugger.Errorf(debugger.OutOfRange, "cells %d to %d are not all on the tape of %d cells", operation.Start, operation.Start+operation.Count, size)
+	}
+
+	cells := e.Tape[operation.Start : operation.Start+operation.Count]
+	format := operation.Format
+	if len(format) == 0 {
+		format = debugger.DecimalFormat
+	}
+
+	text, err := debugger.Render(cells, format)
+	if err != nil {
+		return nil, err
+	}
+
+	return debugger.TapeRange{
+		Operation: debugger.TapeAction,
+		Id:        operation.Id,
+		Start:     operation.Start,
+		Cells:     cells,
+		Format:    format,
+		Text:      text,
+	}, nil
+}
+
+func (e *Engine) searchTape(operation debugger.SearchOperation) (interface{}, error) {
+	sequence, err := operation.Sequence()
+	if err != nil {
+		return nil, err
+	}
+
+	if size := uint(len(e.Tape)); operation.Start >= size {
+		return nil, debugger.Errorf(debugger.OutOfRange, "cell %d is outside the tape of %d cells", operation.Start, size)
+	}
+
+	limit := operation.Limit
+	if limit == 0 {
+		limit = debugger.DefaultSearchLimit
+	}
+
+	matches, more := debugger.Search(e.Tape, sequence, operation.Start, limit)
+	return debugger.SearchResult{Operation: debugger.SearchResultAction, Id: operation.Id, Matches: matches, More: more}, nil
+}
+
+func (e *Engine) evaluate(operation debugger.EvaluateOperation) (interface{}, error) {
+	expression, err := debugger.Compile(operation.Expression)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := expression.Evaluate(debugEnv{e})
+	if err != nil {
+		return nil, err
+	}
+
+	return debugger.Evaluation{Operation: debugger.EvaluationAction, Id: operation.Id, Expression: operation.Expression, Value: value}, nil
+}
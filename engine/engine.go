@@ -1,126 +1,136 @@
 package engine
 
 import (
+	"context"
+	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
 	"os"
 	"path"
+	"slices"
 	"sync"
+	"time"
 
 	"github.com/CanPacis/brainfuck-interpreter/bf_errors"
 	"github.com/CanPacis/brainfuck-interpreter/bf_io"
 	"github.com/CanPacis/brainfuck-interpreter/debugger"
+	"github.com/CanPacis/brainfuck-interpreter/ir"
 	"github.com/CanPacis/brainfuck-interpreter/lexer"
 	"github.com/CanPacis/brainfuck-interpreter/parser"
+	"github.com/CanPacis/brainfuck-interpreter/trace"
 	"github.com/CanPacis/brainfuck-interpreter/waiter"
 )
 
 type Engine struct {
-	Path               string
-	Name               string
-	Content            string
-	Debugger           debugger.Debugger
-	Parser             parser.Parser
-	Tape               [30000]byte
-	Cursor             uint
-	IOTargets          []bf_io.RuntimeIO
-	IOSourceList       bf_io.IOSourceList
-	ioTargetType       bf_io.IOTargetType
-	originalIO         bf_io.RuntimeIO
-	disposers          []func()
-	waiters            waiter.EngineWaiter
-	httpServer         *http.Server
-	debuggerSteppedOut bool
+	Path         string
+	Name         string
+	Content      string
+	Debugger     debugger.Debugger
+	Parser       parser.Parser
+	Tape         []uint32
+	Cursor       uint
+	Mode         ExecutionMode
+	IOTargets    []bf_io.RuntimeIO
+	IOSourceList bf_io.IOSourceList
+	ioTargetType bf_io.IOTargetType
+	originalIO   bf_io.RuntimeIO
+	disposers    []func()
+	waiters      waiter.EngineWaiter
+	httpServer   *http.Server
+	tcp          *bf_io.TcpIO
+	debug        debugSession
+	history      history
+	tape         tapeOptions
+	eof          EOFBehavior
+	err          bf_errors.RuntimeError
+	limits       limits
+	allowedIO    map[bf_io.IOTargetType]bool
+	parsed       bool
+	parseErr     bf_errors.RuntimeError
+	tracer       trace.Tracer
+	traceSteps   uint64
 }
 
 func run(e *Engine, p *[]parser.Statement) bf_errors.RuntimeError {
 	index := 0
-	shouldResume := false
 	program := *p
 
 	for ; index < len(program); index++ {
 		statement := program[index]
 
-		if e.Debugger.Exists && statement.DebugTarget && !shouldResume && !e.debuggerSteppedOut {
-			operation, action, err := e.Debugger.ShareState(e.CreateDebugState(statement))
-
-			if err != nil {
-				return bf_errors.CreateUncaughtError(err, statement.Position, e.Path)
+		if e.limits.active {
+			if err := e.tick(statement.Position); err.Reason != nil {
+				return err
 			}
+		}
 
-			switch operation {
-			case "step":
-				if len(program) > index+1 {
-					program[index+1].DebugTarget = true
-				}
-			case "step-over":
-				if len(program) > index+2 {
-					program[index+2].DebugTarget = true
-				}
-			case "move":
-				e.Cursor = action.(debugger.MoveOperation).Cell
-				if len(program) > index+1 {
-					program[index+1].DebugTarget = true
-				}
-			case "resume":
-				shouldResume = true
-				if len(program) > index+1 {
-					program[index+1].DebugTarget = false
-				}
-			case "assign":
-				o := action.(debugger.AssignOperation)
-				e.Tape[o.Cell] = o.Value
-			case "step-out":
-				for _, statment := range *p {
-					statment.DebugTarget = false
+		var writes []cellWrite
+		recorded := false
+		if e.Debugger.Exists {
+			if err := e.r_debug_s(statement); err.Reason != nil {
+				return err
+			}
+			if len(e.debug.watchpoints) > 0 && e.Debugger.Attached() {
+				writes = e.writes(statement)
+			}
+			if statement.Type != "Loop Done" {
+				// only a client that can go back needs the history
+				if recorded = e.Debugger.Agreed(debugger.HistoryCapability); recorded {
+					e.record(statement)
+				} else {
+					e.history.clear()
 				}
-				e.debuggerSteppedOut = true
 			}
 		}
 
+		var event trace.Event
+		tracing := e.tracer != nil && statement.Type != "Loop Done"
+		if tracing {
+			event = e.traceStart(traceKinds[statement.Type], statement)
+			if statement.Type == "Loop Statement" {
+				// a loop is traced when it is reached, its iterations by
+				// r_loop_s
+				e.traceEnd(event, bf_errors.EmptyError)
+				tracing = false
+			}
+		}
+
+		var err bf_errors.RuntimeError
 		switch statement.Type {
 		case "Increment Statement":
-			e.r_increment_s()
+			err = e.r_increment_s(statement)
 		case "Decrement Statement":
-			e.r_decrement_s()
+			err = e.r_decrement_s(statement)
 		case "Clear Statement":
 			e.r_clear_s()
 		case "Move Right Statement":
-			if err := e.r_move_right_s(statement); err.Reason != nil {
-				return err
-			}
+			err = e.r_move_right_s(statement)
 		case "Move Left Statement":
-			if err := e.r_move_left_s(statement); err.Reason != nil {
-				return err
-			}
+			err = e.r_move_left_s(statement)
 		case "Loop Statement":
-			if statement.DebugTarget && len(statement.Body) > 0 && !shouldResume {
-				if e.debuggerSteppedOut {
-					e.debuggerSteppedOut = false
-				} else {
-					statement.Body[0].DebugTarget = true
-				}
-			}
-			if err := e.r_loop_s(statement); err.Reason != nil {
-				return err
-			}
-		case "Loop Done":
-			if e.debuggerSteppedOut {
-				if len(program) > index+1 {
-					program[index+1].DebugTarget = true
-					e.debuggerSteppedOut = false
-				}
-			}
+			err = e.r_loop_s(statement)
 		case "Stdout Statement":
-			if err := e.r_stdout_s(statement); err.Reason != nil {
-				return err
-			}
+			err = e.r_stdout_s(statement)
 		case "Stdin Statement":
-			if err := e.r_stdin_s(statement); err.Reason != nil {
-				return err
-			}
+			err = e.r_stdin_s(statement)
 		case "Switch IO Statement":
-			if err := e.r_switch_io_s(statement); err.Reason != nil {
+			err = e.r_switch_io_s(statement)
+		}
+
+		if tracing {
+			e.traceEnd(event, err)
+		}
+		if err.Reason != nil {
+			return err
+		}
+
+		if recorded {
+			e.finish(statement)
+		}
+
+		if len(writes) > 0 {
+			if err := e.r_watch_s(statement, writes); err.Reason != nil {
 				return err
 			}
 		}
@@ -137,7 +147,7 @@ func (e *Engine) dispose(err bf_errors.RuntimeError) {
 	if err.Reason != nil {
 		err.Write(e.originalIO.Err)
 		if e.Debugger.Exists {
-			e.Debugger.Close(1)
+			e.Debugger.Close(ExitStatus(err))
 		}
 	} else {
 		if e.Debugger.Exists {
@@ -148,9 +158,99 @@ func (e *Engine) dispose(err bf_errors.RuntimeError) {
 	if e.httpServer != nil {
 		e.httpServer.Close()
 	}
+
+	if e.tcp != nil {
+		e.tcp.Close()
+	}
 }
 
-func (e *Engine) Run() {
+func (e *Engine) parse() bf_errors.RuntimeError {
+	if !e.parsed {
+		e.parsed = true
+		e.parseErr = e.Parser.Parse(e.Content)
+	}
+
+	return e.parseErr
+}
+
+func (e *Engine) allowed(target bf_io.IOTargetType) bool {
+	return e.allowedIO == nil || target == bf_io.Std || e.allowedIO[target]
+}
+
+// Preflight parses the program without running it and reports every io
+// target it could switch to. The error is a bf_errors.PermissionError for
+// the first switch to a target that isn't allowed.
+func (e *Engine) Preflight() ([]bf_io.IOTargetType, error) {
+	if e.err.Reason != nil {
+		return nil, e.err
+	}
+
+	if err := e.parse(); err.Reason != nil {
+		return nil, err
+	}
+
+	targets := []bf_io.IOTargetType{}
+	var denied error
+	for _, statement := range parser.IOTargets(e.Parser.Program) {
+		targets = append(targets, statement.IOTarget)
+		if denied == nil && !e.allowed(statement.IOTarget) {
+			denied = e.permissionError(statement)
+		}
+	}
+
+	return targets, denied
+}
+
+// ExitError is returned by Run when the program fails, use errors.As to
+// get the bf_errors.RuntimeError it wraps.
+type ExitError struct {
+	Status int
+	Err    bf_errors.RuntimeError
+}
+
+func (err *ExitError) Error() string {
+	return err.Err.Error()
+}
+
+func (err *ExitError) Unwrap() error {
+	return err.Err
+}
+
+// ExitStatus is the status a process running the program should exit with,
+// 2 for syntax errors and 1 for any other error.
+func ExitStatus(err bf_errors.RuntimeError) int {
+	switch {
+	case err.Reason == nil:
+		return 0
+	case err.Type == bf_errors.SyntaxError:
+		return 2
+	default:
+		return 1
+	}
+}
+
+func (e *Engine) fail(err bf_errors.RuntimeError) (int, error) {
+	e.dispose(err)
+
+	status := ExitStatus(err)
+	return status, &ExitError{Status: status, Err: err}
+}
+
+// Run parses and runs the program. It returns the exit status along with an
+// *ExitError when the program fails.
+func (e *Engine) Run() (int, error) {
+	return e.RunContext(context.Background())
+}
+
+// RunContext is like Run but stops the program with a bf_errors.CanceledError
+// when ctx is done.
+func (e *Engine) RunContext(ctx context.Context) (int, error) {
+	if e.err.Reason != nil {
+		return e.fail(e.err)
+	}
+
+	defer e.limit(ctx)()
+
 	if e.Debugger.Exists {
 		data := debugger.MetaData{
 			Operation: debugger.DiscloseMetaData,
@@ -158,65 +258,257 @@ func (e *Engine) Run() {
 			FilePath:  e.Path,
 			Content:   e.Content,
 		}
-		_, err := e.Debugger.Client.WriteOperation(data)
+		err := e.Debugger.Disclose(data)
+
+		if err == nil && !e.debug.noWait {
+			err = e.Debugger.Wait(e.limits.ctx)
+		}
 
 		if err != nil {
-			e.dispose(bf_errors.CreateUncaughtError(err, lexer.Position{}, e.Path))
-			os.Exit(1)
+			return e.fail(bf_errors.CreateUncaughtError(err, lexer.Position{}, e.Path))
 		}
 	}
 
-	err := e.Parser.Parse(e.Content)
+	err := e.parse()
 	if err.Reason != nil {
-		e.dispose(err)
-		os.Exit(1)
+		return e.fail(err)
+	}
+
+	if err := e.runProgram(e.Parser.Program); err.Reason != nil {
+		return e.fail(err)
+	}
+
+	if err := e.wait(waiter.Program, lexer.Position{}); err.Reason != nil {
+		return e.fail(err)
 	}
+	e.dispose(bf_errors.EmptyError)
+	return 0, nil
+}
+
+// Eval parses source and runs it from where the last piece left the tape,
+// the cursor and the io target, so that a program can be given a piece at
+// a time. Errors are returned as an *ExitError instead of written to
+// stderr, and the engine stays open for the next piece until Close.
+func (e *Engine) Eval(ctx context.Context, source string) error {
+	if e.err.Reason != nil {
+		return &ExitError{Status: ExitStatus(e.err), Err: e.err}
+	}
+
+	defer e.limit(ctx)()
 
-	err = run(e, &e.Parser.Program)
+	p := parser.NewParser(e.Path)
+	err := p.Parse(source)
+	if err.Reason == nil {
+		err = e.runProgram(p.Program)
+	}
 	if err.Reason != nil {
-		e.dispose(err)
-		os.Exit(1)
+		return &ExitError{Status: ExitStatus(err), Err: err}
 	}
 
-	e.waiters.Wait(waiter.Program)
+	if err := e.wait(waiter.Program, lexer.Position{}); err.Reason != nil {
+		return &ExitError{Status: ExitStatus(err), Err: err}
+	}
+	return nil
+}
+
+// Close ends the io targets of an engine that ran pieces with Eval.
+func (e *Engine) Close() {
 	e.dispose(bf_errors.EmptyError)
 }
 
+// TapeBytes copies the low byte of every cell, which is the whole tape of
+// 8 bit cells the way Tape used to hold it.
+func (e *Engine) TapeBytes() []byte {
+	tape := make([]byte, len(e.Tape))
+	for i, cell := range e.Tape {
+		tape[i] = byte(cell)
+	}
+	return tape
+}
+
+// IOTarget is the io target the program last switched to.
+func (e *Engine) IOTarget() bf_io.IOTargetType {
+	return e.ioTargetType
+}
+
+// limit starts counting a run against the limits, the returned function
+// stops its timeout.
+func (e *Engine) limit(ctx context.Context) context.CancelFunc {
+	cancel := context.CancelFunc(func() {})
+	if e.limits.timeout > 0 {
+		ctx, cancel = context.WithTimeoutCause(ctx, e.limits.timeout, errTimeout)
+	}
+
+	e.limits.ctx = ctx
+	e.limits.steps = 0
+	e.limits.output = 0
+	e.limits.active = e.limits.maxSteps > 0 || ctx.Done() != nil
+	return cancel
+}
+
+// runProgram runs a parsed program with the executor of the engine's mode.
+func (e *Engine) runProgram(program []parser.Statement) bf_errors.RuntimeError {
+	if e.Debugger.Exists || e.Mode == TreeWalker || e.tracer != nil {
+		// the debugger and tracers work on statements, so they get the
+		// unfolded program
+		return run(e, &program)
+	}
+
+	folded := ir.Compile(program, ir.Options{
+		StrictCells: e.tape.cellOverflow != WrapCells,
+	})
+	if e.Mode == JIT {
+		if err, ran := runJIT(e, folded); ran {
+			return err
+		}
+	}
+	return execute(e, assemble(folded))
+}
+
 func (e *Engine) CreateDebugState(statement parser.Statement) debugger.State {
-	var tape []byte
-
-	for i, value := range e.Tape {
-		if value == 0 && len(e.Tape) > i+1 && e.Tape[i+1] == 0 {
-			if i < 50 {
-				tape = e.Tape[:50]
-			} else {
-				tape = e.Tape[:i]
-			}
+	// the zeroes after the last cell in use are left out
+	end := min(int(e.Cursor)+1, len(e.Tape))
+	for i := len(e.Tape) - 1; i >= end; i-- {
+		if e.Tape[i] != 0 {
+			end = i + 1
 			break
 		}
 	}
 
+	breakpoints := []debugger.Breakpoint{}
+	for _, breakpoint := range e.debug.breakpoints {
+		breakpoints = append(breakpoints, *breakpoint)
+	}
+
+	watchpoints := []debugger.Watchpoint{}
+	for _, watchpoint := range e.debug.watchpoints {
+		watchpoints = append(watchpoints, *watchpoint)
+	}
+
 	return debugger.State{
-		Operation: debugger.DiscloseDebugState,
-		Statement: statement,
-		Cursor:    e.Cursor,
-		Tape:      tape,
+		Operation:   debugger.DiscloseDebugState,
+		Statement:   statement,
+		Cursor:      e.Cursor,
+		Tape:        e.Tape[:end],
+		TapeSize:    uint(len(e.Tape)),
+		Breakpoints: breakpoints,
+		Watchpoints: watchpoints,
 	}
 }
 
+// capabilities are the parts of the debugger protocol the engine offers,
+// going back in time needs the execution history.
+func (e *Engine) capabilities() []debugger.Capability {
+	capabilities := []debugger.Capability{}
+	for _, capability := range debugger.Capabilities {
+		if capability != debugger.HistoryCapability || e.history.limit >= 0 {
+			capabilities = append(capabilities, capability)
+		}
+	}
+	return capabilities
+}
+
+// EOFBehavior decides what a `,` does to the current cell once the input
+// has ended.
+type EOFBehavior string
+
+const (
+	EOFZero      EOFBehavior = "zero"
+	EOFMinusOne  EOFBehavior = "minus-one"
+	EOFUnchanged EOFBehavior = "unchanged"
+	EOFError     EOFBehavior = "error"
+)
+
 type EngineOptions struct {
+	// FilePath is where NewEngine reads the program from, engines created
+	// from a source, a reader or a file system only use it in diagnostics
 	FilePath       string
 	AttachDebugger bool
-	Stdout         io.Writer
-	Stderr         io.Writer
-	Stdin          io.Reader
-	IOSourceList   bf_io.IOSourceList
+	// DebugListen makes the debugger wait for clients on a tcp or unix
+	// socket instead of stdout and stdin, see debugger.Listen. The program
+	// starts once a client attaches unless DebugNoWait is set.
+	DebugListen string
+	DebugNoWait bool
+	// DebugStopOnEntry pauses the program before its first statement, so
+	// that a client can set breakpoints before anything runs. Otherwise it
+	// first pauses at a debug statement.
+	DebugStopOnEntry bool
+	// HistoryLimit is roughly how many bytes the execution history of a
+	// debugged program may use, it defaults to DefaultHistoryLimit and can
+	// be NoHistory
+	HistoryLimit int
+	Mode         ExecutionMode
+	// TapeSize is the number of cells, it defaults to DefaultTapeSize and
+	// can be UnboundedTape
+	TapeSize        int
+	CellWidth       CellWidth
+	CellOverflow    CellOverflowPolicy
+	PointerOverflow PointerOverflowPolicy
+	EOF             EOFBehavior
+	// MaxSteps, Timeout and MaxOutput stop programs that run for too many
+	// steps, too long or write too much, zero means there is no limit
+	MaxSteps  uint64
+	Timeout   time.Duration
+	MaxOutput uint64
+	// AllowedIO is the io targets a program may switch to, std is always
+	// allowed and nil allows every target
+	AllowedIO    []bf_io.IOTargetType
+	Stdout       io.Writer
+	Stderr       io.Writer
+	Stdin        io.Reader
+	IOSourceList bf_io.IOSourceList
+	// Tracer gets every step the program takes, tracing runs the program
+	// on the tree-walker so every step is a statement of the source
+	Tracer trace.Tracer
 }
 
+// NewEngine creates an engine for the program at options.FilePath.
 func NewEngine(options EngineOptions) *Engine {
-	name := path.Base(options.FilePath)
 	content, err := os.ReadFile(options.FilePath)
 
+	return newEngine(string(content), err, options)
+}
+
+// NewEngineFromSource creates an engine for a program that is already in
+// memory.
+func NewEngineFromSource(source string, options EngineOptions) *Engine {
+	return newEngine(source, nil, options)
+}
+
+// NewEngineFromReader creates an engine for the program read from r.
+func NewEngineFromReader(r io.Reader, options EngineOptions) (*Engine, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return newEngine(string(content), nil, options), nil
+}
+
+// NewEngineFromFS creates an engine for the program at name in fsys, so
+// programs can be shipped with embed. The name is used in diagnostics
+// unless options.FilePath is set.
+func NewEngineFromFS(fsys fs.FS, name string, options EngineOptions) (*Engine, error) {
+	content, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(options.FilePath) == 0 {
+		options.FilePath = name
+	}
+
+	return newEngine(string(content), nil, options), nil
+}
+
+func newEngine(content string, err error, options EngineOptions) *Engine {
+	if len(options.FilePath) == 0 {
+		options.FilePath = "<source>"
+	}
+
+	name := path.Base(options.FilePath)
+	size, tape, optionsErr := newTapeOptions(options)
+
 	std := bf_io.RuntimeIO{
 		Out: options.Stdout,
 		In:  options.Stdin,
@@ -226,7 +518,7 @@ func NewEngine(options EngineOptions) *Engine {
 	e := &Engine{
 		Name:      name,
 		Path:      options.FilePath,
-		Content:   string(content),
+		Content:   content,
 		Parser:    parser.NewParser(options.FilePath),
 		IOTargets: []bf_io.RuntimeIO{std},
 		waiters: waiter.EngineWaiter{
@@ -235,6 +527,44 @@ func NewEngine(options EngineOptions) *Engine {
 			Write:          &sync.WaitGroup{},
 		},
 		IOSourceList: options.IOSourceList,
+		Mode:         options.Mode,
+		tracer:       options.Tracer,
+		Tape:         make([]uint32, size),
+		tape:         tape,
+		eof:          options.EOF,
+		history:      history{limit: options.HistoryLimit},
+		limits: limits{
+			maxSteps:  options.MaxSteps,
+			timeout:   options.Timeout,
+			maxOutput: options.MaxOutput,
+		},
+	}
+
+	switch e.eof {
+	case "":
+		e.eof = EOFZero
+	case EOFZero, EOFMinusOne, EOFUnchanged, EOFError:
+	default:
+		optionsErr = fmt.Errorf("unknown end of input behavior '%s'", e.eof)
+	}
+
+	if len(e.Mode) == 0 {
+		e.Mode = VirtualMachine
+	}
+
+	if e.history.limit == 0 {
+		e.history.limit = DefaultHistoryLimit
+	}
+
+	if options.AllowedIO != nil {
+		e.allowedIO = map[bf_io.IOTargetType]bool{}
+		for _, target := range options.AllowedIO {
+			// the targets the io keyword can switch to
+			if !slices.Contains(lexer.Keywords, target) {
+				optionsErr = fmt.Errorf("unknown io target '%s'", target)
+			}
+			e.allowedIO[target] = true
+		}
 	}
 
 	if len(e.IOSourceList.File) == 0 {
@@ -245,18 +575,28 @@ func NewEngine(options EngineOptions) *Engine {
 		e.IOSourceList.Http = ":8080"
 	}
 
+	if len(e.IOSourceList.Tcp) == 0 {
+		e.IOSourceList.Tcp = ":8081"
+	}
+
 	e.IOTargets[0].Init(e.IOTargets[0])
 	e.originalIO.Init(e.IOTargets[0])
 	e.ioTargetType = bf_io.Std
 
-	if options.AttachDebugger {
-		debugger_instance, err := debugger.NewDebugger()
+	if len(options.DebugListen) > 0 {
+		debugger_instance, debuggerErr := debugger.Listen(options.DebugListen, e.capabilities()...)
 
-		if err != nil {
-			e.originalIO.Err.Write([]byte("Failed to create a debugger"))
-			e.originalIO.Err.Write([]byte{10})
-			e.originalIO.Err.Write([]byte(err.Error()))
-			e.originalIO.Err.Write([]byte{10})
+		if debuggerErr != nil && err == nil {
+			err = fmt.Errorf("failed to create a debugger: %w", debuggerErr)
+		}
+
+		e.Debugger = debugger_instance
+		e.debug.noWait = options.DebugNoWait
+	} else if options.AttachDebugger {
+		debugger_instance, debuggerErr := debugger.NewDebugger(e.capabilities()...)
+
+		if debuggerErr != nil && err == nil {
+			err = fmt.Errorf("failed to create a debugger: %w", debuggerErr)
 		}
 
 		e.Debugger = debugger_instance
@@ -268,12 +608,15 @@ func NewEngine(options EngineOptions) *Engine {
 		e.IOTargets = []bf_io.RuntimeIO{*io.Init(io)}
 		e.originalIO = e.IOTargets[0]
 	}
+	e.debug.stopOnEntry = options.DebugStopOnEntry
+
+	if err == nil {
+		err = optionsErr
+	}
 
 	if err != nil {
-		e.originalIO.Err.Write([]byte(err.Error()))
-		e.originalIO.Err.Write([]byte{10})
-		e.dispose(bf_errors.CreateUncaughtError(err, lexer.Position{}, e.Path))
-		os.Exit(1)
+		// reported by Run so that creating an engine never fails
+		e.err = bf_errors.CreateUncaughtError(err, lexer.Position{}, e.Path)
 	}
 
 	return e
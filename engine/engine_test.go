@@ -2,7 +2,25 @@ package engine
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/CanPacis/brainfuck-interpreter/bf_errors"
+	"github.com/CanPacis/brainfuck-interpreter/bf_io"
+	"github.com/CanPacis/brainfuck-interpreter/debugger"
+	"github.com/CanPacis/brainfuck-interpreter/lexer"
+	"github.com/CanPacis/brainfuck-interpreter/parser"
+	"github.com/CanPacis/brainfuck-interpreter/trace"
 )
 
 func TestAdd(t *testing.T) {
@@ -10,7 +28,7 @@ func TestAdd(t *testing.T) {
 	stderr := bytes.Buffer{}
 
 	r := NewEngine(EngineOptions{
-		FilePath: "../bf/add.bf",
+		FilePath: "../bf/add.bfi",
 		Stdout:   &stdout,
 		Stderr:   &stderr,
 	})
@@ -34,7 +52,7 @@ func TestHelloWorld(t *testing.T) {
 	stdout := bytes.Buffer{}
 
 	r := NewEngine(EngineOptions{
-		FilePath: "../bf/hello_world.bf",
+		FilePath: "../bf/hello_world.bfi",
 		Stdout:   &stdout,
 	})
 
@@ -46,3 +64,826 @@ func TestHelloWorld(t *testing.T) {
 		t.Errorf("Incorrect stdout expected %s found %s", string(expected), string(found))
 	}
 }
+
+func TestModes(t *testing.T) {
+	for _, mode := range []ExecutionMode{VirtualMachine, TreeWalker, JIT} {
+		stdout := bytes.Buffer{}
+
+		r := NewEngine(EngineOptions{
+			FilePath: "../bf/hello_world.bfi",
+			Stdout:   &stdout,
+			Mode:     mode,
+		})
+
+		r.Run()
+
+		expected := []byte("Hello World!\n")
+		found := stdout.Bytes()
+		if !bytes.Equal(expected, found) {
+			t.Errorf("Incorrect stdout in %s mode expected %s found %s", mode, string(expected), string(found))
+		}
+	}
+}
+
+func BenchmarkModes(b *testing.B) {
+	for _, mode := range []ExecutionMode{VirtualMachine, TreeWalker} {
+		b.Run(string(mode), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				r := NewEngine(EngineOptions{
+					FilePath: "../bf/hello_world.bfi",
+					Stdout:   &bytes.Buffer{},
+					Mode:     mode,
+				})
+
+				r.Run()
+			}
+		})
+	}
+}
+
+// compute is a program that runs for a while without io, its nested loops
+// can't be folded.
+const compute = "++++++++[>-[>-[>+[-]<-]<-]<-]>>>."
+
+func BenchmarkCompute(b *testing.B) {
+	for _, mode := range []ExecutionMode{VirtualMachine, TreeWalker, JIT} {
+		b.Run(string(mode), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				NewEngineFromSource(compute, EngineOptions{
+					Stdout: &bytes.Buffer{},
+					Mode:   mode,
+				}).Run()
+			}
+		})
+	}
+}
+
+func runSource(t *testing.T, source string, options EngineOptions) (*Engine, []byte) {
+	stdout := bytes.Buffer{}
+	options.Stdout = &stdout
+
+	r := NewEngineFromSource(source, options)
+	r.Run()
+
+	return r, stdout.Bytes()
+}
+
+func TestSources(t *testing.T) {
+	stdout := bytes.Buffer{}
+	r, err := NewEngineFromFS(os.DirFS("../bf"), "hello_world.bfi", EngineOptions{Stdout: &stdout})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Run()
+
+	if stdout.String() != "Hello World!\n" || r.Name != "hello_world.bfi" {
+		t.Errorf("Incorrect stdout from file system found %s", stdout.String())
+	}
+
+	stdout.Reset()
+	r, err = NewEngineFromReader(strings.NewReader("++++++[>++++++++<-]>+."), EngineOptions{Stdout: &stdout, FilePath: "generated.bfi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Run()
+
+	if stdout.String() != "1" || r.Path != "generated.bfi" {
+		t.Errorf("Incorrect stdout from reader found %s", stdout.String())
+	}
+
+	if _, err := NewEngineFromFS(os.DirFS("../bf"), "missing.bfi", EngineOptions{}); err == nil {
+		t.Errorf("Expected an error for a missing program")
+	}
+}
+
+func TestIdioms(t *testing.T) {
+	// multiplies 6 by 7 with a copy loop, then scans to both ends
+	source := "++++++[->+++++++>+<<]>>>+<[<]>.[>]<."
+
+	_, vm := runSource(t, source, EngineOptions{Mode: VirtualMachine})
+	_, tree := runSource(t, source, EngineOptions{Mode: TreeWalker})
+
+	if !bytes.Equal(vm, tree) || len(vm) != 2 {
+		t.Errorf("Modes disagree, vm found %v tree found %v", vm, tree)
+	}
+}
+
+func TestJIT(t *testing.T) {
+	sources := []struct {
+		source  string
+		options EngineOptions
+	}{
+		{source: compute},
+		{source: "++++++[->+++++++>+<<]>>>+<[<]>.[>]<."},
+		{source: ",[.,]", options: EngineOptions{Stdin: strings.NewReader("echo")}},
+		{source: "+++*.>"},
+		{source: "-[--->+<]>.", options: EngineOptions{CellWidth: Cell16}},
+		{source: "->-[-<+>]<.", options: EngineOptions{CellWidth: Cell32}},
+		{source: "+\n>+[<]"},
+		{source: "+[>+]", options: EngineOptions{TapeSize: 5}},
+		{source: "+[->+<]<", options: EngineOptions{TapeSize: 5}},
+		{source: "+>>>", options: EngineOptions{TapeSize: 2}},
+	}
+
+	for _, s := range sources {
+		run := func(mode ExecutionMode) (*Engine, string, error) {
+			options := s.options
+			options.Mode = mode
+			options.Stderr = &bytes.Buffer{}
+			if options.Stdin != nil {
+				input, _ := io.ReadAll(options.Stdin)
+				options.Stdin = bytes.NewReader(input)
+				s.options.Stdin = bytes.NewReader(input)
+			}
+
+			stdout := bytes.Buffer{}
+			options.Stdout = &stdout
+			r := NewEngineFromSource(s.source, options)
+			_, err := r.Run()
+			return r, stdout.String(), err
+		}
+
+		vm, vmOutput, vmErr := run(VirtualMachine)
+		jit, jitOutput, jitErr := run(JIT)
+
+		// where the cursor is after a fault is up to the executor
+		if vmOutput != jitOutput || (vmErr == nil && vm.Cursor != jit.Cursor) || !slices.Equal(vm.Tape, jit.Tape) {
+			t.Errorf("Modes disagree on %q, vm found %q at %d jit found %q at %d", s.source, vmOutput, vm.Cursor, jitOutput, jit.Cursor)
+		}
+		if (vmErr == nil) != (jitErr == nil) || (vmErr != nil && vmErr.Error() != jitErr.Error()) {
+			t.Errorf("Modes disagree on %q, vm failed with %v jit failed with %v", s.source, vmErr, jitErr)
+		}
+	}
+}
+
+func TestTapeOptions(t *testing.T) {
+	for _, mode := range []ExecutionMode{VirtualMachine, TreeWalker, JIT} {
+		r, _ := runSource(t, "-", EngineOptions{Mode: mode, CellWidth: Cell16})
+		if r.Tape[0] != 65535 {
+			t.Errorf("Expected 16 bit cell to wrap to 65535 in %s mode found %d", mode, r.Tape[0])
+		}
+		if tape := r.TapeBytes(); len(tape) != DefaultTapeSize || tape[0] != 255 {
+			t.Errorf("Expected the bytes of the tape to keep the low byte in %s mode found %d", mode, tape[0])
+		}
+
+		r, _ = runSource(t, "--", EngineOptions{Mode: mode, CellOverflow: SaturateCells})
+		if r.Tape[0] != 0 {
+			t.Errorf("Expected cell to saturate at 0 in %s mode found %d", mode, r.Tape[0])
+		}
+
+		r, _ = runSource(t, strings.Repeat(">", 40000)+"+", EngineOptions{Mode: mode, TapeSize: UnboundedTape})
+		if r.Cursor != 40000 || r.Tape[40000] != 1 {
+			t.Errorf("Expected tape to grow in %s mode", mode)
+		}
+
+		r, _ = runSource(t, "<+", EngineOptions{Mode: mode, TapeSize: 10, PointerOverflow: WrapPointer})
+		if r.Cursor != 9 || r.Tape[9] != 1 {
+			t.Errorf("Expected cursor to wrap in %s mode found %d", mode, r.Cursor)
+		}
+	}
+}
+
+func TestEOF(t *testing.T) {
+	expected := map[EOFBehavior]uint32{
+		EOFZero:      0,
+		EOFMinusOne:  255,
+		EOFUnchanged: 1,
+	}
+
+	for behavior, value := range expected {
+		r, _ := runSource(t, "+,", EngineOptions{EOF: behavior, Stdin: strings.NewReader("")})
+		if r.Tape[0] != value {
+			t.Errorf("Expected %d after end of input with %s found %d", value, behavior, r.Tape[0])
+		}
+	}
+
+	// only stops once a 16 bit minus one is incremented back to zero
+	_, output := runSource(t, ",+[-.,+]", EngineOptions{EOF: EOFMinusOne, CellWidth: Cell16, Stdin: strings.NewReader("ab\xff")})
+	if string(output) != "ab\xff" {
+		t.Errorf("Incorrect stdout expected %q found %q", "ab\xff", string(output))
+	}
+}
+
+func TestTcp(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	done := make(chan struct{})
+	go func() {
+		// echoes two clients, a zero ends each connection and the zero
+		// before any client leaves them alone
+		runSource(t, "io tcp\n.,[.,].,[.,].", EngineOptions{Timeout: 5 * time.Second, IOSourceList: bf_io.IOSourceList{Tcp: address}})
+		close(done)
+	}()
+
+	var connections []*net.TCPConn
+	for _, message := range []string{"first", "second"} {
+		var connection net.Conn
+		for i := 0; i < 100; i++ {
+			if connection, err = net.Dial("tcp", address); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		connection.Write([]byte(message))
+		connection.(*net.TCPConn).CloseWrite()
+		connections = append(connections, connection.(*net.TCPConn))
+	}
+
+	for i, expected := range []string{"first", "second"} {
+		found, _ := io.ReadAll(connections[i])
+		if string(found) != expected {
+			t.Errorf("Incorrect tcp response expected %s found %s", expected, string(found))
+		}
+	}
+	<-done
+
+	listener, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		connection, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		connection.Write([]byte("dialed"))
+		connection.(*net.TCPConn).CloseWrite()
+		io.Copy(connection, connection)
+	}()
+
+	_, output := runSource(t, "io tcp\n>,[>,]<[<]>io std\n[.>]", EngineOptions{IOSourceList: bf_io.IOSourceList{Tcp: listener.Addr().String(), TcpDial: true}})
+	if string(output) != "dialed" {
+		t.Errorf("Incorrect stdout expected dialed found %s", string(output))
+	}
+}
+
+func TestErrors(t *testing.T) {
+	stderr := bytes.Buffer{}
+	r := NewEngineFromSource("+\n>+[<]", EngineOptions{Stderr: &stderr})
+	status, err := r.Run()
+
+	var runtimeError bf_errors.RuntimeError
+	if status != 1 || !errors.As(err, &runtimeError) {
+		t.Fatalf("Expected a runtime error found %d %v", status, err)
+	}
+	if runtimeError.Type != bf_errors.StackUnderflowError || runtimeError.Position != (lexer.Position{Line: 2, Column: 4}) {
+		t.Errorf("Incorrect runtime error %+v", runtimeError)
+	}
+	if stderr.Len() == 0 {
+		t.Errorf("Expected the error to be written to stderr")
+	}
+
+	r = NewEngineFromSource("io\n", EngineOptions{Stderr: &bytes.Buffer{}})
+	if status, err := r.Run(); status != 2 || !errors.As(err, &runtimeError) || runtimeError.Type != bf_errors.SyntaxError {
+		t.Errorf("Expected a syntax error found %d %v", status, err)
+	}
+
+	r = NewEngine(EngineOptions{FilePath: "../bf/missing.bfi", Stderr: &bytes.Buffer{}})
+	if status, err := r.Run(); status != 1 || !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected a missing file error found %d %v", status, err)
+	}
+}
+
+func TestEval(t *testing.T) {
+	stdout := bytes.Buffer{}
+	r := NewEngineFromSource("", EngineOptions{FilePath: "<repl>", Stdout: &stdout, Stderr: &bytes.Buffer{}})
+	defer r.Close()
+
+	for _, source := range []string{"++++++++[>++++++++<-]", ">+.", "+."} {
+		if err := r.Eval(context.Background(), source); err != nil {
+			t.Fatalf("Unexpected error running %q %v", source, err)
+		}
+	}
+	if stdout.String() != "AB" || r.Cursor != 1 {
+		t.Errorf("Expected the tape to carry over found %q at %d", stdout.String(), r.Cursor)
+	}
+
+	var exitError *ExitError
+	if err := r.Eval(context.Background(), "\n<<"); !errors.As(err, &exitError) || exitError.Err.Type != bf_errors.StackUnderflowError || exitError.Err.Position != (lexer.Position{Line: 2, Column: 2}) {
+		t.Errorf("Expected a stack underflow on the second line found %v", err)
+	}
+	if err := r.Eval(context.Background(), "."); err != nil || stdout.String() != "ABB" {
+		t.Errorf("Expected the engine to go on after an error found %q %v", stdout.String(), err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	runLimited := func(source string, options EngineOptions, ctx context.Context) bf_errors.RuntimeError {
+		options.Stdout = &bytes.Buffer{}
+		options.Stderr = &bytes.Buffer{}
+		_, err := NewEngineFromSource(source, options).RunContext(ctx)
+
+		var runtimeError bf_errors.RuntimeError
+		errors.As(err, &runtimeError)
+		return runtimeError
+	}
+
+	for _, mode := range []ExecutionMode{VirtualMachine, TreeWalker, JIT} {
+		if err := runLimited("+[]", EngineOptions{Mode: mode, MaxSteps: 1000}, context.Background()); err.Type != bf_errors.StepLimitError {
+			t.Errorf("Expected a step limit error in %s mode found %v", mode, err.Reason)
+		}
+
+		if err := runLimited("+[]", EngineOptions{Mode: mode, Timeout: 10 * time.Millisecond}, context.Background()); err.Type != bf_errors.TimeoutError || err.Position.Column != 2 {
+			t.Errorf("Expected a timeout error in %s mode found %v", mode, err.Reason)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+		if err := runLimited("+[]", EngineOptions{Mode: mode}, ctx); err.Type != bf_errors.CanceledError {
+			t.Errorf("Expected a canceled error in %s mode found %v", mode, err.Reason)
+		}
+
+		if err := runLimited("+[.]", EngineOptions{Mode: mode, MaxOutput: 5}, context.Background()); err.Type != bf_errors.OutputLimitError {
+			t.Errorf("Expected an output limit error in %s mode found %v", mode, err.Reason)
+		}
+
+		// a scan around a tape without a zero cell
+		endless := EngineOptions{Mode: mode, TapeSize: 2, PointerOverflow: WrapPointer}
+		endless.MaxSteps = 1000
+		if err := runLimited("+>+[>]", endless, context.Background()); err.Type != bf_errors.StepLimitError {
+			t.Errorf("Expected a step limit error for an endless scan in %s mode found %v", mode, err.Reason)
+		}
+		endless.MaxSteps = 0
+		endless.Timeout = 10 * time.Millisecond
+		if err := runLimited("+>+[>]", endless, context.Background()); err.Type != bf_errors.TimeoutError {
+			t.Errorf("Expected a timeout error for an endless scan in %s mode found %v", mode, err.Reason)
+		}
+		endless.Timeout = 0
+		ctx, cancel = context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+		if err := runLimited("+>+[>]", endless, ctx); err.Type != bf_errors.CanceledError {
+			t.Errorf("Expected a canceled error for an endless scan in %s mode found %v", mode, err.Reason)
+		}
+	}
+}
+
+// blocked is a reader that never returns, like a terminal nobody types in.
+type blocked struct{}
+
+func (blocked) Read([]byte) (int, error) {
+	select {}
+}
+
+func TestBlockedIO(t *testing.T) {
+	runBlocked := func(source string, options EngineOptions, ctx context.Context) bf_errors.RuntimeError {
+		options.Stdin = blocked{}
+		options.Stdout = &bytes.Buffer{}
+		options.Stderr = &bytes.Buffer{}
+		_, err := NewEngineFromSource(source, options).RunContext(ctx)
+
+		var runtimeError bf_errors.RuntimeError
+		errors.As(err, &runtimeError)
+		return runtimeError
+	}
+
+	for _, mode := range []ExecutionMode{VirtualMachine, TreeWalker, JIT} {
+		if err := runBlocked("+,.", EngineOptions{Mode: mode, Timeout: 10 * time.Millisecond}, context.Background()); err.Type != bf_errors.TimeoutError || err.Position.Column != 2 {
+			t.Errorf("Expected a read that never returns to time out in %s mode found %v", mode, err.Reason)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+		if err := runBlocked("+,.", EngineOptions{Mode: mode}, ctx); err.Type != bf_errors.CanceledError {
+			t.Errorf("Expected a read that never returns to be canceled in %s mode found %v", mode, err.Reason)
+		}
+	}
+
+	// nobody connects
+	tcp := EngineOptions{Timeout: 10 * time.Millisecond, IOSourceList: bf_io.IOSourceList{Tcp: "127.0.0.1:0"}}
+	if err := runBlocked("io tcp\n,", tcp, context.Background()); err.Type != bf_errors.TimeoutError || err.Position.Line != 2 {
+		t.Errorf("Expected waiting for a tcp client to time out found %v", err.Reason)
+	}
+
+	// a read given up on still hands its byte to the next one
+	stdin, writer := io.Pipe()
+	stdout := bytes.Buffer{}
+	r := NewEngineFromSource("", EngineOptions{FilePath: "<repl>", Stdin: stdin, Stdout: &stdout, Stderr: &bytes.Buffer{}})
+	defer r.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := r.Eval(ctx, ","); err == nil {
+		t.Fatal("Expected the read to time out")
+	}
+	go writer.Write([]byte("A"))
+	if err := r.Eval(context.Background(), ",."); err != nil || stdout.String() != "A" {
+		t.Errorf("Expected the byte of the read given up on found %q %v", stdout.String(), err)
+	}
+}
+
+func TestStepCounts(t *testing.T) {
+	steps := func(source string, mode ExecutionMode, maxSteps uint64) (uint64, bf_errors.RuntimeError) {
+		r := NewEngineFromSource(source, EngineOptions{Mode: mode, MaxSteps: maxSteps, TapeSize: 16, Stdout: &bytes.Buffer{}, Stderr: &bytes.Buffer{}})
+		_, err := r.Run()
+
+		var runtimeError bf_errors.RuntimeError
+		errors.As(err, &runtimeError)
+		return r.limits.steps, runtimeError
+	}
+
+	for _, program := range []struct {
+		source string
+		// folded loops stop at their start, not in their body
+		folded bool
+	}{
+		{source: "+++++>>--<."},
+		{source: "++[>+++.<-]"},
+		{source: "++[>++[>+.<-]<-]*"},
+		{source: "+++[-]>-[+]", folded: true},
+		{source: "+++[->++>+<<]", folded: true},
+		{source: ">+>+>+<<[>]<[<]>>>>[>]>>+>>+[<<]", folded: true},
+	} {
+		source := program.source
+		tree, _ := steps(source, TreeWalker, 1<<20)
+		if vm, err := steps(source, VirtualMachine, 1<<20); vm != tree || err.Reason != nil {
+			t.Errorf("Expected %s to run %d steps in vm mode found %d %v", source, tree, vm, err.Reason)
+		}
+
+		for limit := uint64(1); limit < tree && !program.folded; limit++ {
+			_, treeError := steps(source, TreeWalker, limit)
+			_, vmError := steps(source, VirtualMachine, limit)
+			if treeError.Type != bf_errors.StepLimitError || vmError.Position != treeError.Position {
+				t.Errorf("Expected %s to stop at %v after %d steps in vm mode found %v", source, treeError.Position, limit, vmError.Position)
+			}
+		}
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	source := "+[\nio file\n-]\nio http\n"
+	r := NewEngineFromSource(source, EngineOptions{AllowedIO: []bf_io.IOTargetType{bf_io.File}, Stderr: &bytes.Buffer{}})
+
+	targets, err := r.Preflight()
+	if strings.Join(targets, ",") != "file,http" {
+		t.Errorf("Incorrect io targets %v", targets)
+	}
+
+	var runtimeError bf_errors.RuntimeError
+	if !errors.As(err, &runtimeError) || runtimeError.Type != bf_errors.PermissionError || runtimeError.Position.Line != 4 {
+		t.Errorf("Expected a permission error for http found %v", err)
+	}
+
+	r = NewEngineFromSource("io file\n", EngineOptions{AllowedIO: []bf_io.IOTargetType{}, Stderr: &bytes.Buffer{}})
+	if _, err := r.Run(); !errors.As(err, &runtimeError) || runtimeError.Type != bf_errors.PermissionError {
+		t.Errorf("Expected a permission error for file found %v", err)
+	}
+
+	r = NewEngineFromSource("io std\n+.", EngineOptions{AllowedIO: []bf_io.IOTargetType{}, Stdout: &bytes.Buffer{}})
+	if _, err := r.Run(); err != nil {
+		t.Errorf("Expected std to always be allowed found %v", err)
+	}
+
+	r = NewEngineFromSource("+", EngineOptions{AllowedIO: []bf_io.IOTargetType{"fiel"}, Stderr: &bytes.Buffer{}})
+	if _, err := r.Preflight(); err == nil || !strings.Contains(err.Error(), "unknown io target 'fiel'") {
+		t.Errorf("Expected fiel to be rejected found %v", err)
+	}
+	if _, err := r.Run(); err == nil {
+		t.Errorf("Expected fiel to stop the program from running")
+	}
+}
+
+func TestFileIO(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "io.txt")
+	os.WriteFile(file, []byte("from io.txt"), 0644)
+
+	// the write after two reads lands on the third byte, not after what the
+	// reader buffered
+	_, output := runSource(t, "io file\n,>,+.io file\n,>,>,io std\n<<.>.>.", EngineOptions{IOSourceList: bf_io.IOSourceList{File: file}})
+	if string(output) != "frs" {
+		t.Errorf("Incorrect stdout expected %q found %q", "frs", string(output))
+	}
+
+	if content, _ := os.ReadFile(file); string(content) != "frsm io.txt" {
+		t.Errorf("Incorrect io file expected %q found %q", "frsm io.txt", string(content))
+	}
+}
+
+type traceRecorder []trace.Event
+
+func (r *traceRecorder) Trace(event trace.Event) {
+	*r = append(*r, event)
+}
+
+func TestTrace(t *testing.T) {
+	events := &traceRecorder{}
+	_, output := runSource(t, "++[>+<-]>.", EngineOptions{Tracer: events})
+	if string(output) != "\x02" {
+		t.Errorf("Incorrect stdout expected %q found %q", "\x02", string(output))
+	}
+
+	kinds := []trace.Kind{}
+	for i, event := range *events {
+		if event.Step != uint64(i+1) {
+			t.Errorf("Expected step %d found %d", i+1, event.Step)
+		}
+		kinds = append(kinds, event.Kind)
+	}
+
+	expected := []trace.Kind{
+		trace.Increment, trace.Increment, trace.Loop,
+		trace.Iteration, trace.MoveRight, trace.Increment, trace.MoveLeft, trace.Decrement,
+		trace.Iteration, trace.MoveRight, trace.Increment, trace.MoveLeft, trace.Decrement,
+		trace.MoveRight, trace.Output,
+	}
+	if !slices.Equal(kinds, expected) {
+		t.Fatalf("Expected the steps %v found %v", expected, kinds)
+	}
+
+	last := (*events)[len(*events)-1]
+	if last.Column != 10 || last.Cursor != 1 || last.Before != 2 || last.After != 2 {
+		t.Errorf("Incorrect output step %+v", last)
+	}
+	if decrement := (*events)[7]; decrement.Before != 2 || decrement.After != 1 || decrement.Cursor != 0 {
+		t.Errorf("Incorrect decrement step %+v", decrement)
+	}
+
+	// the failing step is traced with its error
+	events = &traceRecorder{}
+	runSource(t, "+>-", EngineOptions{Tracer: events, CellOverflow: CellError})
+	if len(*events) != 3 || len((*events)[2].Error) == 0 {
+		t.Errorf("Expected the last step to fail found %+v", *events)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	e := NewEngineFromSource("+++>++<-*", EngineOptions{})
+	if err := e.parse(); err.Reason != nil {
+		t.Fatal(err)
+	}
+
+	for _, statement := range e.Parser.Program {
+		e.record(statement)
+		run(e, &[]parser.Statement{statement})
+		e.finish(statement)
+	}
+	live := moment{statement: parser.Statement{Type: "Live"}}
+
+	if _, err := e.stepBack(); err != nil || e.Tape[0] != 2 || e.Tape[1] != 2 || e.Cursor != 0 {
+		t.Errorf("Expected to undo the clear found %v at %d with %v", e.Tape[:2], e.Cursor, err)
+	}
+
+	if now, err := e.jump(5, live); err != nil || e.Tape[0] != 3 || e.Tape[1] != 1 || e.Cursor != 1 || now.statement.Type != "Increment Statement" {
+		t.Errorf("Expected to be before the second increment of cell 1 found %v at %d with %v", e.Tape[:2], e.Cursor, err)
+	}
+
+	if now, err := e.jump(e.history.live, live); err != nil || now.statement.Type != "Live" || e.Tape[0] != 0 || e.Tape[1] != 0 {
+		t.Errorf("Expected to jump back to the live step found %v with %v", e.Tape[:2], err)
+	}
+
+	if _, err := e.jump(e.history.live+1, live); err == nil {
+		t.Error("Expected to fail to jump to the future")
+	}
+
+	limited := NewEngineFromSource("+++", EngineOptions{HistoryLimit: 1})
+	limited.parse()
+	for _, statement := range limited.Parser.Program {
+		limited.record(statement)
+		limited.finish(statement)
+	}
+	if limited.history.oldest != 2 || limited.history.count != 1 {
+		t.Errorf("Expected only the last step to be kept found %d from %d", limited.history.count, limited.history.oldest)
+	}
+	for _, entry := range limited.history.entries {
+		if entry.changes != nil && entry.statement.Position != (lexer.Position{Line: 1, Column: 3}) {
+			t.Errorf("Expected the dropped steps to be let go found %+v", entry)
+		}
+	}
+
+	disabled := NewEngineFromSource("+", EngineOptions{HistoryLimit: NoHistory})
+	if _, err := disabled.stepBack(); err == nil {
+		t.Error("Expected to fail to step back without a history")
+	}
+
+	// nobody attaches to go back
+	unattached, _ := runSource(t, "+++", EngineOptions{DebugListen: "127.0.0.1:0", DebugNoWait: true})
+	if unattached.history.live != 0 || unattached.history.count != 0 {
+		t.Errorf("Expected no history without a client found %d steps", unattached.history.live)
+	}
+}
+
+func TestDebuggerWithoutEntryPause(t *testing.T) {
+	e := NewEngineFromSource("+debug+.", EngineOptions{DebugListen: "127.0.0.1:0", Stdout: &bytes.Buffer{}})
+
+	done := make(chan int)
+	go func() {
+		status, _ := e.Run()
+		done <- status
+	}()
+
+	c := attach(t, e)
+	if state := c.send(nil); state.Reason != debugger.DebugPause || state.Tape[0] != 1 {
+		t.Errorf("Expected to first pause at the debug statement found %s with %v", state.Reason, state.Tape)
+	}
+
+	c.write(debugger.PlayerOperation{Operation: debugger.Resume})
+	if status := <-done; status != 0 {
+		t.Errorf("Expected the program to finish found %d", status)
+	}
+}
+
+// debugClient attaches to an engine's debugger over tcp.
+type debugClient struct {
+	t       *testing.T
+	encoder *json.Encoder
+	decoder *json.Decoder
+	id      uint64
+}
+
+func attach(t *testing.T, e *Engine) *debugClient {
+	connection, err := net.Dial("tcp", e.Debugger.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { connection.Close() })
+
+	c := &debugClient{t: t, encoder: json.NewEncoder(connection), decoder: json.NewDecoder(connection)}
+	var hello debugger.Hello
+	var meta debugger.MetaData
+	c.decoder.Decode(&hello)
+	c.decoder.Decode(&meta)
+	c.write(debugger.HelloOperation{Operation: debugger.Handshake, Version: debugger.ProtocolVersion})
+	return c
+}
+
+// write sends an operation with the next request id.
+func (c *debugClient) write(operation interface{}) {
+	encoded, _ := json.Marshal(operation)
+	var frame map[string]interface{}
+	json.Unmarshal(encoded, &frame)
+
+	c.id++
+	frame["id"] = c.id
+	c.encoder.Encode(frame)
+}
+
+// read reads frames until a state or an error response, skipping the
+// answer to the handshake.
+func (c *debugClient) read() json.RawMessage {
+	for {
+		var frame json.RawMessage
+		if err := c.decoder.Decode(&frame); err != nil {
+			c.t.Fatal(err)
+		}
+
+		var hello debugger.Hello
+		json.Unmarshal(frame, &hello)
+		if hello.Operation != debugger.HelloAction {
+			return frame
+		}
+	}
+}
+
+// send sends an operation and reads the state shared after it.
+func (c *debugClient) send(operation interface{}) debugger.State {
+	if operation != nil {
+		c.write(operation)
+	}
+
+	var state debugger.State
+	json.Unmarshal(c.read(), &state)
+	if state.Operation != debugger.DiscloseDebugState {
+		c.t.Fatalf("Expected a state found %+v", state)
+	}
+	return state
+}
+
+// fail sends an operation that fails and reads the error response.
+func (c *debugClient) fail(operation interface{}) debugger.ErrorResponse {
+	c.write(operation)
+
+	var response debugger.ErrorResponse
+	json.Unmarshal(c.read(), &response)
+	if response.Operation != debugger.ErrorAction || response.Id != c.id {
+		c.t.Fatalf("Expected an error response to %d found %+v", c.id, response)
+	}
+	return response
+}
+
+func TestDebugger(t *testing.T) {
+	var stdout bytes.Buffer
+	e := NewEngineFromSource("+++\n[>++<-]\n>.", EngineOptions{DebugListen: "127.0.0.1:0", DebugStopOnEntry: true, Stdout: &stdout})
+
+	done := make(chan int)
+	go func() {
+		status, _ := e.Run()
+		done <- status
+	}()
+
+	c := attach(t, e)
+	if state := c.send(nil); state.Reason != debugger.EntryPause {
+		t.Errorf("Expected to pause on entry found %s", state.Reason)
+	}
+
+	state := c.send(debugger.BreakpointOperation{Operation: debugger.SetBreakpoint, Line: 2, Column: 3, Condition: "cell == 2"})
+	if len(state.Breakpoints) != 1 || len(state.Error) > 0 {
+		t.Errorf("Expected a breakpoint found %v with '%s'", state.Breakpoints, state.Error)
+	}
+	if state.Resolved == nil || state.Resolved.Line != 2 || state.Resolved.Column != 3 {
+		t.Errorf("Expected the breakpoint to resolve to 2:3 found %v", state.Resolved)
+	}
+
+	// the start of the line moves to the loop, where a breakpoint requested
+	// elsewhere can't replace it
+	if state := c.send(debugger.BreakpointOperation{Operation: debugger.SetBreakpoint, Line: 2}); state.Resolved == nil || state.Resolved.Column != 1 {
+		t.Errorf("Expected the breakpoint to resolve to 2:1 found %v", state.Resolved)
+	}
+	if response := c.fail(debugger.BreakpointOperation{Operation: debugger.SetBreakpoint, Line: 2, Column: 1, Condition: "cell == 1"}); response.Code != debugger.InvalidArgument {
+		t.Errorf("Expected a breakpoint resolving onto another one to fail found %+v", response)
+	}
+	c.send(debugger.BreakpointOperation{Operation: debugger.ClearBreakpoint, Line: 2})
+	c.send(debugger.WatchpointOperation{Operation: debugger.SetWatchpoint, Start: 0, To: new(uint32)})
+
+	if response := c.fail(debugger.AssignOperation{Operation: debugger.Assign, Cell: 1 << 20, Value: 1}); response.Code != debugger.OutOfRange {
+		t.Errorf("Expected an assign outside the tape to be out of range found %+v", response)
+	}
+	if response := c.fail(debugger.AssignOperation{Operation: debugger.Assign, Cell: 1 << 63, Value: 1}); response.Code != debugger.OutOfRange {
+		t.Errorf("Expected an assign to a cell that overflows an int to be out of range found %+v", response)
+	}
+	if response := c.fail(debugger.WatchpointOperation{Operation: debugger.SetWatchpoint, Start: 1 << 63}); response.Code != debugger.OutOfRange {
+		t.Errorf("Expected a watchpoint off the tape to be out of range found %+v", response)
+	}
+	if response := c.fail(debugger.WatchpointOperation{Operation: debugger.SetWatchpoint, Start: 0, End: DefaultTapeSize}); response.Code != debugger.OutOfRange {
+		t.Errorf("Expected a watchpoint reaching past the tape to be out of range found %+v", response)
+	}
+	if response := c.fail(debugger.MoveOperation{Operation: debugger.Move, Cell: 1 << 63}); response.Code != debugger.OutOfRange {
+		t.Errorf("Expected a move to a cell that overflows an int to be out of range found %+v", response)
+	}
+	if response := c.fail(debugger.AssignOperation{Operation: debugger.Assign, Cell: 0, Value: 256}); response.Code != debugger.OutOfRange {
+		t.Errorf("Expected a value too large for a cell to be out of range found %+v", response)
+	}
+	if response := c.fail(debugger.BreakpointOperation{Operation: debugger.ClearBreakpoint, Line: 1}); response.Code != debugger.NotFound {
+		t.Errorf("Expected clearing a missing breakpoint to fail found %+v", response)
+	}
+
+	state = c.send(debugger.PlayerOperation{Operation: debugger.Resume})
+	if state.Id != c.id {
+		t.Errorf("Expected the pause to answer request %d found %d", c.id, state.Id)
+	}
+	if state.Reason != debugger.BreakpointPause || state.Statement.Column != 3 || state.Tape[1] != 2 {
+		t.Errorf("Expected to pause at the breakpoint in the second iteration found %s at %d with %v", state.Reason, state.Statement.Column, state.Tape[:2])
+	}
+	if len(state.Tape) != 2 || state.TapeSize != DefaultTapeSize {
+		t.Errorf("Expected the tape to end at the last cell in use found %v of %d", state.Tape, state.TapeSize)
+	}
+
+	var tape debugger.TapeRange
+	c.write(debugger.ReadTapeOperation{Operation: debugger.ReadTape, Start: 0, Count: 3, Format: debugger.HexFormat})
+	json.Unmarshal(c.read(), &tape)
+	if tape.Id != c.id || tape.Text != "02 02 00" {
+		t.Errorf("Incorrect tape range %+v", tape)
+	}
+
+	var search debugger.SearchResult
+	c.write(debugger.SearchOperation{Operation: debugger.SearchTape, Values: []uint32{2, 2}})
+	json.Unmarshal(c.read(), &search)
+	if len(search.Matches) != 1 || search.Matches[0] != 0 || search.More {
+		t.Errorf("Incorrect search result %+v", search)
+	}
+
+	var evaluation debugger.Evaluation
+	c.write(debugger.EvaluateOperation{Operation: debugger.Evaluate, Expression: "c[cursor-1] + c[1]"})
+	json.Unmarshal(c.read(), &evaluation)
+	if evaluation.Value != 4 {
+		t.Errorf("Incorrect evaluation %+v", evaluation)
+	}
+
+	if response := c.fail(debugger.ReadTapeOperation{Operation: debugger.ReadTape, Start: DefaultTapeSize, Count: 1}); response.Code != debugger.OutOfRange {
+		t.Errorf("Expected reading past the tape to be out of range found %+v", response)
+	}
+	if response := c.fail(debugger.SearchOperation{Operation: debugger.SearchTape, Values: []uint32{0}, Start: 1 << 63}); response.Code != debugger.OutOfRange {
+		t.Errorf("Expected searching from past the tape to be out of range found %+v", response)
+	}
+
+	state = c.send(debugger.PlayerOperation{Operation: debugger.Resume})
+	if state.Reason != debugger.WatchpointPause || state.Watch.Cell != 0 || state.Watch.Old != 1 || state.Statement.Column != 6 {
+		t.Errorf("Expected the last decrement to hit the watchpoint found %s with %+v", state.Reason, state.Watch)
+	}
+
+	state = c.send(debugger.PlayerOperation{Operation: debugger.ReverseContinue})
+	if state.Reason != debugger.WatchpointPause || state.Replay.Step >= state.Replay.Live || state.Tape[0] != 1 {
+		t.Errorf("Expected to go back to the watched write found %s at %+v with %v", state.Reason, state.Replay, state.Tape[:2])
+	}
+
+	state = c.send(debugger.PlayerOperation{Operation: debugger.StepBack})
+	if state.Tape[1] != 6 || state.Replay.Step != state.Replay.Live-2 {
+		t.Errorf("Expected to be a step before the write found %v at %+v", state.Tape[:2], state.Replay)
+	}
+
+	c.send(debugger.PlayerOperation{Operation: debugger.Resume})
+	c.write(debugger.PlayerOperation{Operation: debugger.Resume})
+
+	if status := <-done; status != 0 || stdout.String() != "\x06" {
+		t.Errorf("Expected the program to finish found %d with %q", status, stdout.String())
+	}
+}
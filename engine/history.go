@@ -0,0 +1,325 @@
+package engine
+
+import (
+	"unsafe"
+
+	"github.com/CanPacis/brainfuck-interpreter/debugger"
+	"github.com/CanPacis/brainfuck-interpreter/parser"
+)
+
+// DefaultHistoryLimit is how much memory the execution history of a
+// debugged program may use unless EngineOptions.HistoryLimit says otherwise.
+const DefaultHistoryLimit = 64 << 20
+
+// NoHistory turns the execution history off.
+const NoHistory = -1
+
+type cellChange struct {
+	cell          uint
+	before, after uint32
+}
+
+// historyEntry is a single step, enough to undo and redo it.
+type historyEntry struct {
+	statement           parser.Statement
+	depth               int
+	cursor, cursorAfter uint
+	changes             []cellChange
+	// io is the byte the statement read or wrote
+	io    byte
+	hasIO bool
+}
+
+func (h *historyEntry) size() int {
+	return int(unsafe.Sizeof(*h)) + len(h.changes)*int(unsafe.Sizeof(cellChange{}))
+}
+
+// history records every step the tree-walker runs while a client that
+// agreed on the history capability is attached. Once it outgrows its limit
+// the oldest steps are dropped.
+type history struct {
+	limit int
+	size  int
+	// entries is a ring of count steps starting at first, dropped steps
+	// are zeroed so that their changes can be collected
+	entries      []historyEntry
+	first, count int
+	// oldest is the step of the first entry and live is the step of the
+	// statement the program is paused at
+	oldest, live uint64
+	// position is the step the client is looking at, it is only before
+	// live while going through the past
+	position uint64
+}
+
+var errNoHistory = debugger.Errorf(debugger.Unsupported, "the execution history is turned off")
+
+func (h *history) entry(step uint64) *historyEntry {
+	return &h.entries[(h.first+int(step-h.oldest))%len(h.entries)]
+}
+
+// push adds the entry of the next step, growing the ring when it is full.
+func (h *history) push(entry historyEntry) {
+	if h.count == len(h.entries) {
+		grown := make([]historyEntry, max(2*len(h.entries), 64))
+		copied := copy(grown, h.entries[h.first:])
+		copy(grown[copied:], h.entries[:h.first])
+		h.entries, h.first = grown, 0
+	}
+
+	h.entries[(h.first+h.count)%len(h.entries)] = entry
+	h.count++
+	h.size += entry.size()
+}
+
+// drop forgets the oldest step.
+func (h *history) drop() {
+	h.size -= h.entries[h.first].size()
+	h.entries[h.first] = historyEntry{}
+	h.first = (h.first + 1) % len(h.entries)
+	h.count--
+	h.oldest++
+}
+
+// clear forgets every step, the history can't go back past a step that
+// wasn't recorded.
+func (h *history) clear() {
+	if h.count == 0 {
+		return
+	}
+	h.entries, h.first, h.count, h.size = nil, 0, 0, 0
+	h.oldest, h.position = h.live, h.live
+}
+
+// record starts the step of a statement, saving what it is about to write.
+func (e *Engine) record(statement parser.Statement) {
+	h := &e.history
+	if h.limit < 0 {
+		return
+	}
+
+	entry := historyEntry{statement: statement, depth: e.debug.depth, cursor: e.Cursor, cursorAfter: e.Cursor}
+
+	switch statement.Type {
+	case "Increment Statement", "Decrement Statement", "Stdin Statement":
+		if int(e.Cursor) < len(e.Tape) {
+			entry.changes = []cellChange{{cell: e.Cursor, before: e.Tape[e.Cursor]}}
+		}
+	case "Clear Statement":
+		for cell, value := range e.Tape {
+			if value != 0 {
+				entry.changes = append(entry.changes, cellChange{cell: uint(cell), before: value})
+			}
+		}
+	case "Stdout Statement":
+		if int(e.Cursor) < len(e.Tape) {
+			entry.io, entry.hasIO = byte(e.Tape[e.Cursor]), true
+		}
+	}
+
+	h.push(entry)
+	h.live++
+	h.position = h.live
+
+	for h.size > h.limit && h.count > 1 {
+		h.drop()
+	}
+}
+
+// finish completes the step record started, once the statement has run. A
+// loop's step is complete as soon as it starts since its body is recorded
+// as steps of its own.
+func (e *Engine) finish(statement parser.Statement) {
+	h := &e.history
+	if h.limit < 0 || statement.Type == "Loop Statement" {
+		return
+	}
+
+	entry := h.entry(h.live - 1)
+	entry.cursorAfter = e.Cursor
+	for i := range entry.changes {
+		if cell := entry.changes[i].cell; cell < uint(len(e.Tape)) {
+			entry.changes[i].after = e.Tape[cell]
+		}
+	}
+	if statement.Type == "Stdin Statement" && len(entry.changes) > 0 {
+		entry.io, entry.hasIO = byte(entry.changes[0].after), true
+	}
+}
+
+func (e *Engine) undo(entry *historyEntry) {
+	for _, change := range entry.changes {
+		e.Tape[change.cell] = change.before
+	}
+	e.Cursor = entry.cursor
+}
+
+func (e *Engine) redo(entry *historyEntry) {
+	for _, change := range entry.changes {
+		e.Tape[change.cell] = change.after
+	}
+	e.Cursor = entry.cursorAfter
+}
+
+// watchedChange finds a change of an entry that matches a watchpoint.
+func (e *Engine) watchedChange(entry *historyEntry) *debugger.WatchHit {
+	for _, change := range entry.changes {
+		for _, watchpoint := range e.debug.watchpoints {
+			if watchpoint.Watches(change.cell) && watchpoint.Matches(change.before, change.after) {
+				return &debugger.WatchHit{Watchpoint: *watchpoint, Cell: change.cell, Old: change.before, New: change.after}
+			}
+		}
+	}
+	return nil
+}
+
+// replayState is where the client is in the history.
+func (e *Engine) replayState() debugger.Replay {
+	h := &e.history
+	replay := debugger.Replay{Step: h.position, Live: h.live, Oldest: h.oldest}
+
+	if h.position < h.live {
+		if entry := h.entry(h.position); entry.hasIO {
+			replay.IO = string([]byte{entry.io})
+		}
+	}
+	return replay
+}
+
+// moment is a step of the past or the present the client can be shown.
+type moment struct {
+	statement parser.Statement
+	pause     debugger.State
+}
+
+// past is the moment at the current position, which is before the live
+// step.
+func (e *Engine) past(reason debugger.PauseReason) moment {
+	return moment{statement: e.history.entry(e.history.position).statement, pause: debugger.State{Reason: reason}}
+}
+
+func (e *Engine) stepBack() (moment, error) {
+	h := &e.history
+	if h.limit < 0 {
+		return moment{}, errNoHistory
+	}
+	if h.position == h.oldest {
+		return moment{}, debugger.Errorf(debugger.OutOfRange, "there are no earlier steps in the history")
+	}
+
+	h.position--
+	e.undo(h.entry(h.position))
+	return e.past(debugger.StepPause), nil
+}
+
+// reverseContinue goes back until a breakpoint, a debug statement or a
+// write to a watched cell, or the oldest step.
+func (e *Engine) reverseContinue() (moment, error) {
+	h := &e.history
+	if h.limit < 0 {
+		return moment{}, errNoHistory
+	}
+
+	for h.position > h.oldest {
+		h.position--
+		entry := h.entry(h.position)
+		e.undo(entry)
+
+		if watch := e.watchedChange(entry); watch != nil {
+			now := e.past(debugger.WatchpointPause)
+			now.pause.Watch = watch
+			return now, nil
+		}
+		if breakpoint := e.breakpoint(entry.statement.Position); breakpoint != nil {
+			if ok, err := breakpoint.Matches(debugEnv{e}); ok {
+				now := e.past(debugger.BreakpointPause)
+				now.pause.Breakpoint = breakpoint
+				return now, err
+			}
+		}
+		if entry.statement.DebugTarget {
+			return e.past(debugger.DebugPause), nil
+		}
+	}
+
+	if h.oldest == 0 {
+		return e.past(debugger.EntryPause), nil
+	}
+	return e.past(debugger.StepPause), nil
+}
+
+// forward redoes the past until a step the client would pause at. It
+// reports false once it reaches the live step without one, which the
+// program then decides for itself.
+func (e *Engine) forward(mode stepMode, requested int, live moment) (moment, bool) {
+	h := &e.history
+
+	for h.position < h.live {
+		entry := h.entry(h.position)
+		e.redo(entry)
+		h.position++
+
+		if watch := e.watchedChange(entry); watch != nil {
+			return moment{statement: entry.statement, pause: debugger.State{Reason: debugger.WatchpointPause, Watch: watch}}, true
+		}
+
+		now, depth := live, e.debug.depth
+		if h.position < h.live {
+			now, depth = e.past(debugger.StepPause), h.entry(h.position).depth
+		}
+
+		switch mode {
+		case stepIn:
+		case stepOver:
+			if depth > requested {
+				continue
+			}
+		case stepOut:
+			if depth >= requested {
+				continue
+			}
+		default:
+			if breakpoint := e.breakpoint(now.statement.Position); breakpoint != nil {
+				if ok, _ := breakpoint.Matches(debugEnv{e}); ok {
+					now.pause = debugger.State{Reason: debugger.BreakpointPause, Breakpoint: breakpoint}
+					return now, true
+				}
+			}
+			if !now.statement.DebugTarget {
+				continue
+			}
+			now.pause = debugger.State{Reason: debugger.DebugPause}
+			return now, true
+		}
+
+		now.pause = debugger.State{Reason: debugger.StepPause}
+		return now, true
+	}
+
+	return moment{}, false
+}
+
+// jump goes to any step from the oldest to the live one.
+func (e *Engine) jump(step uint64, live moment) (moment, error) {
+	h := &e.history
+	if h.limit < 0 {
+		return moment{}, errNoHistory
+	}
+	if step < h.oldest || step > h.live {
+		return moment{}, debugger.Errorf(debugger.OutOfRange, "step %d is not in the history, it goes from %d to %d", step, h.oldest, h.live)
+	}
+
+	for h.position > step {
+		h.position--
+		e.undo(h.entry(h.position))
+	}
+	for h.position < step {
+		e.redo(h.entry(h.position))
+		h.position++
+	}
+
+	if step == h.live {
+		return live, nil
+	}
+	return e.past(debugger.StepPause), nil
+}
@@ -0,0 +1,28 @@
+package engine
+
+import (
+	"github.com/CanPacis/brainfuck-interpreter/bf_errors"
+	"github.com/CanPacis/brainfuck-interpreter/ir"
+)
+
+// jitBudget is how many loop iterations machine code runs before it
+// returns to Go, so that the program can be canceled and the garbage
+// collector can stop it.
+const jitBudget = 1 << 16
+
+// jittable reports whether machine code can run the program the way the
+// virtual machine would. It only checks that the cursor stays on a tape
+// that doesn't grow, cells wrap around and it can't count steps.
+func (e *Engine) jittable() bool {
+	return e.tape.cellOverflow == WrapCells && e.tape.pointerOverflow == PointerError && e.limits.maxSteps == 0
+}
+
+// runJIT runs the program as machine code where it can, it reports false
+// when the virtual machine has to run it instead.
+func runJIT(e *Engine, program []ir.Instruction) (bf_errors.RuntimeError, bool) {
+	if !e.jittable() {
+		return bf_errors.EmptyError, false
+	}
+
+	return jit(e, program)
+}
@@ -0,0 +1,309 @@
+//go:build linux && amd64
+
+package engine
+
+import (
+	"encoding/binary"
+	"syscall"
+	"unsafe"
+
+	"github.com/CanPacis/brainfuck-interpreter/bf_errors"
+	"github.com/CanPacis/brainfuck-interpreter/ir"
+)
+
+// jitState is shared with the machine code, which keeps the tape in rbx,
+// the cursor in rsi, the budget in r8 and the state in rdi while it runs.
+// Its layout is what jitCall and exit expect.
+type jitState struct {
+	tape   uintptr
+	cursor int64
+	budget int64
+	// site is the exit the code returned through
+	site int64
+}
+
+// jitCall runs machine code from entry until it returns through an exit.
+//
+//go:noescape
+func jitCall(entry uintptr, state *jitState)
+
+// jitExit is why machine code returned to Go.
+type jitExit uint8
+
+const (
+	jitDone jitExit = iota
+	jitOutput
+	jitInput
+	jitSwitchIO
+	jitClear
+	jitFault
+	jitOutOfBudget
+)
+
+// jitSite is a place machine code returns to Go from, it goes on from
+// resume unless the exit ends the program.
+type jitSite struct {
+	exit        jitExit
+	instruction *ir.Instruction
+	resume      int
+}
+
+// assembler lowers folded instructions into x86-64 machine code. Every
+// cell is a uint32 of the engine's tape, adds only touch as many bytes of
+// it as the cells are wide so they wrap around on their own.
+type assembler struct {
+	code     []byte
+	sites    []jitSite
+	width    CellWidth
+	tapeSize int
+}
+
+func (a *assembler) emit(code ...byte) {
+	a.code = append(a.code, code...)
+}
+
+func (a *assembler) imm32(value int) {
+	a.code = binary.LittleEndian.AppendUint32(a.code, uint32(int32(value)))
+}
+
+// cell addresses the cell at offset from the cursor, [rbx+rsi*4+disp32]
+// with reg in the ModRM byte.
+func (a *assembler) cell(reg byte, offset int) {
+	a.emit(0x84|reg<<3, 0xb3)
+	a.imm32(offset * 4)
+}
+
+// jump emits a jump with a 32 bit displacement and returns where it is so
+// that it can be patched.
+func (a *assembler) jump(opcode ...byte) int {
+	a.emit(opcode...)
+	a.imm32(0)
+	return len(a.code) - 4
+}
+
+// patch points a jump at the end of the code.
+func (a *assembler) patch(at int) {
+	binary.LittleEndian.PutUint32(a.code[at:], uint32(int32(len(a.code)-at-4)))
+}
+
+// jumpTo emits a jump back to target.
+func (a *assembler) jumpTo(target int, opcode ...byte) {
+	a.emit(opcode...)
+	a.imm32(target - len(a.code) - 4)
+}
+
+// exit returns to Go through a new site.
+func (a *assembler) exit(exit jitExit, instruction *ir.Instruction) {
+	// mov [rdi+8], rsi; mov [rdi+16], r8
+	a.emit(0x48, 0x89, 0x77, 0x08, 0x4c, 0x89, 0x47, 0x10)
+	// mov qword [rdi+24], site
+	a.emit(0x48, 0xc7, 0x47, 0x18)
+	a.imm32(len(a.sites))
+	// ret
+	a.emit(0xc3)
+
+	a.sites = append(a.sites, jitSite{exit: exit, instruction: instruction, resume: len(a.code)})
+}
+
+// add adds value to the cell at offset.
+func (a *assembler) add(offset, value int) {
+	switch a.width {
+	case Cell8:
+		a.emit(0x80)
+		a.cell(0, offset)
+		a.emit(byte(value))
+	case Cell16:
+		a.emit(0x66, 0x81)
+		a.cell(0, offset)
+		a.emit(byte(value), byte(value>>8))
+	default:
+		a.emit(0x81)
+		a.cell(0, offset)
+		a.imm32(value)
+	}
+}
+
+// addECX adds ecx to the cell at offset.
+func (a *assembler) addECX(offset int) {
+	switch a.width {
+	case Cell8:
+		a.emit(0x00)
+	case Cell16:
+		a.emit(0x66, 0x01)
+	default:
+		a.emit(0x01)
+	}
+	a.cell(1, offset)
+}
+
+// compareCell compares the current cell with zero.
+func (a *assembler) compareCell() {
+	// cmp dword [cell], 0
+	a.emit(0x83)
+	a.cell(7, 0)
+	a.emit(0x00)
+}
+
+// bounds faults unless every cell between low and high cells from the
+// cursor is on the tape.
+func (a *assembler) bounds(low, high int, instruction *ir.Instruction) {
+	if low < 0 {
+		// cmp rsi, -low; jge
+		a.emit(0x48, 0x81, 0xfe)
+		a.imm32(-low)
+		skip := a.jump(0x0f, 0x8d)
+		a.exit(jitFault, instruction)
+		a.patch(skip)
+	}
+	if high > 0 {
+		// cmp rsi, size-high; jl
+		a.emit(0x48, 0x81, 0xfe)
+		a.imm32(a.tapeSize - high)
+		skip := a.jump(0x0f, 0x8c)
+		a.exit(jitFault, instruction)
+		a.patch(skip)
+	}
+}
+
+func (a *assembler) move(value int) {
+	// add rsi, value
+	a.emit(0x48, 0x81, 0xc6)
+	a.imm32(value)
+}
+
+// loop runs body while the current cell isn't zero, returning to Go every
+// jitBudget iterations.
+func (a *assembler) loop(instruction *ir.Instruction, body func()) {
+	a.compareCell()
+	end := a.jump(0x0f, 0x84)
+	start := len(a.code)
+	body()
+
+	// dec r8; jnz
+	a.emit(0x49, 0xff, 0xc8)
+	next := a.jump(0x0f, 0x85)
+	a.exit(jitOutOfBudget, instruction)
+	a.patch(next)
+	a.compareCell()
+	a.jumpTo(start, 0x0f, 0x85)
+	a.patch(end)
+}
+
+func (a *assembler) generate(program []ir.Instruction) {
+	for i := range program {
+		instruction := &program[i]
+
+		switch instruction.Op {
+		case ir.Add:
+			if instruction.Value != 0 {
+				a.add(0, instruction.Value)
+			}
+		case ir.Move:
+			if instruction.Value != 0 || instruction.Min != 0 || instruction.Max != 0 {
+				a.bounds(instruction.Min, instruction.Max, instruction)
+			}
+			if instruction.Value != 0 {
+				a.move(instruction.Value)
+			}
+		case ir.SetZero:
+			// mov dword [cell], 0
+			a.emit(0xc7)
+			a.cell(0, 0)
+			a.imm32(0)
+		case ir.Clear:
+			a.exit(jitClear, instruction)
+		case ir.Output:
+			a.exit(jitOutput, instruction)
+		case ir.Input:
+			a.exit(jitInput, instruction)
+		case ir.SwitchIO:
+			a.exit(jitSwitchIO, instruction)
+		case ir.Multiply:
+			// mov eax, dword [cell]; test eax, eax; jz
+			a.emit(0x8b)
+			a.cell(0, 0)
+			a.emit(0x85, 0xc0)
+			end := a.jump(0x0f, 0x84)
+			a.bounds(instruction.Min, instruction.Max, instruction)
+			for _, term := range instruction.Terms {
+				// imul ecx, eax, factor
+				a.emit(0x69, 0xc8)
+				a.imm32(term.Factor)
+				a.addECX(term.Offset)
+			}
+			a.emit(0xc7)
+			a.cell(0, 0)
+			a.imm32(0)
+			a.patch(end)
+		case ir.Scan:
+			a.loop(instruction, func() {
+				a.bounds(min(instruction.Value, 0), max(instruction.Value, 0), instruction)
+				a.move(instruction.Value)
+			})
+		case ir.Loop:
+			a.loop(instruction, func() {
+				a.generate(instruction.Body)
+			})
+		}
+	}
+}
+
+// jit runs the program as machine code in an executable mapping, doing its
+// io and its errors in Go.
+func jit(e *Engine, program []ir.Instruction) (bf_errors.RuntimeError, bool) {
+	a := &assembler{width: Cell32, tapeSize: len(e.Tape)}
+	switch e.tape.mask {
+	case 0xff:
+		a.width = Cell8
+	case 0xffff:
+		a.width = Cell16
+	}
+	a.generate(program)
+	a.exit(jitDone, nil)
+
+	code, err := syscall.Mmap(-1, 0, len(a.code), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE|syscall.MAP_ANON)
+	if err != nil {
+		return bf_errors.EmptyError, false
+	}
+	defer syscall.Munmap(code)
+
+	copy(code, a.code)
+	if err := syscall.Mprotect(code, syscall.PROT_READ|syscall.PROT_EXEC); err != nil {
+		return bf_errors.EmptyError, false
+	}
+
+	state := jitState{cursor: int64(e.Cursor), budget: jitBudget}
+	resume := 0
+	for {
+		state.tape = uintptr(unsafe.Pointer(&e.Tape[0]))
+		jitCall(uintptr(unsafe.Pointer(&code[0]))+uintptr(resume), &state)
+
+		site := a.sites[state.site]
+		e.Cursor = uint(state.cursor)
+		resume = site.resume
+
+		err := bf_errors.EmptyError
+		switch site.exit {
+		case jitDone:
+			return bf_errors.EmptyError, true
+		case jitOutput:
+			err = e.r_stdout_s(site.instruction.Statement())
+		case jitInput:
+			err = e.r_stdin_s(site.instruction.Statement())
+		case jitSwitchIO:
+			err = e.r_switch_io_s(site.instruction.Statement())
+		case jitClear:
+			e.r_clear_s()
+		case jitFault:
+			return e.r_fault_i(site.instruction, int(state.cursor)), true
+		case jitOutOfBudget:
+			state.budget = jitBudget
+			if e.limits.active {
+				err = e.interrupted(site.instruction.Range.Start)
+			}
+		}
+		if err.Reason != nil {
+			return err, true
+		}
+	}
+}
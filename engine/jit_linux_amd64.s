@@ -0,0 +1,13 @@
+//go:build linux && amd64
+
+#include "textflag.h"
+
+// func jitCall(entry uintptr, state *jitState)
+TEXT ·jitCall(SB), NOSPLIT, $0-16
+	MOVQ entry+0(FP), AX
+	MOVQ state+8(FP), DI
+	MOVQ 0(DI), BX
+	MOVQ 8(DI), SI
+	MOVQ 16(DI), R8
+	CALL AX
+	RET
@@ -0,0 +1,13 @@
+//go:build !linux || !amd64
+
+package engine
+
+import (
+	"github.com/CanPacis/brainfuck-interpreter/bf_errors"
+	"github.com/CanPacis/brainfuck-interpreter/ir"
+)
+
+// jit has no machine code to run outside of linux/amd64.
+func jit(e *Engine, program []ir.Instruction) (bf_errors.RuntimeError, bool) {
+	return bf_errors.EmptyError, false
+}
@@ -0,0 +1,137 @@
+package engine
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/CanPacis/brainfuck-interpreter/bf_errors"
+	"github.com/CanPacis/brainfuck-interpreter/lexer"
+	"github.com/CanPacis/brainfuck-interpreter/waiter"
+)
+
+// the context is only checked every so many steps, checking it on every
+// step is as slow as running the program
+const contextCheckInterval = 1 << 12
+
+var errTimeout = errors.New("timeout")
+
+type limits struct {
+	ctx       context.Context
+	maxSteps  uint64
+	timeout   time.Duration
+	maxOutput uint64
+	steps     uint64
+	output    uint64
+	// active is set when ticking is needed at all
+	active bool
+	// reads are the reads given up on that haven't finished yet, the next
+	// read of the same reader gets their byte
+	reads map[*bufio.Reader]chan read
+}
+
+type read struct {
+	value byte
+	err   error
+}
+
+// tick counts a step and stops the program when it has run out of steps,
+// time or its context was canceled.
+func (e *Engine) tick(position lexer.Position) bf_errors.RuntimeError {
+	return e.ticks(position, 1)
+}
+
+// ticks counts the steps of an instruction that stands for several
+// statements at once.
+func (e *Engine) ticks(position lexer.Position, steps uint64) bf_errors.RuntimeError {
+	before := e.limits.steps
+	e.limits.steps += steps
+
+	if e.limits.maxSteps > 0 && e.limits.steps > e.limits.maxSteps {
+		return bf_errors.CreateError(fmt.Errorf("step limit of %d reached", e.limits.maxSteps), position, bf_errors.StepLimitError, e.Path)
+	}
+
+	if before/contextCheckInterval != e.limits.steps/contextCheckInterval {
+		return e.interrupted(position)
+	}
+
+	return bf_errors.EmptyError
+}
+
+// interrupted stops the program when it has run out of time or its
+// context was canceled.
+func (e *Engine) interrupted(position lexer.Position) bf_errors.RuntimeError {
+	if e.limits.ctx.Err() == nil {
+		return bf_errors.EmptyError
+	}
+
+	if context.Cause(e.limits.ctx) == errTimeout {
+		return bf_errors.CreateError(fmt.Errorf("timed out after %s", e.limits.timeout), position, bf_errors.TimeoutError, e.Path)
+	}
+	return bf_errors.CreateError(fmt.Errorf("canceled: %w", context.Cause(e.limits.ctx)), position, bf_errors.CanceledError, e.Path)
+}
+
+// write counts bytes written by the program against the output limit.
+func (e *Engine) write(n int, position lexer.Position) bf_errors.RuntimeError {
+	if e.limits.maxOutput > 0 && e.limits.output+uint64(n) > e.limits.maxOutput {
+		return bf_errors.CreateError(fmt.Errorf("output limit of %d bytes reached", e.limits.maxOutput), position, bf_errors.OutputLimitError, e.Path)
+	}
+	e.limits.output += uint64(n)
+
+	return bf_errors.EmptyError
+}
+
+// read reads a byte, giving up with a TimeoutError or CanceledError when
+// the context is done before the reader returns.
+func (e *Engine) read(reader *bufio.Reader, position lexer.Position) (byte, error, bf_errors.RuntimeError) {
+	done := e.limits.ctx.Done()
+	pending, ok := e.limits.reads[reader]
+	if !ok {
+		if done == nil || reader.Buffered() > 0 {
+			value, err := reader.ReadByte()
+			return value, err, bf_errors.EmptyError
+		}
+
+		pending = make(chan read, 1)
+		go func() {
+			value, err := reader.ReadByte()
+			pending <- read{value, err}
+		}()
+		if e.limits.reads == nil {
+			e.limits.reads = map[*bufio.Reader]chan read{}
+		}
+		e.limits.reads[reader] = pending
+	}
+
+	select {
+	case result := <-pending:
+		delete(e.limits.reads, reader)
+		return result.value, result.err, bf_errors.EmptyError
+	case <-done:
+		return 0, nil, e.interrupted(position)
+	}
+}
+
+// wait waits for the http io, giving up like read does.
+func (e *Engine) wait(target waiter.Waiter, position lexer.Position) bf_errors.RuntimeError {
+	done := e.limits.ctx.Done()
+	if done == nil {
+		e.waiters.Wait(target)
+		return bf_errors.EmptyError
+	}
+
+	waited := make(chan struct{})
+	go func() {
+		e.waiters.Wait(target)
+		close(waited)
+	}()
+
+	select {
+	case <-waited:
+		return bf_errors.EmptyError
+	case <-done:
+		return e.interrupted(position)
+	}
+}
@@ -3,29 +3,42 @@ package engine
 import (
 	"fmt"
 	"io"
+	"slices"
 	"time"
 
 	"github.com/CanPacis/brainfuck-interpreter/bf_errors"
 	"github.com/CanPacis/brainfuck-interpreter/bf_io"
+	"github.com/CanPacis/brainfuck-interpreter/ir"
 	"github.com/CanPacis/brainfuck-interpreter/parser"
+	"github.com/CanPacis/brainfuck-interpreter/trace"
 	"github.com/CanPacis/brainfuck-interpreter/waiter"
 )
 
-func (e *Engine) r_increment_s() {
-	e.Tape[e.Cursor]++
+func (e *Engine) r_increment_s(statement parser.Statement) bf_errors.RuntimeError {
+	if direction := e.add(int(e.Cursor), 1); direction != 0 {
+		return e.cellError(direction, statement.Position)
+	}
+
+	return bf_errors.EmptyError
 }
 
-func (e *Engine) r_decrement_s() {
-	e.Tape[e.Cursor]--
+func (e *Engine) r_decrement_s(statement parser.Statement) bf_errors.RuntimeError {
+	if direction := e.add(int(e.Cursor), -1); direction != 0 {
+		return e.cellError(direction, statement.Position)
+	}
+
+	return bf_errors.EmptyError
 }
 
 func (e *Engine) r_clear_s() {
-	e.Tape = [30000]byte{}
+	clear(e.Tape)
 }
 
 func (e *Engine) r_move_right_s(statement parser.Statement) bf_errors.RuntimeError {
-	if e.Cursor < 30000 {
-		e.Cursor++
+	cursor := int(e.Cursor)
+
+	if e.reach(cursor, 1, 1) {
+		e.Cursor = uint(e.index(cursor + 1))
 
 		return bf_errors.EmptyError
 	}
@@ -34,8 +47,10 @@ func (e *Engine) r_move_right_s(statement parser.Statement) bf_errors.RuntimeErr
 }
 
 func (e *Engine) r_move_left_s(statement parser.Statement) bf_errors.RuntimeError {
-	if e.Cursor > 0 {
-		e.Cursor--
+	cursor := int(e.Cursor)
+
+	if e.reach(cursor, -1, -1) {
+		e.Cursor = uint(e.index(cursor - 1))
 
 		return bf_errors.EmptyError
 	}
@@ -45,7 +60,19 @@ func (e *Engine) r_move_left_s(statement parser.Statement) bf_errors.RuntimeErro
 
 func (e *Engine) r_loop_s(statement parser.Statement) bf_errors.RuntimeError {
 	for e.Tape[e.Cursor] != 0 {
+		if e.limits.active {
+			if err := e.tick(statement.Position); err.Reason != nil {
+				return err
+			}
+		}
+
+		if e.tracer != nil {
+			e.traceEnd(e.traceStart(trace.Iteration, statement), bf_errors.EmptyError)
+		}
+
+		e.debug.depth++
 		err := run(e, &statement.Body)
+		e.debug.depth--
 		if err.Reason != nil {
 			return err
 		}
@@ -54,17 +81,168 @@ func (e *Engine) r_loop_s(statement parser.Statement) bf_errors.RuntimeError {
 	return bf_errors.EmptyError
 }
 
+func (e *Engine) r_add_i(instruction *ir.Instruction) bf_errors.RuntimeError {
+	value := int(e.Tape[e.Cursor])
+
+	if direction := e.add(int(e.Cursor), int64(instruction.Value)); direction != 0 {
+		// only runs in one direction are folded when cells can fail, so the
+		// failing symbol is the one that crossed the limit
+		fault := value
+		if direction > 0 {
+			fault = int(e.tape.mask) - value
+		}
+		return e.cellError(direction, instruction.Origins[min(fault, len(instruction.Origins)-1)].Position)
+	}
+
+	return bf_errors.EmptyError
+}
+
+func (e *Engine) r_set_zero_i() {
+	e.Tape[e.Cursor] = 0
+}
+
+func (e *Engine) r_move_i(instruction *ir.Instruction) bf_errors.RuntimeError {
+	cursor := int(e.Cursor)
+
+	if !e.reach(cursor, instruction.Min, instruction.Max) {
+		return e.r_fault_i(instruction, cursor)
+	}
+
+	e.Cursor = uint(e.index(cursor + instruction.Value))
+	return bf_errors.EmptyError
+}
+
+func (e *Engine) r_fault_i(instruction *ir.Instruction, cursor int) bf_errors.RuntimeError {
+	origin, _ := instruction.Fault(cursor, 0, e.high())
+	if origin.Delta > 0 {
+		return bf_errors.CreateError(fmt.Errorf("stack overflow"), origin.Position, bf_errors.StackOverflowError, e.Path)
+	}
+	return bf_errors.CreateError(fmt.Errorf("stack overflow"), origin.Position, bf_errors.StackUnderflowError, e.Path)
+}
+
+func (e *Engine) r_multiply_i(instruction *ir.Instruction) bf_errors.RuntimeError {
+	value := e.Tape[e.Cursor]
+	if value == 0 {
+		return bf_errors.EmptyError
+	}
+
+	cursor := int(e.Cursor)
+	if !e.reach(cursor, instruction.Min, instruction.Max) {
+		return e.r_fault_i(instruction, cursor)
+	}
+
+	for _, term := range instruction.Terms {
+		index := e.index(cursor + term.Offset)
+		e.Tape[index] = (e.Tape[index] + value*uint32(term.Factor)) & e.tape.mask
+	}
+	e.Tape[cursor] = 0
+
+	return bf_errors.EmptyError
+}
+
+func (e *Engine) r_scan_i(instruction *ir.Instruction) bf_errors.RuntimeError {
+	cursor := int(e.Cursor)
+
+	if e.tape.pointerOverflow != WrapPointer {
+		start := cursor
+		switch instruction.Value {
+		case 1:
+			if found := slices.Index(e.Tape[cursor:], 0); found >= 0 {
+				cursor += found
+			} else {
+				cursor = len(e.Tape) - 1
+			}
+		case -1:
+			for ; cursor > 0 && e.Tape[cursor] != 0; cursor-- {
+			}
+		}
+
+		// the passes skipped at once are counted at once
+		if e.limits.active && cursor != start {
+			if err := e.ticks(instruction.Range.Start, uint64(max(cursor-start, start-cursor))*instruction.PassSteps()); err.Reason != nil {
+				return err
+			}
+		}
+	}
+
+	low, high := min(instruction.Value, 0), max(instruction.Value, 0)
+	for e.Tape[cursor] != 0 {
+		// a wrapping tape without a zero cell never ends the scan
+		if e.limits.active {
+			if err := e.ticks(instruction.Range.Start, instruction.PassSteps()); err.Reason != nil {
+				e.Cursor = uint(cursor)
+				return err
+			}
+		}
+		if !e.reach(cursor, low, high) {
+			return e.r_fault_i(instruction, cursor)
+		}
+		cursor = e.index(cursor + instruction.Value)
+	}
+	e.Cursor = uint(cursor)
+
+	return bf_errors.EmptyError
+}
+
+// r_tcp_connect_s makes sure there is a tcp connection to talk to, waiting
+// for the next client if the last one has ended.
+func (e *Engine) r_tcp_connect_s(statement parser.Statement) bf_errors.RuntimeError {
+	if len(e.IOTargets) > 0 {
+		return bf_errors.EmptyError
+	}
+
+	io, err := e.tcp.Connection(e.limits.ctx)
+	if err != nil {
+		if err := e.interrupted(statement.Position); err.Reason != nil {
+			return err
+		}
+		return bf_errors.CreateUncaughtError(err, statement.Position, e.Path)
+	}
+	e.IOTargets = []bf_io.RuntimeIO{io}
+
+	return bf_errors.EmptyError
+}
+
 func (e *Engine) r_stdout_s(statement parser.Statement) bf_errors.RuntimeError {
+	if e.ioTargetType == bf_io.Tcp {
+		// like http, a zero ends the current connection, and there is
+		// nothing to end without one
+		if e.Tape[e.Cursor] == 0 {
+			if len(e.IOTargets) == 0 {
+				return bf_errors.EmptyError
+			}
+			e.IOTargets = []bf_io.RuntimeIO{}
+			if err := e.tcp.End(); err != nil {
+				return bf_errors.CreateUncaughtError(err, statement.Position, e.Path)
+			}
+			return bf_errors.EmptyError
+		}
+
+		if err := e.r_tcp_connect_s(statement); err.Reason != nil {
+			return err
+		}
+	}
 	if e.ioTargetType == bf_io.Http && len(e.IOTargets) == 0 {
-		e.waiters.Wait(waiter.HttpConnection)
+		if err := e.wait(waiter.HttpConnection, statement.Position); err.Reason != nil {
+			return err
+		}
 	}
 	for _, target := range e.IOTargets {
+		if err := e.write(1, statement.Position); err.Reason != nil {
+			return err
+		}
+
 		if e.ioTargetType == bf_io.Http {
 			if e.Tape[e.Cursor] != 0 {
 				_, err := target.Out.Write([]byte{byte(e.Tape[e.Cursor])})
 				return bf_errors.CreateUncaughtError(err, statement.Position, e.Path)
 			}
 		} else {
+			if e.ioTargetType == bf_io.File {
+				if err := target.Rewind(); err != nil {
+					return bf_errors.CreateUncaughtError(err, statement.Position, e.Path)
+				}
+			}
 			_, err := target.Out.Write([]byte{byte(e.Tape[e.Cursor])})
 			return bf_errors.CreateUncaughtError(err, statement.Position, e.Path)
 		}
@@ -81,21 +259,50 @@ func (e *Engine) r_stdout_s(statement parser.Statement) bf_errors.RuntimeError {
 func (e *Engine) r_stdin_s(statement parser.Statement) bf_errors.RuntimeError {
 	var target bf_io.RuntimeIO
 
+	if e.ioTargetType == bf_io.Tcp {
+		if err := e.r_tcp_connect_s(statement); err.Reason != nil {
+			return err
+		}
+	}
+
 	if len(e.IOTargets) == 0 {
 		target = e.originalIO
 	} else {
 		target = e.IOTargets[0]
 	}
-	byte, err := target.Reader.ReadByte()
-	if err != nil && err != io.EOF {
+	byte, err, interrupted := e.read(target.Reader, statement.Position)
+	if interrupted.Reason != nil {
+		return interrupted
+	}
+	if err == io.EOF {
+		switch e.eof {
+		case EOFZero:
+			e.Tape[e.Cursor] = 0
+		case EOFMinusOne:
+			e.Tape[e.Cursor] = e.tape.mask
+		case EOFError:
+			return bf_errors.CreateError(fmt.Errorf("end of input"), statement.Position, bf_errors.EndOfInputError, e.Path)
+		}
+
+		return bf_errors.EmptyError
+	}
+	if err != nil {
 		return bf_errors.CreateUncaughtError(err, statement.Position, e.Path)
 	}
-	e.Tape[e.Cursor] = byte
+	e.Tape[e.Cursor] = uint32(byte)
 
 	return bf_errors.EmptyError
 }
 
+func (e *Engine) permissionError(statement parser.Statement) bf_errors.RuntimeError {
+	return bf_errors.CreateError(fmt.Errorf("io %s is not allowed", statement.IOTarget), statement.Position, bf_errors.PermissionError, e.Path)
+}
+
 func (e *Engine) r_switch_io_s(statement parser.Statement) bf_errors.RuntimeError {
+	if !e.allowed(statement.IOTarget) {
+		return e.permissionError(statement)
+	}
+
 	// while swtiching io methods, sometimes http server may not spin up, this waits for it
 	// I know I need to solve this
 	time.Sleep(time.Millisecond)
@@ -114,7 +321,14 @@ func (e *Engine) r_switch_io_s(statement parser.Statement) bf_errors.RuntimeErro
 		e.IOTargets = []bf_io.RuntimeIO{}
 		e.httpServer = bf_io.HttpIO(e.IOSourceList.Http, e.IOSourceList.File, &e.IOTargets, e.waiters)
 	case "tcp":
-		e.originalIO.Out.Write([]byte("tcp not implemented yet"))
+		e.IOTargets = []bf_io.RuntimeIO{}
+		if e.tcp == nil {
+			tcp, err := bf_io.NewTcpIO(e.IOSourceList.Tcp, e.IOSourceList.TcpDial)
+			if err != nil {
+				return bf_errors.CreateUncaughtError(err, statement.Position, e.Path)
+			}
+			e.tcp = tcp
+		}
 	case "file":
 		io, close, err := bf_io.FileIO(e.IOSourceList.File)
 
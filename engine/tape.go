@@ -0,0 +1,170 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/CanPacis/brainfuck-interpreter/bf_errors"
+	"github.com/CanPacis/brainfuck-interpreter/lexer"
+)
+
+const (
+	DefaultTapeSize = 30000
+	// UnboundedTape starts with the default size and grows to the right
+	// whenever the cursor moves past the end of it.
+	UnboundedTape = -1
+)
+
+type CellWidth uint
+
+const (
+	Cell8  CellWidth = 8
+	Cell16 CellWidth = 16
+	Cell32 CellWidth = 32
+)
+
+type CellOverflowPolicy string
+
+const (
+	WrapCells     CellOverflowPolicy = "wrap"
+	SaturateCells CellOverflowPolicy = "saturate"
+	CellError     CellOverflowPolicy = "error"
+)
+
+type PointerOverflowPolicy string
+
+const (
+	PointerError PointerOverflowPolicy = "error"
+	WrapPointer  PointerOverflowPolicy = "wrap"
+	GrowTape     PointerOverflowPolicy = "grow"
+)
+
+type tapeOptions struct {
+	mask            uint32
+	cellOverflow    CellOverflowPolicy
+	pointerOverflow PointerOverflowPolicy
+}
+
+func newTapeOptions(options EngineOptions) (int, tapeOptions, error) {
+	t := tapeOptions{
+		cellOverflow:    options.CellOverflow,
+		pointerOverflow: options.PointerOverflow,
+	}
+	size := options.TapeSize
+
+	switch options.CellWidth {
+	case 0, Cell8:
+		t.mask = math.MaxUint8
+	case Cell16:
+		t.mask = math.MaxUint16
+	case Cell32:
+		t.mask = math.MaxUint32
+	default:
+		return 0, t, fmt.Errorf("unsupported cell width %d", options.CellWidth)
+	}
+
+	switch t.cellOverflow {
+	case "":
+		t.cellOverflow = WrapCells
+	case WrapCells, SaturateCells, CellError:
+	default:
+		return 0, t, fmt.Errorf("unknown cell overflow policy '%s'", t.cellOverflow)
+	}
+
+	switch t.pointerOverflow {
+	case "":
+		t.pointerOverflow = PointerError
+	case PointerError, WrapPointer, GrowTape:
+	default:
+		return 0, t, fmt.Errorf("unknown pointer overflow policy '%s'", t.pointerOverflow)
+	}
+
+	if size == 0 {
+		size = DefaultTapeSize
+	} else if size == UnboundedTape {
+		size = DefaultTapeSize
+		t.pointerOverflow = GrowTape
+	} else if size < 0 {
+		return 0, t, fmt.Errorf("invalid tape size %d", size)
+	}
+
+	return size, t, nil
+}
+
+// add changes the current cell by delta under the cell overflow policy, it
+// returns 1 or -1 if the cell would overflow or underflow and the policy
+// is to fail.
+func (e *Engine) add(index int, delta int64) int {
+	if e.tape.cellOverflow == WrapCells {
+		e.Tape[index] = (e.Tape[index] + uint32(delta)) & e.tape.mask
+		return 0
+	}
+
+	value := int64(e.Tape[index]) + delta
+	if value > int64(e.tape.mask) {
+		if e.tape.cellOverflow == CellError {
+			return 1
+		}
+		value = int64(e.tape.mask)
+	} else if value < 0 {
+		if e.tape.cellOverflow == CellError {
+			return -1
+		}
+		value = 0
+	}
+	e.Tape[index] = uint32(value)
+
+	return 0
+}
+
+func (e *Engine) cellError(direction int, position lexer.Position) bf_errors.RuntimeError {
+	if direction > 0 {
+		return bf_errors.CreateError(fmt.Errorf("cell overflow"), position, bf_errors.CellOverflowError, e.Path)
+	}
+	return bf_errors.CreateError(fmt.Errorf("cell underflow"), position, bf_errors.CellUnderflowError, e.Path)
+}
+
+// high is the highest cursor that can be reached without failing.
+func (e *Engine) high() int {
+	if e.tape.pointerOverflow == GrowTape {
+		return math.MaxInt
+	}
+	return len(e.Tape) - 1
+}
+
+// reach reports whether every cell between cursor+min and cursor+max can be
+// addressed, growing the tape when the policy allows it.
+func (e *Engine) reach(cursor, min, max int) bool {
+	switch e.tape.pointerOverflow {
+	case WrapPointer:
+		return true
+	case GrowTape:
+		if cursor+max >= len(e.Tape) {
+			e.grow(cursor + max + 1)
+		}
+	}
+
+	return cursor+min >= 0 && cursor+max < len(e.Tape)
+}
+
+// index resolves a cell that has been reached into a tape index.
+func (e *Engine) index(cell int) int {
+	if e.tape.pointerOverflow == WrapPointer {
+		cell %= len(e.Tape)
+		if cell < 0 {
+			cell += len(e.Tape)
+		}
+	}
+	return cell
+}
+
+func (e *Engine) grow(length int) {
+	size := len(e.Tape) * 2
+	if size < length {
+		size = length
+	}
+
+	tape := make([]uint32, size)
+	copy(tape, e.Tape)
+	e.Tape = tape
+}
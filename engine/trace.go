@@ -0,0 +1,51 @@
+package engine
+
+import (
+	"github.com/CanPacis/brainfuck-interpreter/bf_errors"
+	"github.com/CanPacis/brainfuck-interpreter/parser"
+	"github.com/CanPacis/brainfuck-interpreter/trace"
+)
+
+var traceKinds = map[string]trace.Kind{
+	"Increment Statement":  trace.Increment,
+	"Decrement Statement":  trace.Decrement,
+	"Move Right Statement": trace.MoveRight,
+	"Move Left Statement":  trace.MoveLeft,
+	"Clear Statement":      trace.Clear,
+	"Stdout Statement":     trace.Output,
+	"Stdin Statement":      trace.Input,
+	"Switch IO Statement":  trace.SwitchIO,
+	"Loop Statement":       trace.Loop,
+}
+
+// traceStart begins the event of a statement that is about to run.
+func (e *Engine) traceStart(kind trace.Kind, statement parser.Statement) trace.Event {
+	e.traceSteps++
+
+	event := trace.Event{
+		Step:     e.traceSteps,
+		Kind:     kind,
+		Position: statement.Position,
+		Cursor:   e.Cursor,
+		Before:   e.Tape[e.Cursor],
+		After:    e.Tape[e.Cursor],
+	}
+	if kind == trace.SwitchIO {
+		event.Target = statement.IOTarget
+	}
+
+	return event
+}
+
+// traceEnd finishes the event once its statement has run and hands it to
+// the tracer.
+func (e *Engine) traceEnd(event trace.Event, err bf_errors.RuntimeError) {
+	if event.Cursor < uint(len(e.Tape)) {
+		event.After = e.Tape[event.Cursor]
+	}
+	if err.Reason != nil {
+		event.Error = err.Reason.Error()
+	}
+
+	e.tracer.Trace(event)
+}
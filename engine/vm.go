@@ -0,0 +1,187 @@
+package engine
+
+import (
+	"github.com/CanPacis/brainfuck-interpreter/bf_errors"
+	"github.com/CanPacis/brainfuck-interpreter/ir"
+)
+
+type ExecutionMode string
+
+const (
+	// VirtualMachine runs the folded program as flat bytecode.
+	VirtualMachine ExecutionMode = "vm"
+	// TreeWalker runs the parsed statements directly. It is always used
+	// when a debugger is attached.
+	TreeWalker ExecutionMode = "tree"
+	// JIT compiles the folded program to machine code on linux/amd64 and
+	// runs it on the virtual machine elsewhere, or when the tape options
+	// and limits need more than the machine code checks.
+	JIT ExecutionMode = "jit"
+)
+
+type opcode uint8
+
+const (
+	opAdd opcode = iota
+	opMove
+	opSetZero
+	opClear
+	opOutput
+	opInput
+	opSwitchIO
+	opMultiply
+	opScan
+	// opJumpIfZero is the start of a loop, its target is the instruction
+	// after the matching opJumpIfNotZero
+	opJumpIfZero
+	// opJumpIfNotZero is the end of a loop, its target is the instruction
+	// after the matching opJumpIfZero
+	opJumpIfNotZero
+)
+
+type bytecode struct {
+	op          opcode
+	target      int
+	instruction *ir.Instruction
+}
+
+var opcodes = map[ir.Op]opcode{
+	ir.Add:      opAdd,
+	ir.Move:     opMove,
+	ir.SetZero:  opSetZero,
+	ir.Clear:    opClear,
+	ir.Output:   opOutput,
+	ir.Input:    opInput,
+	ir.SwitchIO: opSwitchIO,
+	ir.Multiply: opMultiply,
+	ir.Scan:     opScan,
+}
+
+func assembleInto(program []ir.Instruction, code []bytecode) []bytecode {
+	for i := range program {
+		instruction := &program[i]
+
+		if instruction.Op != ir.Loop {
+			code = append(code, bytecode{op: opcodes[instruction.Op], instruction: instruction})
+			continue
+		}
+
+		start := len(code)
+		code = append(code, bytecode{op: opJumpIfZero, instruction: instruction})
+		code = assembleInto(instruction.Body, code)
+		code = append(code, bytecode{op: opJumpIfNotZero, target: start + 1, instruction: instruction})
+		code[start].target = len(code)
+	}
+
+	return code
+}
+
+// assemble flattens a folded program into bytecode with precomputed jump
+// targets.
+func assemble(program []ir.Instruction) []bytecode {
+	return assembleInto(program, []bytecode{})
+}
+
+func execute(e *Engine, code []bytecode) bf_errors.RuntimeError {
+	for pc := 0; pc < len(code); pc++ {
+		c := &code[pc]
+
+		if e.limits.active {
+			if err := e.steps(c); err.Reason != nil {
+				return err
+			}
+		}
+
+		switch c.op {
+		case opAdd:
+			if err := e.r_add_i(c.instruction); err.Reason != nil {
+				return err
+			}
+		case opMove:
+			if err := e.r_move_i(c.instruction); err.Reason != nil {
+				return err
+			}
+		case opSetZero:
+			if e.limits.active {
+				if err := e.passes(c.instruction); err.Reason != nil {
+					return err
+				}
+			}
+			e.r_set_zero_i()
+		case opClear:
+			e.r_clear_s()
+		case opMultiply:
+			if e.limits.active {
+				if err := e.passes(c.instruction); err.Reason != nil {
+					return err
+				}
+			}
+			if err := e.r_multiply_i(c.instruction); err.Reason != nil {
+				return err
+			}
+		case opScan:
+			if err := e.r_scan_i(c.instruction); err.Reason != nil {
+				return err
+			}
+		case opJumpIfZero:
+			if e.Tape[e.Cursor] == 0 {
+				pc = c.target - 1
+			}
+		case opJumpIfNotZero:
+			if e.Tape[e.Cursor] != 0 {
+				pc = c.target - 1
+			}
+		case opOutput:
+			if err := e.r_stdout_s(c.instruction.Statement()); err.Reason != nil {
+				return err
+			}
+		case opInput:
+			if err := e.r_stdin_s(c.instruction.Statement()); err.Reason != nil {
+				return err
+			}
+		case opSwitchIO:
+			if err := e.r_switch_io_s(c.instruction.Statement()); err.Reason != nil {
+				return err
+			}
+		}
+	}
+
+	return bf_errors.EmptyError
+}
+
+// steps counts the statements the tree-walker runs for a bytecode, so that
+// a step limit stops a program at the same point in every mode. The passes
+// of folded loops are counted when they run.
+func (e *Engine) steps(c *bytecode) bf_errors.RuntimeError {
+	instruction := c.instruction
+
+	switch c.op {
+	case opAdd, opMove:
+		before := e.limits.steps
+		err := e.ticks(instruction.Range.Start, instruction.Steps())
+		if err.Reason != nil && err.Type == bf_errors.StepLimitError {
+			// the symbol the tree-walker would have stopped at
+			err.Position = instruction.Origins[e.limits.maxSteps-before].Position
+		}
+		return err
+	case opJumpIfZero:
+		// the start of the loop along with its first pass or its end
+		return e.ticks(instruction.Range.Start, 2)
+	case opJumpIfNotZero:
+		// the next pass or the end of the loop
+		return e.tick(instruction.Range.Start)
+	}
+
+	return e.ticks(instruction.Range.Start, instruction.Steps())
+}
+
+// passes counts the passes a folded set-zero or multiply loop stands for.
+func (e *Engine) passes(instruction *ir.Instruction) bf_errors.RuntimeError {
+	passes := uint64(e.Tape[e.Cursor])
+	if instruction.Op == ir.SetZero && instruction.Body[0].Value > 0 {
+		// [+] wraps around
+		passes = (uint64(e.tape.mask) + 1 - passes) % (uint64(e.tape.mask) + 1)
+	}
+
+	return e.ticks(instruction.Range.Start, passes*instruction.PassSteps())
+}
@@ -0,0 +1,313 @@
+// Package format rewrites brainfuck source in a canonical layout without
+// touching what it does or what its comments say.
+//
+// Every line keeps its code, runs of operators stay together and the
+// spaces an author grouped them with become single spaces. Loop bodies are
+// indented by their depth, debug and io directives get lines of their own
+// and the comments after the code of neighbouring lines are aligned. The
+// text of a comment, escapes included, is never changed and a line that
+// mixes code into its comment is only indented.
+package format
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/CanPacis/brainfuck-interpreter/bf_errors"
+	"github.com/CanPacis/brainfuck-interpreter/lexer"
+	"github.com/CanPacis/brainfuck-interpreter/parser"
+)
+
+// indent is what a loop body is indented with.
+const indent = "    "
+
+type kind int
+
+const (
+	code kind = iota
+	comment
+	directive
+)
+
+// item is a symbol, a directive or a comment on a line of the source.
+type item struct {
+	kind kind
+	text string
+	// column and end are the bytes of the line it spans
+	column, end int
+	// space is whether there is whitespace before it
+	space    bool
+	position lexer.Position
+}
+
+// row is a line of the output.
+type row struct {
+	blank bool
+	depth int
+	// text is the code, the directive or the verbatim line
+	text    string
+	comment string
+	// column is where the comment started in the source, counted from
+	// the start of the row
+	column int
+	// continuation is a comment carrying on the comment of the row before
+	continuation bool
+}
+
+// Source formats a program, path is only used for errors. Programs with a
+// syntax error or unbalanced loops are returned as a bf_errors.RuntimeError.
+func Source(path string, source []byte) ([]byte, error) {
+	p := parser.NewParser(path)
+	if err := p.Parse(string(source)); err.Reason != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(string(source), "\n")
+	rows, err := layout(path, lines, items(p.Lexer.Tokens, len(lines)))
+	if err != nil {
+		return nil, err
+	}
+	formatted := render(rows)
+
+	check := parser.NewParser(path)
+	if err := check.Parse(string(formatted)); err.Reason != nil || !same(p.Program, check.Program) {
+		return nil, fmt.Errorf("%s: formatting would change the program", path)
+	}
+	return formatted, nil
+}
+
+// items groups the tokens into the items of every line.
+func items(tokens []lexer.Token, count int) [][]item {
+	lines := make([][]item, count)
+	space := false
+
+	for index := 0; index < len(tokens); index++ {
+		token := tokens[index]
+		line := int(token.Position.Line) - 1
+		current := item{
+			text:     token.Value,
+			column:   int(token.Position.Column) - 1,
+			end:      int(token.Position.Column) - 1 + len(token.Value),
+			space:    space,
+			position: token.Position,
+		}
+		space = false
+
+		switch token.Type {
+		case "space", "whitespace":
+			space = true
+			continue
+		case "new_line":
+			continue
+		case "io":
+			// the parser made sure a space and a keyword follow
+			target := tokens[index+2]
+			index += 2
+			current.kind = directive
+			current.text = "io " + target.Value
+			current.end = int(target.Position.Column) - 1 + len(target.Value)
+		case "debug":
+			current.kind = directive
+		case "comment", "keyword", "escape":
+			current.kind = comment
+			if last := len(lines[line]) - 1; last >= 0 && lines[line][last].kind == comment {
+				lines[line][last].end = current.end
+				continue
+			}
+		default:
+			current.kind = code
+		}
+
+		lines[line] = append(lines[line], current)
+	}
+
+	return lines
+}
+
+// layout splits the lines at their directives into rows, working out the
+// depth of each of them.
+func layout(path string, lines []string, items [][]item) ([]row, error) {
+	rows := []row{}
+	loops := []lexer.Position{}
+
+	for number, line := range items {
+		if len(line) == 0 {
+			rows = append(rows, row{blank: true})
+			continue
+		}
+
+		for i, split := range split(line) {
+			r := row{depth: len(loops), column: -1}
+			// a row split off the line starts where its first item was
+			start := 0
+			if i > 0 {
+				start = split[0].column
+			}
+
+			// a line starting with the end of a loop lines up with its start
+			for _, it := range split {
+				if it.kind != code || it.text != "]" || r.depth == 0 {
+					break
+				}
+				r.depth--
+			}
+
+			for _, it := range split {
+				switch {
+				case it.kind == code && it.text == "[":
+					loops = append(loops, it.position)
+				case it.kind == code && it.text == "]":
+					if len(loops) == 0 {
+						return nil, bf_errors.CreateSyntaxError(fmt.Errorf("] closes a loop that was never opened"), it.position, path)
+					}
+					loops = loops[:len(loops)-1]
+				}
+			}
+
+			last := split[len(split)-1]
+			trailing := 0
+			for _, it := range split {
+				if it.kind == comment {
+					break
+				}
+				trailing++
+			}
+
+			switch {
+			case split[0].kind == directive:
+				r.text = split[0].text
+				if len(split) > 1 {
+					r.comment = lines[number][split[1].column:last.end]
+					r.column = split[1].column - start
+				}
+			case trailing == 0 && len(split) == 1:
+				r.comment = lines[number][last.column:last.end]
+				r.column = last.column - start
+			case trailing >= len(split)-1:
+				r.text = join(split[:trailing])
+				if trailing < len(split) {
+					r.comment = lines[number][last.column:last.end]
+					r.column = last.column - start
+				}
+			default:
+				r.text = lines[number][split[0].column:last.end]
+			}
+
+			rows = append(rows, r)
+		}
+	}
+
+	if len(loops) > 0 {
+		return nil, bf_errors.CreateSyntaxError(fmt.Errorf("loop is never closed"), loops[len(loops)-1], path)
+	}
+	return rows, nil
+}
+
+// split breaks a line before its directives and before the code after
+// them.
+func split(line []item) [][]item {
+	splits := [][]item{}
+	current := []item{}
+
+	for _, it := range line {
+		switch {
+		case it.kind == directive && len(current) > 0,
+			it.kind == code && len(current) > 0 && current[0].kind == directive:
+			splits = append(splits, current)
+			current = []item{}
+		}
+		current = append(current, it)
+	}
+
+	return append(splits, current)
+}
+
+// join writes code items with a single space where the source grouped
+// them, loops hug their bodies.
+func join(items []item) string {
+	var b strings.Builder
+	for i, it := range items {
+		if i > 0 && it.space && items[i-1].text != "[" && it.text != "]" {
+			b.WriteByte(' ')
+		}
+		b.WriteString(it.text)
+	}
+	return b.String()
+}
+
+// render writes the rows, aligning the comments of neighbouring rows and
+// leaving at most one blank line between rows.
+func render(rows []row) []byte {
+	// drop blank lines at the ends and runs of them
+	kept := []row{}
+	for _, r := range rows {
+		if r.blank && (len(kept) == 0 || kept[len(kept)-1].blank) {
+			continue
+		}
+		kept = append(kept, r)
+	}
+	for len(kept) > 0 && kept[len(kept)-1].blank {
+		kept = kept[:len(kept)-1]
+	}
+	rows = kept
+
+	for i := range rows {
+		r := &rows[i]
+		if i > 0 && len(r.text) == 0 && len(r.comment) > 0 && r.column > len(indent)*r.depth {
+			previous := rows[i-1]
+			r.continuation = len(previous.comment) > 0 && (len(previous.text) > 0 || previous.continuation)
+		}
+	}
+
+	var b strings.Builder
+	for start := 0; start < len(rows); {
+		end := start + 1
+		if aligned(rows[start]) {
+			for end < len(rows) && (aligned(rows[end]) || rows[end].continuation) {
+				end++
+			}
+		}
+
+		column := 0
+		for _, r := range rows[start:end] {
+			if aligned(r) || r.continuation {
+				column = max(column, r.column, len(indent)*r.depth+len(r.text)+1)
+			}
+		}
+
+		for _, r := range rows[start:end] {
+			line := strings.Repeat(indent, r.depth) + r.text
+			switch {
+			case r.blank:
+				line = ""
+			case aligned(r) || r.continuation:
+				line += strings.Repeat(" ", column-len(line)) + r.comment
+			case len(r.comment) > 0:
+				line += r.comment
+			}
+			b.WriteString(line)
+			b.WriteByte('\n')
+		}
+		start = end
+	}
+
+	return []byte(b.String())
+}
+
+// aligned is whether a row has a comment after its code.
+func aligned(r row) bool {
+	return len(r.text) > 0 && len(r.comment) > 0
+}
+
+// same compares programs without their positions.
+func same(a, b []parser.Statement) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Type != b[i].Type || a[i].IOTarget != b[i].IOTarget || a[i].DebugTarget != b[i].DebugTarget || !same(a[i].Body, b[i].Body) {
+			return false
+		}
+	}
+	return true
+}
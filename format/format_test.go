@@ -0,0 +1,143 @@
+package format
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/CanPacis/brainfuck-interpreter/bf_errors"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		name, source, expected string
+	}{
+		{"empty", "\n\n", ""},
+		{"loops", "+[>+[-]\n<-\n]", "+[>+[-]\n    <-\n]\n"},
+		{"nested", "[\n[\n-\n]\n]\n", "[\n    [\n        -\n    ]\n]\n"},
+		{"groups", "  +++  +++\t>>  [ - ] \n", "+++ +++ >> [-]\n"},
+		{"blank lines", "\n\n+\n\n\n\n-\n\n", "+\n\n-\n"},
+		{"directives", "+debug-io file,io std\n", "+\ndebug\n-\nio file\n,\nio std\n"},
+		{"directive comment", "io file  read from a file\n+ x", "io file  read from a file\n+        x\n"},
+		{"comments", "+ one\n[\n-   two \\io\\.\nthree  four\n]", "+ one\n[\n    - two \\io\\.\n    three  four\n]\n"},
+		{
+			"aligned",
+			"++ two\n>+++++ five\n\n[ loop\n- inside\n]",
+			"++     two\n>+++++ five\n\n[     loop\n    - inside\n]\n",
+		},
+		{
+			"continuation",
+			"[<]   find the zero\n      then go on\n-\n      not this",
+			"[<]   find the zero\n      then go on\n-\nnot this\n",
+		},
+		{"mixed", "[\nHello, world +\n]", "[\n    Hello, world +\n]\n"},
+		{"escaped newline", "+ a\\\nb", "+ a\\\nb\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			formatted, err := Source("test.bfi", []byte(test.source))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(formatted) != test.expected {
+				t.Errorf("Expected\n%q\nfound\n%q", test.expected, formatted)
+			}
+
+			again, err := Source("test.bfi", formatted)
+			if err != nil || string(again) != string(formatted) {
+				t.Errorf("Formatting is not stable, found\n%q", again)
+			}
+		})
+	}
+}
+
+func TestSourceErrors(t *testing.T) {
+	tests := []struct {
+		source, reason string
+		line, column   uint
+	}{
+		{"+\n[[-]", "loop is never closed", 2, 1},
+		{"+]", "] closes a loop that was never opened", 1, 2},
+		{"io\n", "unexpected end of file, expected io target", 1, 1},
+	}
+
+	for _, test := range tests {
+		_, err := Source("test.bfi", []byte(test.source))
+
+		var runtimeError bf_errors.RuntimeError
+		if !errors.As(err, &runtimeError) {
+			t.Fatalf("Expected a syntax error for %q found %v", test.source, err)
+		}
+		if runtimeError.Type != bf_errors.SyntaxError || runtimeError.Reason.Error() != test.reason || runtimeError.Position.Line != test.line || runtimeError.Position.Column != test.column {
+			t.Errorf("Expected '%s' at %d:%d found %s", test.reason, test.line, test.column, runtimeError.Error())
+		}
+	}
+}
+
+func TestPrograms(t *testing.T) {
+	paths, _ := filepath.Glob("../bf/*.bfi")
+	if len(paths) == 0 {
+		t.Fatal("Expected programs in ../bf")
+	}
+
+	for _, path := range paths {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		formatted, err := Source(path, source)
+		if err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+		again, err := Source(path, formatted)
+		if err != nil || string(again) != string(formatted) {
+			t.Errorf("%s: formatting is not stable", path)
+		}
+
+		// every comment is still there word for word
+		for _, line := range strings.Split(string(source), "\n") {
+			comment := strings.TrimSpace(strings.TrimLeft(line, "+-<>[].,* \t"))
+			if !strings.Contains(string(formatted), comment) {
+				t.Errorf("%s: comment %q is missing", path, comment)
+			}
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	if diff := Diff("a", "b", []byte("+\n"), []byte("+\n")); len(diff) != 0 {
+		t.Errorf("Expected no diff found %q", diff)
+	}
+
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12"
+	b := "1\n2\n3\nfour\n5\n6\n7\n8\n9\n10\n11\n12\n"
+	expected := strings.Join([]string{
+		"--- a",
+		"+++ b",
+		"@@ -1,7 +1,7 @@",
+		" 1",
+		" 2",
+		" 3",
+		"-4",
+		"+four",
+		" 5",
+		" 6",
+		" 7",
+		"@@ -9,4 +9,4 @@",
+		" 9",
+		" 10",
+		" 11",
+		"-12",
+		"\\ No newline at end of file",
+		"+12",
+		"",
+	}, "\n")
+
+	if diff := string(Diff("a", "b", []byte(a), []byte(b))); diff != expected {
+		t.Errorf("Expected\n%s\nfound\n%s", expected, diff)
+	}
+}
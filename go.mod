@@ -2,4 +2,4 @@ module github.com/CanPacis/brainfuck-interpreter
 
 go 1.21.0
 
-require github.com/alecthomas/kong v0.8.0 // indirect
+require github.com/alecthomas/kong v0.8.0
@@ -0,0 +1,287 @@
+package ir
+
+import (
+	"github.com/CanPacis/brainfuck-interpreter/lexer"
+	"github.com/CanPacis/brainfuck-interpreter/parser"
+)
+
+type Op int
+
+const (
+	Add Op = iota
+	Move
+	SetZero
+	Clear
+	Output
+	Input
+	SwitchIO
+	Loop
+	// Multiply adds the current cell times each term's factor to the cell at
+	// the term's offset, then zeroes the current cell
+	Multiply
+	// Scan moves by Value until it finds a zero cell
+	Scan
+)
+
+var opNames = map[Op]string{
+	Add:      "add",
+	Move:     "move",
+	SetZero:  "set-zero",
+	Clear:    "clear",
+	Output:   "output",
+	Input:    "input",
+	SwitchIO: "switch-io",
+	Loop:     "loop",
+	Multiply: "multiply",
+	Scan:     "scan",
+}
+
+func (o Op) String() string {
+	return opNames[o]
+}
+
+type Range struct {
+	Start lexer.Position `json:"start"`
+	End   lexer.Position `json:"end"`
+}
+
+// Origin is a single source symbol that was folded into an instruction,
+// along with the amount it contributed.
+type Origin struct {
+	lexer.Position
+	Delta int `json:"delta"`
+}
+
+type Term struct {
+	Offset int `json:"offset"`
+	Factor int `json:"factor"`
+}
+
+type Instruction struct {
+	Op       Op            `json:"op"`
+	Value    int           `json:"value"`
+	IOTarget string        `json:"io_target"`
+	Body     []Instruction `json:"body"`
+	Terms    []Term        `json:"terms"`
+	Range    Range         `json:"range"`
+	// Min and Max are the lowest and highest offsets a move reaches
+	// relative to where it started, so bounds can be checked once.
+	Min     int      `json:"min"`
+	Max     int      `json:"max"`
+	Origins []Origin `json:"origins"`
+}
+
+func (i *Instruction) fold(statement parser.Statement, delta int) {
+	i.Value += delta
+	i.Range.End = statement.Position
+	i.Origins = append(i.Origins, Origin{Position: statement.Position, Delta: delta})
+
+	if i.Value < i.Min {
+		i.Min = i.Value
+	}
+	if i.Value > i.Max {
+		i.Max = i.Value
+	}
+}
+
+// Fault returns the first folded symbol that takes a move below low or
+// above high when it starts from cursor. For loops it walks one pass of
+// the body.
+func (i Instruction) Fault(cursor, low, high int) (Origin, bool) {
+	if i.Op == Move {
+		for _, origin := range i.Origins {
+			cursor += origin.Delta
+			if cursor < low || cursor > high {
+				return origin, true
+			}
+		}
+
+		return Origin{}, false
+	}
+
+	for _, instruction := range i.Body {
+		if instruction.Op != Move {
+			continue
+		}
+		if origin, ok := instruction.Fault(cursor, low, high); ok {
+			return origin, true
+		}
+		cursor += instruction.Value
+	}
+
+	return Origin{}, false
+}
+
+// Steps is how many statements the tree-walker runs for the instruction.
+// A loop counts its start and its end, every pass is counted on its own.
+func (i Instruction) Steps() uint64 {
+	switch i.Op {
+	case Add, Move:
+		return uint64(len(i.Origins))
+	case Loop, SetZero, Multiply, Scan:
+		return 2
+	}
+
+	return 1
+}
+
+// PassSteps is how many statements the tree-walker runs for a pass of a
+// loop that was folded into a single instruction.
+func (i Instruction) PassSteps() uint64 {
+	steps := uint64(1)
+	for _, instruction := range i.Body {
+		steps += instruction.Steps()
+	}
+
+	return steps
+}
+
+// Statement returns a statement carrying the instruction's position, for
+// resolvers that report errors against statements.
+func (i Instruction) Statement() parser.Statement {
+	return parser.Statement{IOTarget: i.IOTarget, Position: i.Range.Start}
+}
+
+func delta(statement parser.Statement) (Op, int, bool) {
+	switch statement.Type {
+	case "Increment Statement":
+		return Add, 1, true
+	case "Decrement Statement":
+		return Add, -1, true
+	case "Move Right Statement":
+		return Move, 1, true
+	case "Move Left Statement":
+		return Move, -1, true
+	}
+
+	return 0, 0, false
+}
+
+func single(op Op, statement parser.Statement) Instruction {
+	return Instruction{
+		Op:       op,
+		IOTarget: statement.IOTarget,
+		Range:    Range{Start: statement.Position, End: statement.Position},
+	}
+}
+
+func isSetZero(body []Instruction, options Options) bool {
+	if len(body) != 1 || body[0].Op != Add {
+		return false
+	}
+
+	// [+] only reaches zero by wrapping around
+	return body[0].Value == -1 || (body[0].Value == 1 && !options.StrictCells)
+}
+
+type Options struct {
+	// StrictCells is set when cells saturate or fail instead of wrapping,
+	// so only runs in one direction can be folded and multiply loops are
+	// left alone.
+	StrictCells bool
+}
+
+// multiply recognizes balanced, IO-free loops that decrement their counter
+// once per iteration, like [->+>++<<].
+func multiply(loop *Instruction, body []Instruction) bool {
+	offset := 0
+	factors := map[int]int{}
+	terms := []Term{}
+
+	for _, instruction := range body {
+		switch instruction.Op {
+		case Add:
+			if _, ok := factors[offset]; !ok && offset != 0 {
+				terms = append(terms, Term{Offset: offset})
+			}
+			factors[offset] += instruction.Value
+		case Move:
+			if offset+instruction.Min < loop.Min {
+				loop.Min = offset + instruction.Min
+			}
+			if offset+instruction.Max > loop.Max {
+				loop.Max = offset + instruction.Max
+			}
+			offset += instruction.Value
+		default:
+			return false
+		}
+	}
+
+	if offset != 0 || factors[0] != -1 {
+		return false
+	}
+
+	loop.Op = Multiply
+	loop.Body = body
+	for _, term := range terms {
+		if factors[term.Offset] != 0 {
+			loop.Terms = append(loop.Terms, Term{Offset: term.Offset, Factor: factors[term.Offset]})
+		}
+	}
+
+	return true
+}
+
+// scan recognizes loops that only move in one direction, like [>] or [<<].
+func scan(loop *Instruction, body []Instruction) bool {
+	if len(body) != 1 || body[0].Op != Move || body[0].Value == 0 {
+		return false
+	}
+
+	move := body[0]
+	if (move.Value > 0 && move.Max != move.Value) || (move.Value < 0 && move.Min != move.Value) {
+		return false
+	}
+
+	loop.Op = Scan
+	loop.Value = move.Value
+	loop.Body = body
+	return true
+}
+
+// Compile lowers a parsed program into instructions, folding runs of
+// +/- and >/< into a single add or move and recognizing [-] and [+],
+// multiply loops and scan loops.
+func Compile(program []parser.Statement, options Options) []Instruction {
+	instructions := []Instruction{}
+
+	for _, statement := range program {
+		if op, d, ok := delta(statement); ok {
+			last := len(instructions) - 1
+			if last < 0 || instructions[last].Op != op || (options.StrictCells && op == Add && instructions[last].Value*d < 0) {
+				instructions = append(instructions, single(op, statement))
+				last++
+			}
+			instructions[last].fold(statement, d)
+			continue
+		}
+
+		switch statement.Type {
+		case "Clear Statement":
+			instructions = append(instructions, single(Clear, statement))
+		case "Stdout Statement":
+			instructions = append(instructions, single(Output, statement))
+		case "Stdin Statement":
+			instructions = append(instructions, single(Input, statement))
+		case "Switch IO Statement":
+			instructions = append(instructions, single(SwitchIO, statement))
+		case "Loop Statement":
+			body := Compile(statement.Body, options)
+			loop := single(Loop, statement)
+			if len(body) > 0 {
+				loop.Range.End = body[len(body)-1].Range.End
+			}
+
+			if isSetZero(body, options) {
+				loop.Op = SetZero
+				loop.Body = body
+			} else if (options.StrictCells || !multiply(&loop, body)) && !scan(&loop, body) {
+				loop.Body = body
+			}
+			instructions = append(instructions, loop)
+		}
+	}
+
+	return instructions
+}
@@ -0,0 +1,94 @@
+package ir
+
+import (
+	"testing"
+
+	"github.com/CanPacis/brainfuck-interpreter/parser"
+)
+
+func compile(t *testing.T, source string) []Instruction {
+	p := parser.NewParser("test.bfi")
+	if err := p.Parse(source); err.Reason != nil {
+		t.Fatalf("Unexpected parse error %s", err.String())
+	}
+
+	return Compile(p.Program, Options{})
+}
+
+func TestFolding(t *testing.T) {
+	instructions := compile(t, "+++-->><<<.")
+
+	if len(instructions) != 3 {
+		t.Fatalf("Expected 3 instructions found %d", len(instructions))
+	}
+
+	add := instructions[0]
+	if add.Op != Add || add.Value != 1 || len(add.Origins) != 5 {
+		t.Errorf("Incorrect add instruction %+v", add)
+	}
+	if add.Range.Start.Column != 1 || add.Range.End.Column != 5 {
+		t.Errorf("Incorrect add range %+v", add.Range)
+	}
+
+	move := instructions[1]
+	if move.Op != Move || move.Value != -1 || move.Min != -1 || move.Max != 2 {
+		t.Errorf("Incorrect move instruction %+v", move)
+	}
+
+	origin, ok := move.Fault(0, 0, 1)
+	if !ok || origin.Column != 7 {
+		t.Errorf("Incorrect fault %+v", origin)
+	}
+}
+
+func TestSetZero(t *testing.T) {
+	instructions := compile(t, "+[-]>[+]>[--]")
+
+	if instructions[1].Op != SetZero || instructions[3].Op != SetZero {
+		t.Errorf("Expected [-] and [+] to be set-zero")
+	}
+	if instructions[5].Op != Loop {
+		t.Errorf("Expected [--] to stay a loop")
+	}
+}
+
+func TestMultiply(t *testing.T) {
+	instructions := compile(t, "[->+>++<<]>[->+<<]>[->+<+]")
+
+	multiply := instructions[0]
+	if multiply.Op != Multiply || len(multiply.Terms) != 2 {
+		t.Fatalf("Expected a multiply loop found %+v", multiply)
+	}
+	if multiply.Terms[0] != (Term{Offset: 1, Factor: 1}) || multiply.Terms[1] != (Term{Offset: 2, Factor: 2}) {
+		t.Errorf("Incorrect terms %+v", multiply.Terms)
+	}
+	if multiply.Min != 0 || multiply.Max != 2 {
+		t.Errorf("Incorrect bounds %d %d", multiply.Min, multiply.Max)
+	}
+
+	if instructions[2].Op != Loop {
+		t.Errorf("Expected an unbalanced loop to stay a loop")
+	}
+	if instructions[4].Op != Loop {
+		t.Errorf("Expected a loop that does not decrement its counter to stay a loop")
+	}
+}
+
+func TestScan(t *testing.T) {
+	instructions := compile(t, "[<]>[>>]>[<>]")
+
+	if instructions[0].Op != Scan || instructions[0].Value != -1 {
+		t.Errorf("Expected [<] to be a scan found %+v", instructions[0])
+	}
+	if instructions[2].Op != Scan || instructions[2].Value != 2 {
+		t.Errorf("Expected [>>] to be a scan found %+v", instructions[2])
+	}
+	if instructions[4].Op != Loop {
+		t.Errorf("Expected [<>] to stay a loop")
+	}
+
+	origin, ok := instructions[0].Fault(0, 0, 10)
+	if !ok || origin.Column != 2 || origin.Delta != -1 {
+		t.Errorf("Incorrect fault %+v", origin)
+	}
+}
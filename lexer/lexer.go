@@ -69,11 +69,18 @@ func (l *Lexer) Lex(input string) {
 			l.Tokens = append(l.Tokens, l.CreateToken("star", "*"))
 		case 32:
 			l.Tokens = append(l.Tokens, l.CreateToken("space", " "))
+		case '\t', '\r':
+			l.Tokens = append(l.Tokens, l.CreateToken("whitespace", string(char)))
 		case 10:
-			l.CreateToken("new_line", "\n")
+			l.Tokens = append(l.Tokens, l.CreateToken("new_line", "\n"))
 		case '\\':
-			l.CreateToken("escape", "\\\\")
-			index++
+			// an escaped newline still ends its line
+			if index+1 < len(input) && input[index+1] != 10 {
+				l.Tokens = append(l.Tokens, l.CreateToken("escape", input[index:index+2]))
+				index++
+			} else {
+				l.Tokens = append(l.Tokens, l.CreateToken("escape", "\\"))
+			}
 		case 'i':
 			consumed := l.LexIoKeyword(input[index:])
 			index += consumed
@@ -81,19 +88,42 @@ func (l *Lexer) Lex(input string) {
 			if char == 'd' {
 				consumed := l.LexDebug(input[index:])
 				index += consumed
-			} else {
-				for _, keyword := range Keywords {
-					if strings.HasPrefix(keyword, string(input[index])) {
-						consumed := l.LexKeyword(input[index:])
-						index += consumed
-					}
+				continue
+			}
+
+			keyword := false
+			for _, k := range Keywords {
+				if strings.HasPrefix(k, string(input[index])) {
+					consumed := l.LexKeyword(input[index:])
+					index += consumed
+					keyword = true
+					break
 				}
 			}
+			if !keyword {
+				l.LexComment(input[index : index+1])
+			}
 		}
 
 	}
 }
 
+// LexComment adds text that isn't code to the comment right before it, or
+// starts a comment with it. Comments are kept so that the source can be
+// written back, the parser skips them.
+func (l *Lexer) LexComment(text string) {
+	if last := len(l.Tokens) - 1; last >= 0 {
+		token := &l.Tokens[last]
+		if token.Type == "comment" && token.Position.Line == l.CurrentPosition.Line && token.Position.Column+uint(len(token.Value)) == l.CurrentPosition.Column {
+			token.Value += text
+			l.CurrentPosition.Column += uint(len(text))
+			return
+		}
+	}
+
+	l.Tokens = append(l.Tokens, l.CreateToken("comment", text))
+}
+
 func (l *Lexer) LexKeyword(input string) int {
 	if len(input) > 2 {
 		for _, keyword := range Keywords {
@@ -113,30 +143,26 @@ func (l *Lexer) LexKeyword(input string) int {
 		}
 	}
 
-	l.CurrentPosition.Column++
+	l.LexComment(input[:1])
 	return 0
 }
 
 func (l *Lexer) LexIoKeyword(input string) int {
-	if len(input) < 2 {
-		return 0
-	}
-
-	if input[:2] == "io" {
+	if len(input) >= 2 && input[:2] == "io" {
 		l.Tokens = append(l.Tokens, l.CreateToken("io", "io"))
 		return 1
 	} else {
-		l.CurrentPosition.Column++
+		l.LexComment(input[:1])
 		return 0
 	}
 }
 
 func (l *Lexer) LexDebug(input string) int {
-	if input[:5] == "debug" {
+	if len(input) >= 5 && input[:5] == "debug" {
 		l.Tokens = append(l.Tokens, l.CreateToken("debug", "debug"))
 		return 4
 	} else {
-		l.CurrentPosition.Column++
+		l.LexComment(input[:1])
 		return 0
 	}
 }
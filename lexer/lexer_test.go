@@ -0,0 +1,81 @@
+package lexer
+
+import "testing"
+
+func TestDebugAtTheEnd(t *testing.T) {
+	// a d without four more characters after it used to be sliced past the
+	// end of the input
+	for _, source := range []string{"+d", "+de", "+debu"} {
+		l := Lexer{CurrentPosition: Position{Line: 1, Column: 1}}
+		l.Lex(source)
+
+		for _, token := range l.Tokens {
+			if token.Type == "debug" {
+				t.Errorf("Expected no debug token in %q found one at %v", source, token.Position)
+			}
+		}
+	}
+
+	l := Lexer{CurrentPosition: Position{Line: 1, Column: 1}}
+	l.Lex("+debug")
+	if last := l.Tokens[len(l.Tokens)-1]; last.Type != "debug" {
+		t.Errorf("Expected +debug to end with a debug token found %v", last)
+	}
+}
+
+func TestPositions(t *testing.T) {
+	l := Lexer{CurrentPosition: Position{Line: 1, Column: 1}}
+	l.Lex("hi +\\.\t-\\\n> x\ty <")
+
+	expected := []Token{
+		{"comment", "hi", Position{1, 1}},
+		{"space", " ", Position{1, 3}},
+		{"plus", "+", Position{1, 4}},
+		{"escape", "\\.", Position{1, 5}},
+		{"whitespace", "\t", Position{1, 7}},
+		{"minus", "-", Position{1, 8}},
+		{"escape", "\\", Position{1, 9}},
+		{"new_line", "\n", Position{1, 10}},
+		{"move_right", ">", Position{2, 1}},
+		{"space", " ", Position{2, 2}},
+		{"comment", "x", Position{2, 3}},
+		{"whitespace", "\t", Position{2, 4}},
+		{"comment", "y", Position{2, 5}},
+		{"space", " ", Position{2, 6}},
+		{"move_left", "<", Position{2, 7}},
+	}
+
+	if len(l.Tokens) != len(expected) {
+		t.Fatalf("Expected %d tokens found %v", len(expected), l.Tokens)
+	}
+	for i, token := range l.Tokens {
+		if token != expected[i] {
+			t.Errorf("Expected %v found %v", expected[i], token)
+		}
+	}
+}
+
+func TestMovedPositions(t *testing.T) {
+	// was is where the lexer put the + before it counted every character
+	for _, position := range []struct {
+		source   string
+		was, now Position
+	}{
+		{"x+", Position{1, 1}, Position{1, 2}},
+		{"\t+", Position{1, 1}, Position{1, 2}},
+		{"a\nb+", Position{2, 1}, Position{2, 2}},
+		{"\\\n+", Position{1, 3}, Position{2, 1}},
+		{"ab\\\ncd+", Position{1, 4}, Position{2, 3}},
+		{"hi+", Position{1, 3}, Position{1, 3}},
+		{"\\.+", Position{1, 3}, Position{1, 3}},
+	} {
+		l := Lexer{CurrentPosition: Position{Line: 1, Column: 1}}
+		l.Lex(position.source)
+
+		for _, token := range l.Tokens {
+			if token.Type == "plus" && token.Position != position.now {
+				t.Errorf("Expected the + of %q at %v (it was at %v) found %v", position.source, position.now, position.was, token.Position)
+			}
+		}
+	}
+}
@@ -1,42 +1,433 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/CanPacis/brainfuck-interpreter/bf_errors"
 	"github.com/CanPacis/brainfuck-interpreter/bf_io"
+	"github.com/CanPacis/brainfuck-interpreter/compile"
+	"github.com/CanPacis/brainfuck-interpreter/dap"
 	"github.com/CanPacis/brainfuck-interpreter/engine"
+	"github.com/CanPacis/brainfuck-interpreter/format"
+	"github.com/CanPacis/brainfuck-interpreter/parser"
+	"github.com/CanPacis/brainfuck-interpreter/profile"
+	"github.com/CanPacis/brainfuck-interpreter/repl"
+	"github.com/CanPacis/brainfuck-interpreter/trace"
+	"github.com/CanPacis/brainfuck-interpreter/tui"
 	"github.com/alecthomas/kong"
 )
 
 type Run struct {
-	Path  string `arg:"" name:"path" type:"path"`
-	Debug bool   `help:"Attach a debugger (currently not working)."`
-	File  string `help:"Provide an io source for file. The default is 'io.txt'."`
-	Http  string `help:"Provide an io source for http. The default is ':8080'."`
+	Path             string                       `arg:"" name:"path" type:"path"`
+	Debug            bool                         `help:"Attach a debugger, the program pauses at debug statements."`
+	DebugStopOnEntry bool                         `help:"Pause before the first statement and whenever a debugger attaches while the program runs."`
+	DebugListen      string                       `help:"Wait for the debugger on a tcp or unix socket (tcp://host:port or unix:///path) instead of stdout and stdin." placeholder:"ADDRESS"`
+	DebugNoWait      bool                         `help:"Start the program without waiting for the debugger to attach."`
+	DebugHistory     int                          `help:"Memory the debugger may use to record the execution history in MiB, 0 turns it off." default:"64"`
+	Mode             engine.ExecutionMode         `help:"Select the executor, 'vm' runs bytecode, 'tree' walks the parsed statements and 'jit' compiles to machine code." enum:"vm,tree,jit" default:"vm"`
+	JIT              bool                         `name:"jit" help:"Compile the program to machine code on linux/amd64, the same as --mode=jit."`
+	TapeSize         int                          `help:"Number of cells on the tape, -1 makes it unbounded." default:"30000"`
+	CellWidth        engine.CellWidth             `help:"Width of a cell in bits, one of 8, 16 or 32." default:"8"`
+	CellOverflow     engine.CellOverflowPolicy    `help:"What happens when a cell goes out of range." enum:"wrap,saturate,error" default:"wrap"`
+	PointerOverflow  engine.PointerOverflowPolicy `help:"What happens when the cursor moves off the tape." enum:"error,wrap,grow" default:"error"`
+	EOF              engine.EOFBehavior           `name:"eof" help:"What ',' does to the cell once the input has ended." enum:"zero,minus-one,unchanged,error" default:"zero"`
+	MaxSteps         uint64                       `help:"Stop the program after this many steps, 0 means no limit."`
+	Timeout          time.Duration                `help:"Stop the program after running this long, 0 means no limit."`
+	MaxOutput        uint64                       `help:"Stop the program once it writes more than this many bytes, 0 means no limit."`
+	AllowIO          []string                     `name:"allow-io" help:"Only allow switching to these io targets, std is always allowed." placeholder:"TARGET,..."`
+	File             string                       `help:"Provide an io source for file. The default is 'io.txt'."`
+	Http             string                       `help:"Provide an io source for http. The default is ':8080'."`
+	Tcp              string                       `help:"Provide an io source for tcp. The default is ':8081'."`
+	TcpDial          bool                         `help:"Dial the tcp address instead of listening on it."`
+	Trace            string                       `help:"Record the steps the program takes to a JSON lines trace file." type:"path" placeholder:"FILE"`
+	TraceFilter      []string                     `help:"Only trace these kinds of steps, or the groups io, loops, tape and moves." placeholder:"KIND,..."`
+	TraceSample      uint                         `help:"Only trace every nth step that passes the filter." default:"1"`
 }
 
 func (r *Run) Run(ctx *kong.Context) error {
+	history := r.DebugHistory << 20
+	if history <= 0 {
+		history = engine.NoHistory
+	}
+
+	var tracer *trace.Writer
+	if len(r.Trace) > 0 {
+		kinds, err := trace.ParseFilter(r.TraceFilter)
+		if err != nil {
+			return err
+		}
+
+		file, err := os.Create(r.Trace)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		tracer = trace.NewWriter(file, r.Path, trace.Options{Kinds: kinds, Sample: r.TraceSample})
+	}
+
+	if r.JIT {
+		r.Mode = engine.JIT
+	}
+
+	options := engine.EngineOptions{
+		FilePath:         r.Path,
+		AttachDebugger:   r.Debug,
+		DebugListen:      r.DebugListen,
+		DebugNoWait:      r.DebugNoWait,
+		DebugStopOnEntry: r.DebugStopOnEntry,
+		HistoryLimit:     history,
+		Mode:             r.Mode,
+		TapeSize:         r.TapeSize,
+		CellWidth:        r.CellWidth,
+		CellOverflow:     r.CellOverflow,
+		PointerOverflow:  r.PointerOverflow,
+		EOF:              r.EOF,
+		MaxSteps:         r.MaxSteps,
+		Timeout:          r.Timeout,
+		MaxOutput:        r.MaxOutput,
+		AllowedIO:        r.AllowIO,
+		IOSourceList: bf_io.IOSourceList{
+			File:    r.File,
+			Http:    r.Http,
+			Tcp:     r.Tcp,
+			TcpDial: r.TcpDial,
+		},
+	}
+	if tracer != nil {
+		options.Tracer = tracer
+	}
+
+	e := engine.NewEngine(options)
+
+	if e.Debugger.Exists && !e.Debugger.Stdio() {
+		fmt.Fprintf(os.Stderr, "debugger listening on %s\n", e.Debugger.Addr())
+	}
+
+	// the engine has already reported the error, this only sets the status
+	status, err := e.Run()
+	if tracer != nil {
+		if traceErr := tracer.Close(status, err); traceErr != nil {
+			fmt.Fprintf(os.Stderr, "failed to write the trace: %s\n", traceErr)
+		}
+	}
+
+	if status != 0 {
+		os.Exit(status)
+	}
+	return nil
+}
+
+type Check struct {
+	Path    string   `arg:"" name:"path" type:"path"`
+	AllowIO []string `name:"allow-io" help:"Fail if the program could switch to any other io target, std is always allowed." placeholder:"TARGET,..."`
+}
+
+func (c *Check) Run(ctx *kong.Context) error {
+	e := engine.NewEngine(engine.EngineOptions{
+		FilePath:  c.Path,
+		AllowedIO: c.AllowIO,
+	})
+
+	targets, err := e.Preflight()
+	for _, target := range targets {
+		fmt.Println(target)
+	}
+
+	var runtimeError bf_errors.RuntimeError
+	if errors.As(err, &runtimeError) {
+		runtimeError.Write(os.Stderr)
+		os.Exit(engine.ExitStatus(runtimeError))
+	}
+	return nil
+}
+
+type Debug struct {
+	Path            string                       `arg:"" name:"path" type:"path"`
+	History         int                          `help:"Memory used to record the execution history in MiB, 0 turns it off." default:"64"`
+	TapeSize        int                          `help:"Number of cells on the tape, -1 makes it unbounded." default:"30000"`
+	CellWidth       engine.CellWidth             `help:"Width of a cell in bits, one of 8, 16 or 32." default:"8"`
+	CellOverflow    engine.CellOverflowPolicy    `help:"What happens when a cell goes out of range." enum:"wrap,saturate,error" default:"wrap"`
+	PointerOverflow engine.PointerOverflowPolicy `help:"What happens when the cursor moves off the tape." enum:"error,wrap,grow" default:"error"`
+	EOF             engine.EOFBehavior           `name:"eof" help:"What ',' does to the cell once the input has ended." enum:"zero,minus-one,unchanged,error" default:"zero"`
+}
+
+func (d *Debug) Run(ctx *kong.Context) error {
+	history := d.History << 20
+	if history <= 0 {
+		history = engine.NoHistory
+	}
+
+	status, err := tui.Run(d.Path, engine.EngineOptions{
+		HistoryLimit:    history,
+		TapeSize:        d.TapeSize,
+		CellWidth:       d.CellWidth,
+		CellOverflow:    d.CellOverflow,
+		PointerOverflow: d.PointerOverflow,
+		EOF:             d.EOF,
+	}, os.Stdin, os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	if status != 0 {
+		os.Exit(status)
+	}
+	return nil
+}
+
+type Profile struct {
+	Path            string                       `arg:"" name:"path" type:"path"`
+	Pprof           string                       `help:"Also write the profile in the pprof format, for go tool pprof." type:"path" placeholder:"FILE"`
+	Top             int                          `help:"Number of positions listed by the time they took, 0 lists all of them." default:"20"`
+	TapeSize        int                          `help:"Number of cells on the tape, -1 makes it unbounded." default:"30000"`
+	CellWidth       engine.CellWidth             `help:"Width of a cell in bits, one of 8, 16 or 32." default:"8"`
+	CellOverflow    engine.CellOverflowPolicy    `help:"What happens when a cell goes out of range." enum:"wrap,saturate,error" default:"wrap"`
+	PointerOverflow engine.PointerOverflowPolicy `help:"What happens when the cursor moves off the tape." enum:"error,wrap,grow" default:"error"`
+	EOF             engine.EOFBehavior           `name:"eof" help:"What ',' does to the cell once the input has ended." enum:"zero,minus-one,unchanged,error" default:"zero"`
+	MaxSteps        uint64                       `help:"Stop the program after this many steps, 0 means no limit."`
+	Timeout         time.Duration                `help:"Stop the program after running this long, 0 means no limit."`
+}
+
+func (p *Profile) Run(ctx *kong.Context) error {
+	profiler := profile.NewProfiler()
 	e := engine.NewEngine(engine.EngineOptions{
-		FilePath:       r.Path,
-		AttachDebugger: r.Debug,
+		FilePath:        p.Path,
+		TapeSize:        p.TapeSize,
+		CellWidth:       p.CellWidth,
+		CellOverflow:    p.CellOverflow,
+		PointerOverflow: p.PointerOverflow,
+		EOF:             p.EOF,
+		MaxSteps:        p.MaxSteps,
+		Timeout:         p.Timeout,
+		Tracer:          profiler,
+	})
+
+	// a program that fails is still profiled up to where it failed
+	status, _ := e.Run()
+	result := profiler.Profile(e.Path, e.Content, e.Parser.Program)
+
+	if err := result.WriteListing(os.Stderr, p.Top); err != nil {
+		return err
+	}
+
+	if len(p.Pprof) > 0 {
+		file, err := os.Create(p.Pprof)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		if err := result.WritePprof(file); err != nil {
+			return err
+		}
+	}
+
+	if status != 0 {
+		os.Exit(status)
+	}
+	return nil
+}
+
+type Compile struct {
+	Path     string         `arg:"" name:"path" type:"path"`
+	Target   compile.Target `help:"Language to compile to." enum:"c,go,js,wasm,wasi" required:""`
+	Output   string         `short:"o" help:"Write the source or module to this file instead of stdout." type:"path" placeholder:"FILE"`
+	TapeSize int            `help:"Number of cells on the tape." default:"30000"`
+	File     string         `help:"File the program reads and writes after switching to io file." default:"io.txt"`
+	Package  string         `help:"Name of the generated Go package, the name of the file by default."`
+}
+
+func (c *Compile) Run(ctx *kong.Context) error {
+	content, err := os.ReadFile(c.Path)
+	if err != nil {
+		return err
+	}
+
+	p := parser.NewParser(c.Path)
+	if err := p.Parse(string(content)); err.Reason != nil {
+		err.Write(os.Stderr)
+		os.Exit(engine.ExitStatus(err))
+	}
+
+	source, err := compile.Compile(p.Program, compile.Options{
+		Target:   c.Target,
+		File:     c.Path,
+		TapeSize: c.TapeSize,
+		IOFile:   c.File,
+		Package:  c.Package,
+	})
+	var runtimeError bf_errors.RuntimeError
+	if errors.As(err, &runtimeError) {
+		runtimeError.Write(os.Stderr)
+		os.Exit(engine.ExitStatus(runtimeError))
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(c.Output) == 0 {
+		_, err = os.Stdout.Write(source)
+		return err
+	}
+	return os.WriteFile(c.Output, source, 0644)
+}
+
+type Dap struct {
+	Listen string `help:"Serve a single client on this tcp address instead of stdio."`
+}
+
+func (d *Dap) Run(ctx *kong.Context) error {
+	if len(d.Listen) == 0 {
+		return dap.NewServer(os.Stdin, os.Stdout).Serve()
+	}
+
+	listener, err := net.Listen("tcp", d.Listen)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	connection, err := listener.Accept()
+	if err != nil {
+		return err
+	}
+	defer connection.Close()
+
+	return dap.NewServer(connection, connection).Serve()
+}
+
+type Repl struct {
+	History         string                       `help:"Keep the lines run in this file between sessions." type:"path" placeholder:"FILE"`
+	Mode            engine.ExecutionMode         `help:"Select the executor, 'vm' runs bytecode, 'tree' walks the parsed statements and 'jit' compiles to machine code." enum:"vm,tree,jit" default:"vm"`
+	TapeSize        int                          `help:"Number of cells on the tape, -1 makes it unbounded." default:"30000"`
+	CellWidth       engine.CellWidth             `help:"Width of a cell in bits, one of 8, 16 or 32." default:"8"`
+	CellOverflow    engine.CellOverflowPolicy    `help:"What happens when a cell goes out of range." enum:"wrap,saturate,error" default:"wrap"`
+	PointerOverflow engine.PointerOverflowPolicy `help:"What happens when the cursor moves off the tape." enum:"error,wrap,grow" default:"error"`
+	EOF             engine.EOFBehavior           `name:"eof" help:"What ',' does to the cell once the input has ended." enum:"zero,minus-one,unchanged,error" default:"zero"`
+	Timeout         time.Duration                `help:"Stop a line after running this long, 0 means no limit."`
+	File            string                       `help:"Provide an io source for file. The default is 'io.txt'."`
+	Http            string                       `help:"Provide an io source for http. The default is ':8080'."`
+	Tcp             string                       `help:"Provide an io source for tcp. The default is ':8081'."`
+}
+
+func (r *Repl) Run(ctx *kong.Context) error {
+	return repl.Run(engine.EngineOptions{
+		Mode:            r.Mode,
+		TapeSize:        r.TapeSize,
+		CellWidth:       r.CellWidth,
+		CellOverflow:    r.CellOverflow,
+		PointerOverflow: r.PointerOverflow,
+		EOF:             r.EOF,
+		Timeout:         r.Timeout,
 		IOSourceList: bf_io.IOSourceList{
 			File: r.File,
 			Http: r.Http,
+			Tcp:  r.Tcp,
 		},
-	})
+	}, r.History, os.Stdin, os.Stdout)
+}
+
+type Fmt struct {
+	Paths []string `arg:"" name:"path" help:"Programs to format, directories are searched for .bf and .bfi files."`
+	Check bool     `help:"List the programs that aren't formatted instead of rewriting them and fail if there are any."`
+	Diff  bool     `help:"Print the changes formatting would make instead of rewriting the programs and fail if there are any."`
+}
+
+func (f *Fmt) Run(ctx *kong.Context) error {
+	paths := []string{}
+	for _, path := range f.Paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			paths = append(paths, path)
+			continue
+		}
+
+		err = filepath.WalkDir(path, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if extension := filepath.Ext(path); !entry.IsDir() && (extension == ".bf" || extension == ".bfi") {
+				paths = append(paths, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
 
-	e.Run()
+	status := 0
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		formatted, err := format.Source(path, content)
+		var runtimeError bf_errors.RuntimeError
+		if errors.As(err, &runtimeError) {
+			runtimeError.Write(os.Stderr)
+			status = engine.ExitStatus(runtimeError)
+			continue
+		} else if err != nil {
+			return err
+		}
+		if bytes.Equal(content, formatted) {
+			continue
+		}
+
+		switch {
+		case f.Diff:
+			os.Stdout.Write(format.Diff(path+".orig", path, content, formatted))
+		case f.Check:
+			fmt.Println(path)
+		default:
+			if err := os.WriteFile(path, formatted, 0644); err != nil {
+				return err
+			}
+			continue
+		}
+		if status == 0 {
+			status = 1
+		}
+	}
+
+	if status != 0 {
+		os.Exit(status)
+	}
 	return nil
 }
 
 var CLI struct {
-	Run Run `cmd:"run"`
+	Run     Run     `cmd:"run"`
+	Check   Check   `cmd:"check" help:"Report the io targets a program could switch to without running it."`
+	Debug   Debug   `cmd:"debug" help:"Debug a program in the terminal."`
+	Dap     Dap     `cmd:"dap" help:"Run a Debug Adapter Protocol server for editors."`
+	Profile Profile `cmd:"profile" help:"Run a program and report how often and how long each of its symbols ran."`
+	Compile Compile `cmd:"compile" help:"Compile a program to C, Go or JavaScript source or to a WebAssembly module."`
+	Repl    Repl    `cmd:"repl" help:"Run brainfuck a line at a time on a tape that persists between lines."`
+	Fmt     Fmt     `cmd:"fmt" help:"Format programs, indenting loops and keeping every comment."`
 }
 
 func main() {
 	ctx := kong.Parse(&CLI)
 
 	switch ctx.Command() {
-	case "run <path>":
+	case "check <path>":
 		ctx.Run()
+	case "run <path>", "debug <path>", "dap", "profile <path>", "compile <path>", "repl", "fmt <path>":
+		ctx.FatalIfErrorf(ctx.Run())
 	default:
 		panic(ctx.Command())
 	}
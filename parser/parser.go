@@ -97,10 +97,23 @@ func parse(tokens []lexer.Token) ([]Statement, int, lexer.Position, error) {
 	return statements, index + 1, lexer.Position{}, nil
 }
 
+// code leaves out the comments and the whitespace the lexer keeps.
+func code(tokens []lexer.Token) []lexer.Token {
+	code := make([]lexer.Token, 0, len(tokens))
+	for _, token := range tokens {
+		switch token.Type {
+		case "comment", "escape", "new_line", "whitespace":
+			continue
+		}
+		code = append(code, token)
+	}
+	return code
+}
+
 func (p *Parser) Parse(input string) bf_errors.RuntimeError {
 	p.Lexer.Lex(input)
 
-	statments, _, position, err := parse(p.Lexer.Tokens)
+	statments, _, position, err := parse(code(p.Lexer.Tokens))
 
 	if err != nil {
 		return bf_errors.CreateSyntaxError(err, position, p.FilePath)
@@ -110,6 +123,31 @@ func (p *Parser) Parse(input string) bf_errors.RuntimeError {
 	return bf_errors.EmptyError
 }
 
+// IOTargets reports the first switch statement for every io target a
+// program could switch to, in the order they appear.
+func IOTargets(program []Statement) []Statement {
+	targets := []Statement{}
+	seen := map[string]bool{}
+
+	var walk func(program []Statement)
+	walk = func(program []Statement) {
+		for _, statement := range program {
+			switch statement.Type {
+			case "Switch IO Statement":
+				if !seen[statement.IOTarget] {
+					seen[statement.IOTarget] = true
+					targets = append(targets, statement)
+				}
+			case "Loop Statement":
+				walk(statement.Body)
+			}
+		}
+	}
+	walk(program)
+
+	return targets
+}
+
 func NewParser(filePath string) Parser {
 	return Parser{
 		FilePath: filePath,
@@ -0,0 +1,72 @@
+package profile
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// share is the part of the whole run a duration took in percent.
+func (p *Profile) share(d time.Duration) float64 {
+	if p.Time <= 0 {
+		return 0
+	}
+	return float64(d) / float64(p.Time) * 100
+}
+
+// WriteListing writes the source with the steps and the time share of
+// every line, then the loops and the top positions that took the most time.
+// Every position is listed when top is zero.
+func (p *Profile) WriteListing(w io.Writer, top int) error {
+	b := bufio.NewWriter(w)
+
+	fmt.Fprintf(b, "%s: %d steps in %s\n\n", p.File, p.Steps, p.Time)
+
+	type line struct {
+		steps uint64
+		time  time.Duration
+	}
+	lines := map[uint]*line{}
+	for _, position := range p.Positions {
+		if lines[position.Line] == nil {
+			lines[position.Line] = &line{}
+		}
+		lines[position.Line].steps += position.Steps
+		lines[position.Line].time += position.Time
+	}
+
+	fmt.Fprintf(b, "%10s %7s %5s\n", "steps", "time", "line")
+	for i, text := range strings.Split(strings.TrimRight(p.Source, "\n"), "\n") {
+		if counts, ok := lines[uint(i+1)]; ok {
+			fmt.Fprintf(b, "%10d %6.1f%% %5d  %s\n", counts.steps, p.share(counts.time), i+1, text)
+		} else {
+			fmt.Fprintf(b, "%10s %7s %5d  %s\n", "", "", i+1, text)
+		}
+	}
+
+	if len(p.Loops) > 0 {
+		fmt.Fprintf(b, "\n%-10s %10s %10s %10s %7s\n", "loop", "entries", "iterations", "steps", "time")
+		for _, loop := range p.Loops {
+			fmt.Fprintf(b, "%-10s %10d %10d %10d %6.1f%%\n", fmt.Sprintf("%d:%d", loop.Line, loop.Column), loop.Entries, loop.Iterations, loop.Steps, p.share(loop.Time))
+		}
+	}
+
+	positions := slices.Clone(p.Positions)
+	slices.SortStableFunc(positions, func(a, b *Position) int {
+		return cmp.Compare(b.Time, a.Time)
+	})
+	if top > 0 && len(positions) > top {
+		positions = positions[:top]
+	}
+
+	fmt.Fprintf(b, "\n%-10s %-8s %10s %7s\n", "position", "symbol", "steps", "time")
+	for _, position := range positions {
+		fmt.Fprintf(b, "%-10s %-8s %10d %6.1f%%\n", fmt.Sprintf("%d:%d", position.Line, position.Column), position.Symbol, position.Steps, p.share(position.Time))
+	}
+
+	return b.Flush()
+}
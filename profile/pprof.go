@@ -0,0 +1,130 @@
+package profile
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/CanPacis/brainfuck-interpreter/lexer"
+)
+
+// message encodes protocol buffers, just enough of them for profile.proto.
+type message []byte
+
+func (m message) varint(value uint64) message {
+	for value >= 0x80 {
+		m = append(m, byte(value)|0x80)
+		value >>= 7
+	}
+	return append(m, byte(value))
+}
+
+func (m message) uint(field int, value uint64) message {
+	if value == 0 {
+		return m
+	}
+	return m.varint(uint64(field) << 3).varint(value)
+}
+
+func (m message) bytes(field int, value []byte) message {
+	m = m.varint(uint64(field)<<3 | 2).varint(uint64(len(value)))
+	return append(m, value...)
+}
+
+func (m message) packed(field int, values ...uint64) message {
+	var packed message
+	for _, value := range values {
+		packed = packed.varint(value)
+	}
+	return m.bytes(field, packed)
+}
+
+// stringTable is the string table of a profile, the empty string comes
+// first.
+type stringTable struct {
+	table   []string
+	indices map[string]uint64
+}
+
+func (s *stringTable) index(value string) uint64 {
+	if index, ok := s.indices[value]; ok {
+		return index
+	}
+
+	s.indices[value] = uint64(len(s.table))
+	s.table = append(s.table, value)
+	return s.indices[value]
+}
+
+// WritePprof writes the profile in the gzipped protocol buffer format of
+// pprof. Every symbol is a function named after its symbol and position,
+// the loops around it are its callers, so flame graphs show the time spent
+// in every loop.
+func (p *Profile) WritePprof(w io.Writer) error {
+	names := &stringTable{table: []string{""}, indices: map[string]uint64{"": 0}}
+	var profile message
+
+	for _, value := range [][2]string{{"steps", "count"}, {"time", "nanoseconds"}} {
+		profile = profile.bytes(1, message{}.uint(1, names.index(value[0])).uint(2, names.index(value[1])))
+	}
+
+	// the program itself is the root of every stack
+	root := uint64(len(p.Positions) + 1)
+	ids := map[lexer.Position]uint64{}
+	for i, position := range p.Positions {
+		ids[position.Position] = uint64(i + 1)
+	}
+
+	function := func(id uint64, name string, line uint) {
+		profile = profile.bytes(5, message{}.
+			uint(1, id).
+			uint(2, names.index(name)).
+			uint(3, names.index(name)).
+			uint(4, names.index(p.File)).
+			uint(5, uint64(line)))
+	}
+	location := func(id uint64, line uint, column uint) {
+		profile = profile.bytes(4, message{}.
+			uint(1, id).
+			bytes(4, message{}.uint(1, id).uint(2, uint64(line)).uint(3, uint64(column))))
+	}
+
+	for i, position := range p.Positions {
+		id := uint64(i + 1)
+		name := fmt.Sprintf("%s %d:%d", position.Symbol, position.Line, position.Column)
+		if position.Symbol == "[" {
+			name = fmt.Sprintf("loop %d:%d", position.Line, position.Column)
+		}
+
+		function(id, name, position.Line)
+		location(id, position.Line, position.Column)
+
+		stack := []uint64{id}
+		for j := len(position.Loops) - 1; j >= 0; j-- {
+			stack = append(stack, ids[position.Loops[j]])
+		}
+		stack = append(stack, root)
+
+		profile = profile.bytes(2, message{}.
+			packed(1, stack...).
+			packed(2, position.Steps, uint64(position.Time)))
+	}
+
+	function(root, p.File, 1)
+	location(root, 1, 0)
+
+	profile = profile.uint(10, uint64(p.Time))
+	profile = profile.bytes(11, message{}.uint(1, names.index("time")).uint(2, names.index("nanoseconds")))
+	profile = profile.uint(12, 1)
+	profile = profile.uint(14, names.index("time"))
+
+	for _, name := range names.table {
+		profile = profile.bytes(6, []byte(name))
+	}
+
+	compressed := gzip.NewWriter(w)
+	if _, err := compressed.Write(profile); err != nil {
+		return err
+	}
+	return compressed.Close()
+}
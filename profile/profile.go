@@ -0,0 +1,165 @@
+// Package profile attributes how often and how long a program runs each of
+// its symbols, so the hot loops of a program can be found without guessing.
+//
+// A Profiler is a trace.Tracer, the engine runs profiled programs on the
+// tree-walker and every step is counted at the position of its symbol.
+// Times are relative, the folding the virtual machine does makes some
+// symbols a lot cheaper than the tree-walker shows.
+package profile
+
+import (
+	"slices"
+	"time"
+
+	"github.com/CanPacis/brainfuck-interpreter/lexer"
+	"github.com/CanPacis/brainfuck-interpreter/parser"
+	"github.com/CanPacis/brainfuck-interpreter/trace"
+)
+
+// Position is what a single symbol of the program did.
+type Position struct {
+	lexer.Position
+	Symbol string
+	Steps  uint64
+	Time   time.Duration
+	// Entries is how often a loop was reached and Iterations how often it
+	// ran its body, they are zero for other symbols
+	Entries    uint64
+	Iterations uint64
+	// Loops are the positions of the loops around the symbol, the
+	// outermost one first
+	Loops []lexer.Position
+}
+
+// Loop is a loop along with everything it ran.
+type Loop struct {
+	*Position
+	// Steps and Time include the symbols in the body of the loop
+	Steps uint64
+	Time  time.Duration
+}
+
+// Profile is a profiled run of a program.
+type Profile struct {
+	File   string
+	Source string
+	Steps  uint64
+	Time   time.Duration
+	// Positions are the symbols that ran in the order of the source
+	Positions []*Position
+	// Loops are the loops that were reached in the order of the source
+	Loops []Loop
+}
+
+var symbols = map[string]string{
+	"Increment Statement":  "+",
+	"Decrement Statement":  "-",
+	"Move Right Statement": ">",
+	"Move Left Statement":  "<",
+	"Clear Statement":      "*",
+	"Stdout Statement":     ".",
+	"Stdin Statement":      ",",
+	"Loop Statement":       "[",
+}
+
+// Profiler counts the steps of a program run with it as its
+// EngineOptions.Tracer.
+type Profiler struct {
+	positions map[lexer.Position]*Position
+	start     time.Time
+	last      time.Time
+}
+
+// NewProfiler creates a profiler for a single run.
+func NewProfiler() *Profiler {
+	return &Profiler{positions: map[lexer.Position]*Position{}}
+}
+
+// Trace counts a step. Events come in once their step has run, so the
+// time since the last one is the time of this step. The clock starts with
+// the first step so that starting the engine isn't part of it.
+func (p *Profiler) Trace(event trace.Event) {
+	now := time.Now()
+	if p.last.IsZero() {
+		p.start, p.last = now, now
+	}
+
+	position, ok := p.positions[event.Position]
+	if !ok {
+		position = &Position{Position: event.Position}
+		p.positions[event.Position] = position
+	}
+
+	position.Steps++
+	position.Time += now.Sub(p.last)
+	switch event.Kind {
+	case trace.Loop:
+		position.Entries++
+	case trace.Iteration:
+		position.Iterations++
+	}
+
+	p.last = now
+}
+
+// Profile puts the counts together with the parsed program they were made
+// of, once the program has ended.
+func (p *Profiler) Profile(file string, source string, program []parser.Statement) *Profile {
+	profile := &Profile{File: file, Source: source, Time: p.last.Sub(p.start)}
+
+	var walk func(program []parser.Statement, loops []lexer.Position)
+	walk = func(program []parser.Statement, loops []lexer.Position) {
+		for _, statement := range program {
+			position, ok := p.positions[statement.Position]
+			if !ok || statement.Type == "Loop Done" {
+				continue
+			}
+
+			position.Symbol = symbols[statement.Type]
+			if statement.Type == "Switch IO Statement" {
+				position.Symbol = "io " + statement.IOTarget
+			}
+			position.Loops = loops
+			profile.Positions = append(profile.Positions, position)
+
+			if statement.Type == "Loop Statement" {
+				walk(statement.Body, append(slices.Clip(loops), statement.Position))
+			}
+		}
+	}
+	walk(program, nil)
+
+	slices.SortFunc(profile.Positions, func(a, b *Position) int {
+		return compare(a.Position, b.Position)
+	})
+
+	loops := map[lexer.Position]int{}
+	for _, position := range profile.Positions {
+		if position.Symbol == "[" {
+			loops[position.Position] = len(profile.Loops)
+			profile.Loops = append(profile.Loops, Loop{Position: position})
+		}
+	}
+
+	for _, position := range profile.Positions {
+		profile.Steps += position.Steps
+
+		enclosing := position.Loops
+		if position.Symbol == "[" {
+			enclosing = append(slices.Clip(enclosing), position.Position)
+		}
+		for _, loop := range enclosing {
+			profile.Loops[loops[loop]].Steps += position.Steps
+			profile.Loops[loops[loop]].Time += position.Time
+		}
+	}
+
+	return profile
+}
+
+func compare(a, b lexer.Position) int {
+	if a.Line != b.Line {
+		return int(a.Line) - int(b.Line)
+	}
+	return int(a.Column) - int(b.Column)
+}
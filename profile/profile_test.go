@@ -0,0 +1,99 @@
+package profile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/CanPacis/brainfuck-interpreter/engine"
+)
+
+func profileSource(t *testing.T, source string) *Profile {
+	profiler := NewProfiler()
+	e := engine.NewEngineFromSource(source, engine.EngineOptions{Stdout: &bytes.Buffer{}, Tracer: profiler})
+	if _, err := e.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	return profiler.Profile(e.Path, e.Content, e.Parser.Program)
+}
+
+func TestProfile(t *testing.T) {
+	p := profileSource(t, "++\n[>++[>+<-]<-]")
+
+	if p.Steps != 37 || len(p.Positions) != 13 {
+		t.Errorf("Expected 37 steps at 13 positions found %d at %d", p.Steps, len(p.Positions))
+	}
+
+	expected := []struct {
+		column                     uint
+		entries, iterations, steps uint64
+	}{
+		{1, 1, 2, 35},
+		{5, 2, 4, 22},
+	}
+	if len(p.Loops) != len(expected) {
+		t.Fatalf("Expected %d loops found %d", len(expected), len(p.Loops))
+	}
+	for i, loop := range p.Loops {
+		if loop.Line != 2 || loop.Column != expected[i].column || loop.Entries != expected[i].entries || loop.Iterations != expected[i].iterations || loop.Steps != expected[i].steps {
+			t.Errorf("Expected %+v found %d:%d %d %d %d", expected[i], loop.Line, loop.Column, loop.Entries, loop.Iterations, loop.Steps)
+		}
+	}
+
+	increment := p.Positions[8]
+	if increment.Symbol != "+" || increment.Column != 7 || increment.Steps != 4 || len(increment.Loops) != 2 {
+		t.Errorf("Incorrect position %+v", increment)
+	}
+}
+
+func TestListing(t *testing.T) {
+	p := profileSource(t, "++\n[>++[>+<-]<-]")
+
+	var out bytes.Buffer
+	if err := p.WriteListing(&out, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	listing := out.String()
+	for _, line := range []string{"37 steps", "1  ++\n", "    35 ", "[>++[>+<-]<-]"} {
+		if !strings.Contains(listing, line) {
+			t.Errorf("Expected the listing to contain %q\n%s", line, listing)
+		}
+	}
+
+	positions := listing[strings.Index(listing, "position"):]
+	if lines := strings.Count(positions, "\n"); lines != 4 {
+		t.Errorf("Expected the top 3 positions found\n%s", positions)
+	}
+}
+
+func TestPprof(t *testing.T) {
+	p := profileSource(t, "+[-]")
+
+	var out bytes.Buffer
+	if err := p.WritePprof(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first field is the steps sample type
+	if len(encoded) == 0 || encoded[0] != 1<<3|2 {
+		t.Fatalf("Incorrect profile %x", encoded)
+	}
+	for _, name := range []string{"steps", "nanoseconds", "loop 1:2", "- 1:3"} {
+		if !bytes.Contains(encoded, []byte(name)) {
+			t.Errorf("Expected the profile to contain %q", name)
+		}
+	}
+}
@@ -0,0 +1,339 @@
+// Package repl runs brainfuck a line at a time on a tape that persists
+// between lines, showing the cells around the cursor after each of them.
+package repl
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	"github.com/CanPacis/brainfuck-interpreter/engine"
+	"github.com/CanPacis/brainfuck-interpreter/lexer"
+)
+
+const help = `a line of brainfuck runs on the tape left by the last one, a line with
+an unclosed [ continues on the next. ctrl-c stops a running line. lines are
+read as the terminal sends them, there is no line editing or arrow key
+recall, run it under rlwrap for those.
+
+commands:
+  :tape [CELL]    show the cells around the cursor or a cell
+  :reset          start over with an empty tape
+  :load FILE      run a file on the tape
+  :io [TARGET]    show the io target or switch to std, file, http or tcp
+  :history        list the lines run so far, numbered
+  !N, !!          run line N of that list again, or the last one
+  :help           show this
+  :quit           leave, so does the end of input`
+
+const (
+	prompt       = "bf> "
+	continuation = "... "
+	// tapeContext is how many cells are shown on each side of the cursor
+	tapeContext = 8
+)
+
+// output remembers whether what the program wrote ended its line.
+type output struct {
+	out  io.Writer
+	open bool
+}
+
+func (o *output) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		o.open = p[len(p)-1] != '\n'
+	}
+	return o.out.Write(p)
+}
+
+// endLine ends a line the program left open.
+func (o *output) endLine() {
+	if o.open {
+		o.Write([]byte{'\n'})
+	}
+}
+
+// lines hands the program the input a line at a time, so that the lines
+// after the one it reads from are left for the repl.
+type lines struct {
+	in *bufio.Reader
+}
+
+func (l lines) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		b, err := l.in.ReadByte()
+		if err != nil {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, err
+		}
+
+		p[n] = b
+		n++
+		if b == '\n' {
+			break
+		}
+	}
+	return n, nil
+}
+
+type session struct {
+	options engine.EngineOptions
+	engine  *engine.Engine
+	in      *bufio.Reader
+	out     *output
+	history []string
+	// file keeps the history between sessions
+	file *os.File
+}
+
+// Run reads lines from in until it ends or :quit, writing the program's
+// output and the tape to out. The history is loaded from and appended to
+// historyFile unless it is empty.
+func Run(options engine.EngineOptions, historyFile string, in io.Reader, out io.Writer) error {
+	s := &session{
+		options: options,
+		in:      bufio.NewReader(in),
+		out:     &output{out: out},
+	}
+	s.options.FilePath = "<repl>"
+	s.options.Stdin = lines{s.in}
+	s.options.Stdout = s.out
+	s.options.Stderr = s.out
+
+	if len(historyFile) > 0 {
+		content, err := os.ReadFile(historyFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if len(content) > 0 {
+			s.history = strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+		}
+
+		s.file, err = os.OpenFile(historyFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			return err
+		}
+		defer s.file.Close()
+	}
+
+	s.reset()
+	defer func() {
+		s.engine.Close()
+	}()
+
+	fmt.Fprintln(s.out, "brainfuck repl, :help lists the commands")
+	pending := ""
+	for {
+		if len(pending) > 0 {
+			fmt.Fprint(s.out, continuation)
+		} else {
+			fmt.Fprint(s.out, prompt)
+		}
+
+		line, err := s.in.ReadString('\n')
+		if err != nil && len(line) == 0 {
+			fmt.Fprintln(s.out)
+			return nil
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		if len(pending) == 0 {
+			command := strings.TrimSpace(line)
+			switch {
+			case len(command) == 0:
+				continue
+			case strings.HasPrefix(command, ":"):
+				if s.command(command) {
+					return nil
+				}
+				continue
+			case strings.HasPrefix(command, "!"):
+				s.recall(command)
+				continue
+			}
+		}
+
+		pending += line + "\n"
+		switch depth := depth(pending); {
+		case depth > 0:
+			continue
+		case depth < 0:
+			fmt.Fprintln(s.out, "error: ] without a [")
+		default:
+			s.remember(strings.TrimSuffix(pending, "\n"))
+			s.eval(pending)
+		}
+		pending = ""
+	}
+}
+
+// depth is how many loops the source leaves open, it is negative when a
+// loop is closed that wasn't opened.
+func depth(source string) int {
+	l := lexer.Lexer{CurrentPosition: lexer.Position{Line: 1, Column: 1}}
+	l.Lex(source)
+
+	depth := 0
+	for _, token := range l.Tokens {
+		switch token.Type {
+		case "loop_open":
+			depth++
+		case "loop_close":
+			depth--
+			if depth < 0 {
+				return depth
+			}
+		}
+	}
+	return depth
+}
+
+func (s *session) reset() {
+	if s.engine != nil {
+		s.engine.Close()
+	}
+	s.engine = engine.NewEngineFromSource("", s.options)
+}
+
+// remember adds an entry to the history, its lines are joined so that it
+// is one line of the history file.
+func (s *session) remember(entry string) {
+	entry = strings.ReplaceAll(entry, "\n", " ")
+	s.history = append(s.history, entry)
+	if s.file != nil {
+		fmt.Fprintln(s.file, entry)
+	}
+}
+
+// eval runs source on the tape, a ctrl-c stops it.
+func (s *session) eval(source string) {
+	// the prompt leaves its line open but the input ends it
+	s.out.open = false
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	err := s.engine.Eval(ctx, source)
+	stop()
+
+	s.out.endLine()
+	var exitError *engine.ExitError
+	if errors.As(err, &exitError) {
+		fmt.Fprint(s.out, exitError.Err.String())
+	} else if err != nil {
+		fmt.Fprintf(s.out, "error: %s\n", err)
+	}
+	s.tape(int(s.engine.Cursor))
+}
+
+// recall runs an entry of the history again.
+func (s *session) recall(command string) {
+	index := len(s.history)
+	if command != "!!" {
+		n, err := strconv.Atoi(strings.TrimPrefix(command, "!"))
+		if err != nil || n < 1 || n > len(s.history) {
+			fmt.Fprintf(s.out, "error: there is no line %s in the history\n", strings.TrimPrefix(command, "!"))
+			return
+		}
+		index = n
+	}
+	if index == 0 {
+		fmt.Fprintln(s.out, "error: the history is empty")
+		return
+	}
+
+	entry := s.history[index-1]
+	fmt.Fprintln(s.out, entry)
+	if strings.HasPrefix(entry, ":") {
+		s.command(entry)
+		return
+	}
+	s.remember(entry)
+	s.eval(entry)
+}
+
+// command runs a meta-command and reports whether the repl should end.
+func (s *session) command(command string) bool {
+	name, argument, _ := strings.Cut(command, " ")
+	argument = strings.TrimSpace(argument)
+
+	switch name {
+	case ":help", ":h":
+		fmt.Fprintln(s.out, help)
+	case ":quit", ":q":
+		return true
+	case ":tape", ":t":
+		cell := int(s.engine.Cursor)
+		if len(argument) > 0 {
+			n, err := strconv.Atoi(argument)
+			if err != nil || n < 0 || n >= len(s.engine.Tape) {
+				fmt.Fprintf(s.out, "error: cell %s is outside the tape\n", argument)
+				return false
+			}
+			cell = n
+		}
+		s.tape(cell)
+	case ":reset":
+		s.remember(command)
+		s.reset()
+		s.tape(0)
+	case ":load":
+		content, err := os.ReadFile(argument)
+		if err != nil {
+			fmt.Fprintf(s.out, "error: %s\n", err)
+			return false
+		}
+		s.remember(command)
+		s.eval(string(content))
+	case ":io":
+		if len(argument) == 0 {
+			fmt.Fprintf(s.out, "io %s\n", s.engine.IOTarget())
+			return false
+		}
+		s.remember(command)
+		s.eval("io " + argument)
+	case ":history":
+		for i, entry := range s.history {
+			fmt.Fprintf(s.out, "%4d  %s\n", i+1, entry)
+		}
+	default:
+		fmt.Fprintf(s.out, "unknown command '%s', :help lists the commands\n", name)
+	}
+
+	return false
+}
+
+// tape shows the cells around a cell with the cursor marked.
+func (s *session) tape(center int) {
+	e := s.engine
+
+	var indexes, values, characters, marker strings.Builder
+	for cell := max(0, center-tapeContext); cell <= min(len(e.Tape)-1, center+tapeContext); cell++ {
+		value := e.Tape[cell]
+		character := ""
+		if value >= 32 && value < 127 {
+			character = string(rune(value))
+		}
+
+		fmt.Fprintf(&indexes, "%5d", cell)
+		fmt.Fprintf(&values, "%5d", value)
+		fmt.Fprintf(&characters, "%5s", character)
+		if cell == int(e.Cursor) {
+			marker.WriteString("    ^")
+		} else {
+			marker.WriteString("     ")
+		}
+	}
+
+	for _, line := range []string{"cell " + indexes.String(), "     " + values.String(), "     " + characters.String(), "     " + marker.String()} {
+		if line = strings.TrimRight(line, " "); len(line) > 0 {
+			fmt.Fprintln(s.out, line)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package repl
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/CanPacis/brainfuck-interpreter/engine"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	program := filepath.Join(dir, "seven.bfi")
+	os.WriteFile(program, []byte("+++++++[>+++++++<-]>."), 0o644)
+	history := filepath.Join(dir, "history")
+	os.WriteFile(history, []byte("+++\n"), 0o644)
+
+	lines := strings.Join([]string{
+		"++++++++[>++++++++<-]>+.",
+		"+.",
+		"[",
+		"-",
+		"]",
+		"<<",
+		"]",
+		",.",
+		"Z",
+		":io",
+		":tape 20",
+		":reset",
+		":load " + program,
+		"!1",
+		":history",
+		":bogus",
+		":quit",
+		"+.",
+	}, "\n")
+
+	var out strings.Builder
+	if err := Run(engine.EngineOptions{}, history, strings.NewReader(lines), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	screen := out.String()
+	for _, expected := range []string{
+		"bf> A\n",
+		"bf> B\n",
+		"bf> ... ... cell     0    1    2",
+		"Stack underflow:\t'stack overflow' at line 1 column 2 in <repl>",
+		"error: ] without a [",
+		"bf> Z\n",
+		"io std",
+		"cell    12   13",
+		"   1  +++\n   2  ++++++++[>++++++++<-]>+.\n   3  +.\n   4  [ - ]\n",
+		"unknown command ':bogus'",
+	} {
+		if !strings.Contains(screen, expected) {
+			t.Errorf("Expected the screen to show %q", expected)
+		}
+	}
+
+	// the load runs on a reset tape, the recalled +++ adds to its cell
+	if !strings.Contains(screen, "bf> 1\ncell     0    1") || !strings.Contains(screen, "+++\ncell     0    1    2    3    4    5    6    7    8    9\n         0   52") {
+		t.Errorf("Expected the load and the recalled line to run on the tape\n%s", screen)
+	}
+	if strings.Contains(screen, "bf> +.") {
+		t.Errorf("Expected the repl to end at :quit")
+	}
+
+	content, _ := os.ReadFile(history)
+	if !strings.HasSuffix(string(content), ":load "+program+"\n+++\n") {
+		t.Errorf("Expected the history file to keep the lines found %q", content)
+	}
+}
+
+func TestDepth(t *testing.T) {
+	depths := map[string]int{
+		"[-[":      2,
+		"[-]":      0,
+		"]":        -1,
+		"[]]":      -1,
+		"\\[":      0,
+		"io std\n": 0,
+	}
+
+	for source, expected := range depths {
+		if found := depth(source); found != expected {
+			t.Errorf("Expected %q to leave %d loops open found %d", source, expected, found)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+// Package trace records what a program does step by step, so runs can be
+// looked into after the fact where a debugger can't be attached.
+//
+// A trace file is JSON lines: a header, an event for every recorded step
+// and a footer once the program has ended. The header and the footer are
+// told apart from events by their record field.
+package trace
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/CanPacis/brainfuck-interpreter/lexer"
+)
+
+// Version is the version of the trace format.
+const Version = 1
+
+// Kind is what a step did.
+type Kind string
+
+var (
+	Increment Kind = "increment"
+	Decrement Kind = "decrement"
+	MoveRight Kind = "move-right"
+	MoveLeft  Kind = "move-left"
+	Clear     Kind = "clear"
+	Output    Kind = "output"
+	Input     Kind = "input"
+	SwitchIO  Kind = "switch-io"
+	// Loop is a loop being reached, whether its body runs or not
+	Loop Kind = "loop"
+	// Iteration is a loop running its body once more
+	Iteration Kind = "iteration"
+)
+
+// Kinds are all the kinds of steps.
+var Kinds = []Kind{Increment, Decrement, MoveRight, MoveLeft, Clear, Output, Input, SwitchIO, Loop, Iteration}
+
+// Groups are names a filter can use for several kinds at once.
+var Groups = map[string][]Kind{
+	"all":   Kinds,
+	"io":    {Output, Input, SwitchIO},
+	"loops": {Loop},
+	"tape":  {Increment, Decrement, Clear, Input},
+	"moves": {MoveRight, MoveLeft},
+}
+
+// Event is a single step of a program. Before and After are the values of
+// the cell under the cursor the step ran at, an output step's value is
+// the byte it wrote and an input step's After the byte it read.
+type Event struct {
+	Step uint64 `json:"step"`
+	Kind Kind   `json:"kind"`
+	lexer.Position
+	Cursor uint   `json:"cursor"`
+	Before uint32 `json:"before"`
+	After  uint32 `json:"after"`
+	// Target is the io target a switch-io step switched to
+	Target string `json:"target,omitempty"`
+	// Error is why the step failed, it is the last step of the program
+	Error string `json:"error,omitempty"`
+}
+
+// Tracer gets every step of a program run with EngineOptions.Tracer.
+type Tracer interface {
+	Trace(event Event)
+}
+
+// Header is the first line of a trace file.
+type Header struct {
+	Record  string `json:"record"`
+	Version int    `json:"version"`
+	File    string `json:"file"`
+	Kinds   []Kind `json:"kinds"`
+	Sample  uint   `json:"sample"`
+}
+
+// Footer is the last line of a trace file. Steps counts every step the
+// program took and Recorded the ones that made it into the file.
+type Footer struct {
+	Record   string `json:"record"`
+	Status   int    `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Steps    uint64 `json:"steps"`
+	Recorded uint64 `json:"recorded"`
+}
+
+// Options decide which steps are recorded. Only steps of the given kinds
+// are, all of them when there are none, and of those only every Sample'th
+// one when Sample is more than 1.
+type Options struct {
+	Kinds  []Kind
+	Sample uint
+}
+
+// ParseFilter turns kind and group names into kinds.
+func ParseFilter(names []string) ([]Kind, error) {
+	kinds := []Kind{}
+
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if group, ok := Groups[name]; ok {
+			kinds = append(kinds, group...)
+		} else if slices.Contains(Kinds, Kind(name)) {
+			kinds = append(kinds, Kind(name))
+		} else {
+			return nil, fmt.Errorf("unknown trace filter '%s'", name)
+		}
+	}
+
+	slices.Sort(kinds)
+	return slices.Compact(kinds), nil
+}
+
+// Writer writes a trace file. Errors are kept until Close reports them,
+// so tracing never stops a program.
+type Writer struct {
+	out      *bufio.Writer
+	kinds    map[Kind]bool
+	sample   uint64
+	matched  uint64
+	steps    uint64
+	recorded uint64
+	err      error
+}
+
+// NewWriter starts a trace file of the program at file.
+func NewWriter(w io.Writer, file string, options Options) *Writer {
+	kinds := options.Kinds
+	if len(kinds) == 0 {
+		kinds = Kinds
+	}
+
+	writer := &Writer{out: bufio.NewWriter(w), kinds: map[Kind]bool{}, sample: max(uint64(options.Sample), 1)}
+	for _, kind := range kinds {
+		writer.kinds[kind] = true
+	}
+
+	writer.write(Header{Record: "header", Version: Version, File: file, Kinds: kinds, Sample: uint(writer.sample)})
+	return writer
+}
+
+func (w *Writer) write(line interface{}) {
+	if w.err != nil {
+		return
+	}
+
+	encoded, err := json.Marshal(line)
+	if err == nil {
+		_, err = w.out.Write(append(encoded, 10))
+	}
+	w.err = err
+}
+
+func (w *Writer) Trace(event Event) {
+	w.steps++
+	if !w.kinds[event.Kind] {
+		return
+	}
+
+	w.matched++
+	if (w.matched-1)%w.sample != 0 {
+		return
+	}
+
+	w.recorded++
+	w.write(event)
+}
+
+// Close ends the trace file with the exit status of the program and the
+// error it failed with, if any.
+func (w *Writer) Close(status int, failure error) error {
+	footer := Footer{Record: "footer", Status: status, Steps: w.steps, Recorded: w.recorded}
+	if failure != nil {
+		footer.Error = failure.Error()
+	}
+
+	w.write(footer)
+	if w.err != nil {
+		return w.err
+	}
+	return w.out.Flush()
+}
+
+// ErrTruncated is returned by a Reader when the trace file ends without a
+// footer, like when the program was killed.
+var ErrTruncated = errors.New("the trace ends without a footer")
+
+// Reader reads a trace file event by event.
+type Reader struct {
+	scanner *bufio.Scanner
+	header  Header
+	footer  *Footer
+}
+
+// NewReader reads the header of a trace file.
+func NewReader(r io.Reader) (*Reader, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+
+	reader := &Reader{scanner: scanner}
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("the trace is empty")
+	}
+
+	if err := json.Unmarshal(scanner.Bytes(), &reader.header); err != nil || reader.header.Record != "header" {
+		return nil, errors.New("the trace does not start with a header")
+	}
+	if reader.header.Version != Version {
+		return nil, fmt.Errorf("the trace is version %d, only version %d can be read", reader.header.Version, Version)
+	}
+
+	return reader, nil
+}
+
+func (r *Reader) Header() Header {
+	return r.header
+}
+
+// Footer is the last line of the trace, it is nil until Next has returned
+// io.EOF.
+func (r *Reader) Footer() *Footer {
+	return r.footer
+}
+
+// Next reads the next event, it returns io.EOF once it reaches the footer.
+func (r *Reader) Next() (Event, error) {
+	if r.footer != nil {
+		return Event{}, io.EOF
+	}
+
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return Event{}, err
+		}
+		return Event{}, ErrTruncated
+	}
+
+	line := r.scanner.Bytes()
+
+	var record struct {
+		Record string `json:"record"`
+	}
+	if err := json.Unmarshal(line, &record); err != nil {
+		return Event{}, err
+	}
+
+	if record.Record == "footer" {
+		r.footer = &Footer{}
+		if err := json.Unmarshal(line, r.footer); err != nil {
+			return Event{}, err
+		}
+		return Event{}, io.EOF
+	}
+
+	var event Event
+	err := json.Unmarshal(line, &event)
+	return event, err
+}
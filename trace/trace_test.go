@@ -0,0 +1,92 @@
+package trace
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+
+	"github.com/CanPacis/brainfuck-interpreter/lexer"
+)
+
+func TestParseFilter(t *testing.T) {
+	kinds, err := ParseFilter([]string{"io", "loops", "output"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(kinds, []Kind{Input, Loop, Output, SwitchIO}) {
+		t.Errorf("Incorrect kinds %v", kinds)
+	}
+
+	if _, err := ParseFilter([]string{"jump"}); err == nil {
+		t.Error("Expected an unknown filter to fail")
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := NewWriter(&out, "test.bfi", Options{Kinds: []Kind{Increment}, Sample: 2})
+
+	for step := uint64(1); step <= 6; step++ {
+		kind := Increment
+		if step%3 == 0 {
+			kind = Output
+		}
+		w.Trace(Event{Step: step, Kind: kind, Position: lexer.Position{Line: 1, Column: uint(step)}, After: uint32(step)})
+	}
+	if err := w.Close(1, errors.New("failed")); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header := r.Header(); header.File != "test.bfi" || header.Sample != 2 {
+		t.Errorf("Incorrect header %+v", header)
+	}
+
+	// the increments are steps 1, 2, 4 and 5, every second one is kept
+	steps := []uint64{}
+	for {
+		event, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event.Column != uint(event.Step) || event.After != uint32(event.Step) {
+			t.Errorf("Incorrect event %+v", event)
+		}
+		steps = append(steps, event.Step)
+	}
+
+	if !slices.Equal(steps, []uint64{1, 4}) {
+		t.Errorf("Expected the steps [1 4] found %v", steps)
+	}
+
+	footer := r.Footer()
+	if footer == nil || footer.Status != 1 || footer.Error != "failed" || footer.Steps != 6 || footer.Recorded != 2 {
+		t.Errorf("Incorrect footer %+v", footer)
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	var out bytes.Buffer
+	w := NewWriter(&out, "test.bfi", Options{})
+	w.Trace(Event{Step: 1, Kind: Increment})
+	w.out.Flush()
+
+	r, err := NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); err != ErrTruncated {
+		t.Errorf("Expected the trace to be truncated found %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/CanPacis/brainfuck-interpreter/debugger"
+)
+
+const (
+	clearScreen = "\x1b[H\x1b[2J"
+	highlight   = "\x1b[7m"
+	bold        = "\x1b[1m"
+	dim         = "\x1b[2m"
+	reset       = "\x1b[0m"
+
+	// sourceContext is how many lines are shown around the current one
+	sourceContext = 5
+	// tapeContext is how many cells are shown on each side of the cursor
+	tapeContext = 8
+	outputLines = 6
+)
+
+// view is everything drawn on the screen.
+type view struct {
+	meta    debugger.MetaData
+	state   *debugger.State
+	output  string
+	message string
+	exited  *debugger.Exit
+}
+
+func (v view) render() string {
+	var b strings.Builder
+	b.WriteString(clearScreen)
+
+	fmt.Fprintf(&b, "%s%s%s", bold, v.meta.FileName, reset)
+	switch {
+	case v.exited != nil:
+		fmt.Fprintf(&b, "  exited with status %d\n\n", v.exited.Code)
+	case v.state == nil:
+		b.WriteString("  running\n\n")
+	default:
+		fmt.Fprintf(&b, "  %s at %d:%d, step %d", v.state.Reason, v.state.Statement.Line, v.state.Statement.Column, v.state.Replay.Step)
+		if v.state.Replay.Step < v.state.Replay.Live {
+			fmt.Fprintf(&b, " of %d (past)", v.state.Replay.Live)
+		}
+		b.WriteString("\n\n")
+		v.source(&b)
+		b.WriteString("\n")
+		v.tape(&b)
+		if watch := v.state.Watch; watch != nil {
+			fmt.Fprintf(&b, "\ncell %d changed from %d to %d\n", watch.Cell, watch.Old, watch.New)
+		}
+		b.WriteString("\n")
+	}
+
+	fmt.Fprintf(&b, "%soutput%s\n", dim, reset)
+	lines := strings.Split(strings.TrimSuffix(v.output, "\n"), "\n")
+	if len(lines) > outputLines {
+		lines = lines[len(lines)-outputLines:]
+	}
+	for _, line := range lines {
+		fmt.Fprintf(&b, "  %s\n", line)
+	}
+
+	if len(v.message) > 0 {
+		fmt.Fprintf(&b, "\n%s\n", v.message)
+	}
+	if v.state != nil && len(v.state.Error) > 0 {
+		fmt.Fprintf(&b, "\nerror: %s\n", v.state.Error)
+	}
+
+	return b.String()
+}
+
+// source draws the lines around the current statement with it highlighted
+// and breakpoints marked in the gutter.
+func (v view) source(b *strings.Builder) {
+	lines := strings.Split(v.meta.Content, "\n")
+	current := int(v.state.Statement.Line)
+
+	breakpoints := map[int]bool{}
+	for _, breakpoint := range v.state.Breakpoints {
+		breakpoints[int(breakpoint.Line)] = true
+	}
+
+	for line := max(1, current-sourceContext); line <= min(len(lines), current+sourceContext); line++ {
+		text := lines[line-1]
+		marker := " "
+		if breakpoints[line] {
+			marker = "*"
+		}
+
+		if line == current {
+			column := int(v.state.Statement.Column) - 1
+			if column >= 0 && column < len(text) {
+				text = text[:column] + highlight + text[column:column+1] + reset + text[column+1:]
+			}
+			fmt.Fprintf(b, "%s%s%4d%s %s\n", marker, bold, line, reset, text)
+		} else {
+			fmt.Fprintf(b, "%s%s%4d%s %s\n", marker, dim, line, reset, text)
+		}
+	}
+}
+
+// tape draws the cells around the cursor.
+func (v view) tape(b *strings.Builder) {
+	cursor := int(v.state.Cursor)
+	start := max(0, cursor-tapeContext)
+
+	var indexes, values, characters strings.Builder
+	for cell := start; cell <= cursor+tapeContext; cell++ {
+		var value uint32
+		if cell < len(v.state.Tape) {
+			value = v.state.Tape[cell]
+		}
+
+		character := " "
+		if value >= 32 && value < 127 {
+			character = string(rune(value))
+		}
+
+		index := fmt.Sprintf("%5d", cell)
+		cellValue := fmt.Sprintf("%5d", value)
+		if cell == cursor {
+			index = bold + index + reset
+			cellValue = highlight + cellValue + reset
+		}
+
+		indexes.WriteString(index)
+		values.WriteString(cellValue)
+		characters.WriteString(fmt.Sprintf("%5s", character))
+	}
+
+	fmt.Fprintf(b, "%scell%s %s\n", dim, reset, indexes.String())
+	fmt.Fprintf(b, "     %s\n", values.String())
+	fmt.Fprintf(b, "     %s\n", characters.String())
+}
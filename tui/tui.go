@@ -0,0 +1,427 @@
+// Package tui is a terminal debugger. It runs a program in process and
+// drives it over the debugger protocol, one command per line.
+package tui
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/CanPacis/brainfuck-interpreter/debugger"
+	"github.com/CanPacis/brainfuck-interpreter/engine"
+)
+
+const help = `commands:
+  s, step               step into the next statement
+  n, next               step over loops
+  o, out                step out of the current loop
+  c, continue           run until a breakpoint
+  back                  step back in the history
+  rc, reverse           continue backwards to a breakpoint
+  jump STEP             go to a step in the history
+  b LINE[:COL] [COND]   set a breakpoint, like 'b 3 cell[2] == 65'
+  clear LINE[:COL]      clear a breakpoint
+  w CELL[..END]         watch cells for writes
+  unwatch CELL[..END]   stop watching cells
+  set CELL VALUE        assign a value to a cell
+  move CELL             move the cursor
+  p EXPR                print an expression, like 'p c[cursor-1] + c[4]'
+  x START [COUNT] [FMT] show cells as decimal, hex or ascii
+  find TEXT             find text on the tape
+  input TEXT            send a line to the program's input
+  q, quit               stop the program
+an empty line repeats the last command`
+
+// output collects what the program writes so it can be drawn.
+type output struct {
+	lock   sync.Mutex
+	buffer strings.Builder
+}
+
+func (o *output) Write(p []byte) (int, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.buffer.Write(p)
+}
+
+func (o *output) String() string {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.buffer.String()
+}
+
+type session struct {
+	out     io.Writer
+	encoder *json.Encoder
+	input   *io.PipeWriter
+	output  *output
+	view    view
+	last    string
+	// requests counts the ids of the operations sent and paused is the
+	// state the program was last paused at, an operation that fails leaves
+	// it there
+	requests uint64
+	paused   *debugger.State
+}
+
+// Run debugs the program at path with commands read from in, drawing to
+// out. It returns the exit status of the program.
+func Run(path string, options engine.EngineOptions, in io.Reader, out io.Writer) (int, error) {
+	directory, err := os.MkdirTemp("", "bf-debug")
+	if err != nil {
+		return 1, err
+	}
+	defer os.RemoveAll(directory)
+
+	stdin, input := io.Pipe()
+	programOutput := &output{}
+
+	options.FilePath = path
+	options.DebugListen = "unix://" + filepath.Join(directory, "debugger.sock")
+	options.DebugNoWait = false
+	options.DebugStopOnEntry = true
+	options.Stdin = stdin
+	options.Stdout = programOutput
+	options.Stderr = programOutput
+
+	e := engine.NewEngine(options)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan int, 1)
+	go func() {
+		status, _ := e.RunContext(ctx)
+		done <- status
+	}()
+
+	connection, err := net.Dial("unix", strings.TrimPrefix(options.DebugListen, "unix://"))
+	if err != nil {
+		// the engine failed before it could listen, it has written why
+		status := <-done
+		fmt.Fprint(out, programOutput.String())
+		return status, nil
+	}
+	defer connection.Close()
+
+	s := &session{
+		out:     out,
+		encoder: json.NewEncoder(connection),
+		input:   input,
+		output:  programOutput,
+	}
+
+	frames := make(chan []byte)
+	go func() {
+		reader := bufio.NewReader(connection)
+		for {
+			line, err := reader.ReadBytes(10)
+			if err != nil {
+				close(frames)
+				return
+			}
+			frames <- line
+		}
+	}()
+
+	commands := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			commands <- scanner.Text()
+		}
+		close(commands)
+	}()
+
+	// commands wait for the program to pause, except for input and quit
+	pending := []string{}
+	quit := func() int {
+		cancel()
+		input.Close()
+		connection.Close()
+		return <-done
+	}
+
+	for {
+		if s.view.state != nil && len(pending) > 0 {
+			command := pending[0]
+			pending = pending[1:]
+			if s.execute(command) {
+				return quit(), nil
+			}
+			continue
+		}
+
+		select {
+		case frame, ok := <-frames:
+			if !ok {
+				// the program ended without saying how
+				status := <-done
+				s.receive([]byte(fmt.Sprintf(`{"operation":"exit","code":%d}`, status)))
+				return status, nil
+			}
+			if exited := s.receive(frame); exited {
+				return <-done, nil
+			}
+		case command, ok := <-commands:
+			if !ok {
+				// the program sees the end of input too, so it can't block
+				// the commands left from pausing
+				commands = nil
+				input.Close()
+				pending = append(pending, "quit")
+				continue
+			}
+
+			switch name, _, _ := strings.Cut(strings.TrimSpace(command), " "); name {
+			case "input", "q", "quit":
+				if s.execute(command) {
+					return quit(), nil
+				}
+			default:
+				pending = append(pending, command)
+			}
+		}
+	}
+}
+
+// receive handles a frame from the program and reports whether it exited.
+func (s *session) receive(frame []byte) bool {
+	var operation struct {
+		Operation debugger.ServerAction `json:"operation"`
+	}
+	json.Unmarshal(frame, &operation)
+
+	switch operation.Operation {
+	case debugger.HelloAction:
+		var hello debugger.Hello
+		json.Unmarshal(frame, &hello)
+		if hello.Id == 0 {
+			s.send(debugger.HelloOperation{Operation: debugger.Handshake, Id: s.requests + 1, Version: debugger.ProtocolVersion})
+		}
+		return false
+	case debugger.DiscloseMetaData:
+		json.Unmarshal(frame, &s.view.meta)
+		return false
+	case debugger.DiscloseDebugState:
+		var state debugger.State
+		json.Unmarshal(frame, &state)
+		s.view.state, s.paused = &state, &state
+	case debugger.ErrorAction:
+		var response debugger.ErrorResponse
+		json.Unmarshal(frame, &response)
+		s.view.state = s.paused
+		s.view.message = "error: " + response.Message
+	case debugger.EvaluationAction:
+		var evaluation debugger.Evaluation
+		json.Unmarshal(frame, &evaluation)
+		s.view.state = s.paused
+		s.view.message = fmt.Sprintf("%s = %d", evaluation.Expression, evaluation.Value)
+	case debugger.TapeAction:
+		var tape debugger.TapeRange
+		json.Unmarshal(frame, &tape)
+		s.view.state = s.paused
+		s.view.message = fmt.Sprintf("cells %d..%d: %s", tape.Start, tape.Start+uint(len(tape.Cells))-1, tape.Text)
+	case debugger.SearchResultAction:
+		var result debugger.SearchResult
+		json.Unmarshal(frame, &result)
+		s.view.state = s.paused
+		s.view.message = "not found"
+		if len(result.Matches) > 0 {
+			s.view.message = fmt.Sprintf("found at %v", result.Matches)
+		}
+		if result.More {
+			s.view.message += " and more"
+		}
+	case debugger.ExitAction:
+		var exit debugger.Exit
+		json.Unmarshal(frame, &exit)
+		s.view.state = nil
+		s.view.exited = &exit
+	}
+
+	s.draw()
+	return s.view.exited != nil
+}
+
+func (s *session) draw() {
+	s.view.output = s.output.String()
+	fmt.Fprint(s.out, s.view.render())
+	if s.view.exited == nil && s.view.state != nil {
+		fmt.Fprint(s.out, "(bf) ")
+	}
+	s.view.message = ""
+}
+
+// execute runs a command and reports whether the session should end.
+// Commands that send an operation leave the program running until it
+// shares its state again.
+func (s *session) execute(command string) bool {
+	command = strings.TrimSpace(command)
+	if len(command) == 0 {
+		command = s.last
+	}
+	s.last = command
+
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return false
+	}
+	arguments := fields[1:]
+
+	var operation interface{}
+	var err error
+	id := s.requests + 1
+
+	switch fields[0] {
+	case "s", "step":
+		operation = debugger.PlayerOperation{Operation: debugger.Step, Id: id}
+	case "n", "next":
+		operation = debugger.PlayerOperation{Operation: debugger.StepOver, Id: id}
+	case "o", "out":
+		operation = debugger.PlayerOperation{Operation: debugger.StepOut, Id: id}
+	case "c", "continue":
+		operation = debugger.PlayerOperation{Operation: debugger.Resume, Id: id}
+	case "back":
+		operation = debugger.PlayerOperation{Operation: debugger.StepBack, Id: id}
+	case "rc", "reverse":
+		operation = debugger.PlayerOperation{Operation: debugger.ReverseContinue, Id: id}
+	case "jump":
+		var step uint64
+		if step, err = number(arguments, 0); err == nil {
+			operation = debugger.JumpOperation{Operation: debugger.Jump, Id: id, Step: step}
+		}
+	case "b", "break", "clear":
+		var line, column uint
+		if line, column, err = position(arguments); err == nil {
+			action := debugger.SetBreakpoint
+			if fields[0] == "clear" {
+				action = debugger.ClearBreakpoint
+			}
+			operation = debugger.BreakpointOperation{Operation: action, Id: id, Line: line, Column: column, Condition: strings.Join(arguments[1:], " ")}
+		}
+	case "w", "watch", "unwatch":
+		var start, end uint
+		if start, end, err = cells(arguments); err == nil {
+			action := debugger.SetWatchpoint
+			if fields[0] == "unwatch" {
+				action = debugger.ClearWatchpoint
+			}
+			operation = debugger.WatchpointOperation{Operation: action, Id: id, Start: start, End: end}
+		}
+	case "set":
+		var cell, value uint64
+		if cell, err = number(arguments, 0); err == nil {
+			if value, err = number(arguments, 1); err == nil {
+				operation = debugger.AssignOperation{Operation: debugger.Assign, Id: id, Cell: uint(cell), Value: uint32(value)}
+			}
+		}
+	case "move":
+		var cell uint64
+		if cell, err = number(arguments, 0); err == nil {
+			operation = debugger.MoveOperation{Operation: debugger.Move, Id: id, Cell: uint(cell)}
+		}
+	case "p", "print":
+		_, expression, _ := strings.Cut(command, " ")
+		operation = debugger.EvaluateOperation{Operation: debugger.Evaluate, Id: id, Expression: expression}
+	case "x":
+		var start, count uint64
+		count = 1
+		if start, err = number(arguments, 0); err == nil && len(arguments) > 1 {
+			count, err = number(arguments, 1)
+		}
+		format := debugger.DecimalFormat
+		if len(arguments) > 2 {
+			format = debugger.TapeFormat(arguments[2])
+		}
+		operation = debugger.ReadTapeOperation{Operation: debugger.ReadTape, Id: id, Start: uint(start), Count: uint(count), Format: format}
+	case "find":
+		_, text, _ := strings.Cut(command, " ")
+		operation = debugger.SearchOperation{Operation: debugger.SearchTape, Id: id, Text: text}
+	case "input":
+		_, text, _ := strings.Cut(command, " ")
+		go s.input.Write([]byte(text + "\n"))
+		return false
+	case "q", "quit":
+		return true
+	case "h", "help":
+		s.view.message = help
+		s.draw()
+		return false
+	default:
+		err = fmt.Errorf("unknown command '%s', try 'help'", fields[0])
+	}
+
+	if err != nil {
+		s.view.message = err.Error()
+		s.draw()
+		return false
+	}
+
+	s.view.state = nil
+	s.send(operation)
+	return false
+}
+
+// send sends an operation made with the next request id.
+func (s *session) send(operation interface{}) {
+	s.requests++
+	s.encoder.Encode(operation)
+}
+
+func number(arguments []string, index int) (uint64, error) {
+	if index >= len(arguments) {
+		return 0, fmt.Errorf("missing a number")
+	}
+
+	value, err := strconv.ParseUint(arguments[index], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' is not a number", arguments[index])
+	}
+	return value, nil
+}
+
+// position parses LINE or LINE:COLUMN.
+func position(arguments []string) (uint, uint, error) {
+	if len(arguments) == 0 {
+		return 0, 0, fmt.Errorf("missing a line")
+	}
+
+	line, column, _ := strings.Cut(arguments[0], ":")
+	if len(column) == 0 {
+		column = "0"
+	}
+
+	l, err := number([]string{line}, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	c, err := number([]string{column}, 0)
+	return uint(l), uint(c), err
+}
+
+// cells parses CELL or CELL..END.
+func cells(arguments []string) (uint, uint, error) {
+	if len(arguments) == 0 {
+		return 0, 0, fmt.Errorf("missing a cell")
+	}
+
+	start, end, _ := strings.Cut(arguments[0], "..")
+	if len(end) == 0 {
+		end = start
+	}
+
+	s, err := number([]string{start}, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	e, err := number([]string{end}, 0)
+	return uint(s), uint(e), err
+}
@@ -0,0 +1,42 @@
+package tui
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/CanPacis/brainfuck-interpreter/engine"
+)
+
+func TestRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "echo.bfi")
+	os.WriteFile(path, []byte("++++++++[>++++++++<-]>+.\n,.\n"), 0o644)
+
+	commands := strings.Join([]string{
+		"b 2",
+		"c",
+		"input z",
+		"s",
+		"set 1 66",
+		"set 1000000 1",
+		"p c[0] + c[1]",
+		"x 0 2 ascii",
+		"find B",
+		"bogus",
+		"c",
+	}, "\n")
+
+	var out strings.Builder
+	status, err := Run(path, engine.EngineOptions{}, strings.NewReader(commands), &out)
+	if err != nil || status != 0 {
+		t.Fatalf("Expected the program to finish found %d with %v", status, err)
+	}
+
+	screen := out.String()
+	for _, expected := range []string{"entry at 1:1", "breakpoint at 2:1", "step at 2:2", "unknown command 'bogus'", "error: cell 1000000 is outside the tape", "c[0] + c[1] = 66", "cells 0..1: .B", "found at [1]", "exited with status 0", "  AB"} {
+		if !strings.Contains(screen, expected) {
+			t.Errorf("Expected the screen to show %q", expected)
+		}
+	}
+}